@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"mylang/dap"
+	"net"
+	"os"
+)
+
+func main() {
+	listen := flag.String("listen", "", "serve on this TCP address (e.g. 127.0.0.1:4711) instead of stdio")
+	flag.Parse()
+
+	if *listen == "" {
+		if err := dap.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		// クライアントごとにコンパイラとRuntimeを持つので同時に相手にできる
+		go func() {
+			defer conn.Close()
+			if err := dap.NewServer(conn, conn).Serve(); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"mylang/compiler"
+	"mylang/runtime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sourceExt   = ".my"
+	asmExt      = ".s"
+	bytecodeExt = ".bc"
+)
+
+// load 入力を拡張子で見分けてオブジェクトファイルにする
+// ディレクトリとfile.myはコンパイルし，file.sはアセンブルし，file.bcはそのまま読む
+func load(path string, opts ...compiler.Option) (*runtime.ObjectFile, int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, exitNoInput, err
+	}
+	ext := filepath.Ext(path)
+	if info.IsDir() || ext == sourceExt {
+		return compile(path, opts...)
+	}
+	if ext != asmExt && ext != bytecodeExt {
+		return nil, exitUsage, fmt.Errorf("unknown file type: %s: want %s, %s, %s or a directory", path, sourceExt, asmExt, bytecodeExt)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, exitNoInput, err
+	}
+	var obj *runtime.ObjectFile
+	if ext == asmExt {
+		obj, err = runtime.Assemble(string(src))
+	} else {
+		obj, err = runtime.DecodeObjectFile(bytes.NewReader(src))
+	}
+	if err != nil {
+		return nil, exitCompile, fmt.Errorf("%s: %w", path, err)
+	}
+	return obj, exitOK, nil
+}
+
+// compile ディレクトリならimportをたどってまとめて，ファイルならそれだけをコンパイルする
+func compile(path string, opts ...compiler.Option) (*runtime.ObjectFile, int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, exitNoInput, err
+	}
+	if info.IsDir() {
+		prog, debug, err := compiler.CompileDirDebug(path, opts...)
+		if err != nil {
+			return nil, exitCompile, err
+		}
+		return runtime.NewObjectFile(prog, debug.Symbols), exitOK, nil
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, exitNoInput, err
+	}
+	obj, err := compiler.CompileSource(string(src), opts...)
+	if err != nil {
+		return nil, exitCompile, fmt.Errorf("%s: %w", path, err)
+	}
+	return obj, exitOK, nil
+}
+
+// optimizeFlag -O0で最適化を止められるようにする
+func optimizeFlag(fs *flag.FlagSet) func() []compiler.Option {
+	o0 := fs.Bool("O0", false, "disable optimizations such as constant folding")
+	return func() []compiler.Option {
+		if *o0 {
+			return []compiler.Option{compiler.WithoutOptimization()}
+		}
+		return nil
+	}
+}
+
+// definesMain startupが呼ぶmain(label(0))が定義されているか
+func definesMain(obj *runtime.ObjectFile) bool {
+	for _, op := range obj.Program {
+		if op.GetKind() != runtime.OP_DEF_LABEL {
+			continue
+		}
+		if params := op.Params(); len(params) == 1 && params[0].GetKind() == runtime.OBJ_LABEL && params[0].GetData() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// outputPath -oがなければ入力の拡張子をextにする
+func outputPath(out, in, ext string) string {
+	if out != "" {
+		return out
+	}
+	in = filepath.Clean(in)
+	return strings.TrimSuffix(in, filepath.Ext(in)) + ext
+}
+
+func writeObject(path string, obj *runtime.ObjectFile) (int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return exitOutput, err
+	}
+	if err := obj.Encode(f); err != nil {
+		_ = f.Close()
+		return exitOutput, err
+	}
+	if err := f.Close(); err != nil {
+		return exitOutput, err
+	}
+	return exitOK, nil
+}
+
+func buildCommand(args []string) (int, error) {
+	fs := newFlagSet("build", "<file.my|dir>")
+	out := fs.String("o", "", "output bytecode file (default: input with .bc)")
+	compilerOptions := optimizeFlag(fs)
+	if code, ok := parseFlags(fs, args, 1); !ok {
+		return code, nil
+	}
+	obj, code, err := compile(fs.Arg(0), compilerOptions()...)
+	if err != nil {
+		return code, err
+	}
+	return writeObject(outputPath(*out, fs.Arg(0), bytecodeExt), obj)
+}
+
+func asmCommand(args []string) (int, error) {
+	fs := newFlagSet("asm", "<file.s>")
+	out := fs.String("o", "", "output bytecode file (default: input with .bc)")
+	if code, ok := parseFlags(fs, args, 1); !ok {
+		return code, nil
+	}
+	src, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return exitNoInput, err
+	}
+	obj, err := runtime.Assemble(string(src))
+	if err != nil {
+		return exitCompile, fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	return writeObject(outputPath(*out, fs.Arg(0), bytecodeExt), obj)
+}
+
+func disasmCommand(args []string) (int, error) {
+	fs := newFlagSet("disasm", "<file.bc>")
+	if code, ok := parseFlags(fs, args, 1); !ok {
+		return code, nil
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return exitNoInput, err
+	}
+	defer f.Close()
+	obj, err := runtime.DecodeObjectFile(f)
+	if err != nil {
+		return exitCompile, fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	fmt.Println(runtime.Disassemble(obj))
+	return exitOK, nil
+}
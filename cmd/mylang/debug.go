@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mylang/runtime"
+	"strconv"
+	"strings"
+)
+
+const debugHelp = `commands:
+  b, break [pc|label name|label(n)]  set breakpoint, or show breakpoints
+  d, delete <pc>                     delete breakpoint
+  s, step                            execute one operation
+  n, next                            step over CALL
+  o, out                             run until the current function returns
+  c, continue                        run until breakpoint or exit
+  l, list [n]                        show operations around pc
+  r, regs                            show registers
+  stack                              show stack
+  mem <addr> [count]                 show memory
+  set reg <name> <value>             modify register
+  set stack <index> <value>          modify stack
+  set mem <addr> <value>             modify memory
+  h, help                            show this help
+  q, quit                            quit`
+
+// debugREPL 1行ずつコマンドを読んでデバッガを動かす
+func debugREPL(d *runtime.Debugger, symbols map[string]int, in io.Reader, out io.Writer) error {
+	names := make(map[int]string)
+	for name, no := range symbols {
+		names[no] = name
+	}
+	if err := d.Start(); err != nil {
+		return err
+	}
+	printLocation(d, out)
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "(debug) ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		var err error
+		switch cmd {
+		case "q", "quit":
+			return nil
+		case "h", "help":
+			fmt.Fprintln(out, debugHelp)
+		case "b", "break":
+			err = setBreakpoint(d, symbols, args, out)
+		case "d", "delete":
+			var pc int
+			if pc, err = intArg(args, 0); err == nil {
+				d.ClearBreakpoint(pc)
+			}
+		case "s", "step":
+			err = run(d, out, d.Step)
+		case "n", "next":
+			err = run(d, out, d.StepOver)
+		case "o", "out":
+			err = run(d, out, d.StepOut)
+		case "c", "continue":
+			err = run(d, out, d.Continue)
+		case "l", "list":
+			n := 5
+			if len(args) != 0 {
+				n, err = intArg(args, 0)
+			}
+			if err == nil {
+				list(d, names, n, out)
+			}
+		case "r", "regs":
+			for kind, obj := range d.Registers() {
+				fmt.Fprintf(out, "%-16s %v\n", runtime.RegisterKind(kind).String(), obj)
+			}
+		case "stack":
+			for i, obj := range d.Stack() {
+				fmt.Fprintf(out, "%4d: %v\n", i, obj)
+			}
+		case "mem":
+			err = showMemory(d, args, out)
+		case "set":
+			err = set(d, args)
+		default:
+			err = fmt.Errorf("unknown command: %s: type help", cmd)
+		}
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+}
+
+func run(d *runtime.Debugger, out io.Writer, f func() error) error {
+	if d.Exited() {
+		return fmt.Errorf("program has exited")
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	printLocation(d, out)
+	return nil
+}
+
+func printLocation(d *runtime.Debugger, out io.Writer) {
+	if d.Exited() {
+		fmt.Fprintf(out, "exited: status=%v\n", d.Register(runtime.REG_STATUS))
+		return
+	}
+	op, _ := d.Operation(d.PC())
+	fmt.Fprintf(out, "pc=%d depth=%d: %v\n", d.PC(), d.Depth(), op)
+}
+
+func list(d *runtime.Debugger, names map[int]string, n int, out io.Writer) {
+	breakpoints := make(map[int]bool)
+	for _, pc := range d.Breakpoints() {
+		breakpoints[pc] = true
+	}
+	for pc := d.PC() - n; pc <= d.PC()+n; pc++ {
+		op, ok := d.Operation(pc)
+		if !ok {
+			continue
+		}
+		mark := "  "
+		if breakpoints[pc] {
+			mark = "* "
+		}
+		if pc == d.PC() {
+			mark = mark[:1] + ">"
+		}
+		label := ""
+		if op.GetKind() == runtime.OP_DEF_LABEL {
+			if name, ok := names[op.Labels()[0]]; ok {
+				label = "  // " + name
+			}
+		}
+		fmt.Fprintf(out, "%s%4d  %v%s\n", mark, pc, op, label)
+	}
+}
+
+func intArg(args []string, i int) (int, error) {
+	if len(args) <= i {
+		return 0, fmt.Errorf("missing argument")
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil {
+		return 0, fmt.Errorf("not a number: %s", args[i])
+	}
+	return n, nil
+}
+
+func setBreakpoint(d *runtime.Debugger, symbols map[string]int, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		for _, pc := range d.Breakpoints() {
+			op, _ := d.Operation(pc)
+			fmt.Fprintf(out, "%4d  %v\n", pc, op)
+		}
+		return nil
+	}
+	if pc, err := strconv.Atoi(args[0]); err == nil {
+		return d.SetBreakpoint(pc)
+	}
+	if no, ok := symbols[args[0]]; ok {
+		return d.SetBreakpointAtLabel(no)
+	}
+	if strings.HasPrefix(args[0], "label(") && strings.HasSuffix(args[0], ")") {
+		no, err := strconv.Atoi(args[0][len("label(") : len(args[0])-1])
+		if err == nil {
+			return d.SetBreakpointAtLabel(no)
+		}
+	}
+	return fmt.Errorf("unknown location: %s", args[0])
+}
+
+func showMemory(d *runtime.Debugger, args []string, out io.Writer) error {
+	addr, err := intArg(args, 0)
+	if err != nil {
+		return err
+	}
+	count := 1
+	if 1 < len(args) {
+		if count, err = intArg(args, 1); err != nil {
+			return err
+		}
+	}
+	for i := addr; i < addr+count && i < d.MemorySize(); i++ {
+		obj, err := d.Memory(i)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%4d: %v\n", i, obj)
+	}
+	return nil
+}
+
+func set(d *runtime.Debugger, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: set reg|stack|mem <where> <value>")
+	}
+	value, err := runtime.ParseOperand(args[2])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "reg":
+		kind, ok := runtime.LookupRegisterKind(args[1])
+		if !ok {
+			return fmt.Errorf("unknown register: %s", args[1])
+		}
+		return d.SetRegister(kind, value)
+	case "stack":
+		i, err := intArg(args, 1)
+		if err != nil {
+			return err
+		}
+		return d.SetStack(i, value)
+	case "mem":
+		addr, err := intArg(args, 1)
+		if err != nil {
+			return err
+		}
+		return d.SetMemory(addr, value)
+	default:
+		return fmt.Errorf("usage: set reg|stack|mem <where> <value>")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"mylang/runtime"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// runtimeFlags runとreplで共通の実行時の設定
+type runtimeFlags struct {
+	stack   int
+	memory  int
+	trace   string
+	fuel    int
+	timeout time.Duration
+}
+
+func (f *runtimeFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&f.stack, "stack", 1024, "stack size")
+	fs.IntVar(&f.memory, "memory", 1024, "memory size")
+	fs.StringVar(&f.trace, "trace", "", "trace executed operations to stderr: text or json")
+	fs.IntVar(&f.fuel, "fuel", 0, "maximum number of operations per run, 0 means no limit")
+	fs.DurationVar(&f.timeout, "timeout", 0, "maximum time per run, 0 means no limit")
+}
+
+func (f *runtimeFlags) options() ([]runtime.Option, error) {
+	opts := []runtime.Option{runtime.WithFuel(f.fuel)}
+	switch f.trace {
+	case "":
+	case "text":
+		opts = append(opts, runtime.WithHook(runtime.NewTextTracer(os.Stderr)))
+	case "json":
+		opts = append(opts, runtime.WithHook(runtime.NewJSONTracer(os.Stderr)))
+	default:
+		return nil, fmt.Errorf("unknown trace format: %s", f.trace)
+	}
+	return opts, nil
+}
+
+// context -timeoutが過ぎるかCtrl-Cで止まるcontext
+func (f *runtimeFlags) context() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if f.timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
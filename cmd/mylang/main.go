@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"mylang/runtime"
+	"os"
+)
+
+const usage = `usage: mylang <command> [flags] [arguments]
+
+commands:
+  run      compile or load a program and run it: file.my, directory, file.s or file.bc
+  build    compile file.my or a directory to bytecode
+  asm      assemble file.s to bytecode
+  disasm   print bytecode as assembly
+  repl     evaluate statements and expressions line by line
+
+run "mylang <command> -h" for the flags of each command`
+
+// 終了コード, runが最後まで動いたときはmainの戻り値になる
+const (
+	exitOK        = 0
+	exitUsage     = 64  // 引数やフラグの誤り
+	exitCompile   = 65  // コンパイル，アセンブル，読み込みの失敗
+	exitNoInput   = 66  // 入力ファイルが読めない
+	exitRuntime   = 70  // 実行中のエラー
+	exitOutput    = 73  // 出力ファイルが書けない
+	exitLimit     = 124 // -fuelか-timeoutの上限に達した
+	exitInterrupt = 130 // Ctrl-Cで止めた
+)
+
+var commands = map[string]func(args []string) (int, error){
+	"run":    runCommand,
+	"build":  buildCommand,
+	"asm":    asmCommand,
+	"disasm": disasmCommand,
+	"repl":   replCommand,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mylang: ")
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+	name := os.Args[1]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		fmt.Println(usage)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n%s\n", name, usage)
+		os.Exit(exitUsage)
+	}
+	code, err := cmd(os.Args[2:])
+	if err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: mylang %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags フラグを読んで，引数の数がnargsでなければ使い方を表示する
+// -hなら正常に終わる
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// runtimeExitCode 実行が止まった理由を終了コードにする
+func runtimeExitCode(err error) int {
+	switch {
+	case errors.Is(err, runtime.ErrFuelExhausted), errors.Is(err, context.DeadlineExceeded):
+		return exitLimit
+	case errors.Is(err, context.Canceled):
+		return exitInterrupt
+	default:
+		return exitRuntime
+	}
+}
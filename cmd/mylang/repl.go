@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mylang/compiler"
+	"mylang/runtime"
+	"os"
+	"strings"
+)
+
+const replHelp = `enter statements, expressions or function definitions
+commands:
+  :asm     show operations generated for the last input
+  :regs    show registers
+  :help    show this help
+  :quit    quit`
+
+func replCommand(args []string) (int, error) {
+	fs := newFlagSet("repl", "")
+	var rf runtimeFlags
+	rf.register(fs)
+	compilerOptions := optimizeFlag(fs)
+	if code, ok := parseFlags(fs, args, 0); !ok {
+		return code, nil
+	}
+	opts, err := rf.options()
+	if err != nil {
+		return exitUsage, err
+	}
+	// プログラムのinput()もREPLと同じ入力から読む
+	in := bufio.NewReader(os.Stdin)
+	opts = append(opts, runtime.WithStdin(in), runtime.WithStdout(os.Stdout))
+	r := runtime.NewRuntime(rf.stack, rf.memory, opts...)
+	defer r.Close()
+	if err := repl(r, &rf, compiler.NewSession(compilerOptions()...), in, os.Stdout); err != nil {
+		return exitRuntime, err
+	}
+	return exitOK, nil
+}
+
+// repl 入力を1つずつコンパイルして実行し，式なら値を表示する
+// {が閉じていなければ次の行も同じ入力として読む
+func repl(r *runtime.Runtime, rf *runtimeFlags, s *compiler.Session, in *bufio.Reader, out io.Writer) error {
+	if err := r.Reload(runtime.Program{}); err != nil {
+		return err
+	}
+	init := s.Init()
+	if err := r.AppendObject(init.Object); err != nil {
+		return err
+	}
+	if err := r.Call(context.Background(), init.Label); err != nil {
+		return err
+	}
+
+	var last *runtime.ObjectFile
+	for {
+		src, err := readEntry(in, out)
+		if err == io.EOF {
+			fmt.Fprintln(out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch src = strings.TrimSpace(src); src {
+		case "":
+			continue
+		case ":q", ":quit":
+			return nil
+		case ":help":
+			fmt.Fprintln(out, replHelp)
+			continue
+		case ":asm":
+			if last == nil {
+				fmt.Fprintln(out, "error: no input yet")
+			} else {
+				fmt.Fprintln(out, runtime.Disassemble(last))
+			}
+			continue
+		case ":regs":
+			for kind, obj := range r.Registers() {
+				fmt.Fprintf(out, "%-16s %v\n", runtime.RegisterKind(kind).String(), obj)
+			}
+			continue
+		}
+		if strings.HasPrefix(src, ":") {
+			fmt.Fprintf(out, "error: unknown command: %s: type :help\n", src)
+			continue
+		}
+
+		entry, err := appendEntry(r, s, src)
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+			continue
+		}
+		last = entry.Object
+		if entry.Label < 0 {
+			continue
+		}
+		ctx, cancel := rf.context()
+		err = r.Call(ctx, entry.Label)
+		cancel()
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+			continue
+		}
+		if status := r.Register(runtime.REG_STATUS); entry.Expr && status.GetKind() != runtime.OBJ_NULL {
+			fmt.Fprintln(out, status)
+		}
+	}
+}
+
+// appendEntry 入力をコンパイルしてRuntimeに足す
+// 足せなかったときは，宣言した関数や変数をSessionからも取り消す
+func appendEntry(r *runtime.Runtime, s *compiler.Session, src string) (*compiler.Entry, error) {
+	entry, err := s.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.AppendObject(entry.Object); err != nil {
+		s.Discard()
+		return nil, err
+	}
+	return entry, nil
+}
+
+// readEntry 1つの入力を読む, 入力が終われば io.EOF
+func readEntry(in *bufio.Reader, out io.Writer) (string, error) {
+	fmt.Fprint(out, "> ")
+	src := ""
+	for {
+		line, err := in.ReadString('\n')
+		src += line
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(src) != "" {
+				return src, nil
+			}
+			return "", err
+		}
+		if strings.Count(src, "{") <= strings.Count(src, "}") {
+			return src, nil
+		}
+		fmt.Fprint(out, "... ")
+	}
+}
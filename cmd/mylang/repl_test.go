@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"mylang/compiler"
+	"mylang/runtime"
+	"testing"
+)
+
+func TestAppendEntry_Discard(t *testing.T) {
+	// 同じ入力を別のSessionでコンパイルして，fに振られるラベルを調べる
+	const src = "fn f() int { return 1 }"
+	probe := compiler.NewSession()
+	probe.Init()
+	entry, err := probe.Compile(src)
+	assert.Nil(t, err)
+	label := entry.Object.Program[0].Params()[0].GetData()
+
+	r := runtime.NewRuntime(100, 1024)
+	defer r.Close()
+	assert.Nil(t, r.Reload(runtime.Program{}))
+	s := compiler.NewSession()
+	init := s.Init()
+	assert.Nil(t, r.AppendObject(init.Object))
+	assert.Nil(t, r.Call(context.Background(), init.Label))
+	// 先にラベルを使っておき，足すときにエラーにする
+	assert.Nil(t, r.Append(runtime.Program{runtime.NewDefLabelOp(runtime.NewLabelObject(label)), runtime.NewReturnOp()}))
+
+	_, err = appendEntry(r, s, src)
+	assert.ErrorContains(t, err, "already registered")
+	// 足せなかった関数は後の入力から見えない
+	_, err = appendEntry(r, s, "f()")
+	assert.NotNil(t, err)
+	_, err = s.Compile("fn f() int { return 2 }")
+	assert.Nil(t, err)
+}
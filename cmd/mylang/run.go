@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mylang/runtime"
+	"os"
+	"strings"
+)
+
+func runCommand(args []string) (int, error) {
+	fs := newFlagSet("run", "<file.my|dir|file.s|file.bc>")
+	var rf runtimeFlags
+	rf.register(fs)
+	debug := fs.Bool("debug", false, "start the step debugger")
+	profile := fs.String("profile", "", "write pprof profile to this file")
+	profileText := fs.Bool("profile-text", false, "print profile table to stderr")
+	compilerOptions := optimizeFlag(fs)
+	if code, ok := parseFlags(fs, args, 1); !ok {
+		return code, nil
+	}
+
+	obj, code, err := load(fs.Arg(0), compilerOptions()...)
+	if err != nil {
+		return code, err
+	}
+	if undefined := obj.Undefined(); len(undefined) != 0 {
+		return exitCompile, fmt.Errorf("undefined symbols: %s", strings.Join(undefined, ", "))
+	}
+	if !definesMain(obj) {
+		return exitCompile, fmt.Errorf("function main is not defined")
+	}
+	opts, err := rf.options()
+	if err != nil {
+		return exitUsage, err
+	}
+	var profiler *runtime.Profiler
+	if *profile != "" || *profileText {
+		profiler = runtime.NewProfiler(obj.Symbols)
+		opts = append(opts, runtime.WithHook(profiler))
+	}
+
+	r := runtime.NewRuntime(rf.stack, rf.memory, opts...)
+	defer r.Close()
+	if err := r.LoadObject(obj); err != nil {
+		return exitCompile, fmt.Errorf("failed to load: %w", err)
+	}
+	if err := r.CollectLabel(); err != nil {
+		return exitCompile, fmt.Errorf("failed to load: %w", err)
+	}
+	ctx, cancel := rf.context()
+	defer cancel()
+	if *debug {
+		err = debugREPL(runtime.NewDebugger(r), obj.Symbols, os.Stdin, os.Stdout)
+	} else {
+		err = r.RunContext(ctx)
+	}
+	if profiler != nil {
+		if perr := writeProfile(profiler, *profile, *profileText); perr != nil {
+			log.Print(perr)
+		}
+	}
+	if err != nil {
+		return runtimeExitCode(err), err
+	}
+	// mainの戻り値を終了コードにする, デバッガは途中でやめられるので使わない
+	status := r.Register(runtime.REG_STATUS)
+	if *debug || status == nil || status.GetKind() != runtime.OBJ_INT {
+		return exitOK, nil
+	}
+	return status.GetData() & 0xff, nil
+}
+
+func writeProfile(profiler *runtime.Profiler, path string, text bool) error {
+	if text {
+		if err := profiler.WriteText(os.Stderr); err != nil {
+			return err
+		}
+	}
+	if path == "" {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profiler.WritePprof(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
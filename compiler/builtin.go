@@ -0,0 +1,110 @@
+package compiler
+
+import (
+	"fmt"
+	"mylang/runtime"
+)
+
+// builtins 言語に組み込みの関数, 結果は普通の関数と同じくSTATUSに入れる
+// 同じ名前の関数が定義されていればそちらが優先される
+var builtins = map[string]func(g *Generator, nd *Node) (runtime.Program, error){
+	// input() 標準入力から1行読んで文字列を返す, EOFならnull
+	"input": func(_ *Generator, nd *Node) (runtime.Program, error) {
+		return genRead(nd, "input", runtime.READ_LINE)
+	},
+	// readInt() 標準入力から1行読んで整数として返す, EOFならnull
+	"readInt": func(_ *Generator, nd *Node) (runtime.Program, error) {
+		return genRead(nd, "readInt", runtime.READ_INT)
+	},
+	// yield() 他のスレッドに順番を譲る
+	"yield": func(_ *Generator, nd *Node) (runtime.Program, error) {
+		if count := countArguments(nd); count != 0 {
+			return nil, fmt.Errorf("yield: want 0 arguments, got %d", count)
+		}
+		return runtime.Program{runtime.NewYieldOp()}, nil
+	},
+}
+
+// 引数を式として生成するものはbuiltinsの初期化と循環しないようにinitで登録する
+func init() {
+	builtins["join"] = genJoin
+	builtins["channel"] = genChannel
+	builtins["close"] = genClose
+	builtins["println"] = genPrintln
+}
+
+// join(t) spawnしたスレッドが終わるまで待ち，その関数の戻り値を返す
+func genJoin(g *Generator, nd *Node) (runtime.Program, error) {
+	if count := countArguments(nd); count != 1 {
+		return nil, fmt.Errorf("join: want 1 arguments, got %d", count)
+	}
+	prog, err := g.genExpr(nd.rhs.lhs)
+	if err != nil {
+		return nil, err
+	}
+	return append(prog, runtime.NewJoinOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewRegisterObject(runtime.REG_GENERAL_1))), nil
+}
+
+func countArguments(nd *Node) int {
+	count := 0
+	if nd.rhs != nil {
+		for arg := nd.rhs.lhs; arg != nil; arg = arg.next {
+			count++
+		}
+	}
+	return count
+}
+
+func genRead(nd *Node, name string, mode int) (runtime.Program, error) {
+	if count := countArguments(nd); count != 0 {
+		return nil, fmt.Errorf("%s: want 0 arguments, got %d", name, count)
+	}
+	return runtime.Program{
+		runtime.NewSyscallReadOp(runtime.NewObject(runtime.STD_IN), runtime.NewObject(mode), runtime.NewRegisterObject(runtime.REG_STATUS)),
+	}, nil
+}
+
+// channel(n) n個までためておけるチャネルを作る, nを省くとバッファなし
+func genChannel(g *Generator, nd *Node) (runtime.Program, error) {
+	status := runtime.NewRegisterObject(runtime.REG_STATUS)
+	switch count := countArguments(nd); count {
+	case 0:
+		return runtime.Program{runtime.NewMakeChannelOp(status, runtime.NewObject(0))}, nil
+	case 1:
+		prog, err := g.genExpr(nd.rhs.lhs)
+		if err != nil {
+			return nil, err
+		}
+		return append(prog, runtime.NewMakeChannelOp(status, runtime.NewRegisterObject(runtime.REG_GENERAL_1))), nil
+	default:
+		return nil, fmt.Errorf("channel: want 0 or 1 arguments, got %d", count)
+	}
+}
+
+// close(ch) チャネルを閉じる, 閉じた後も残っている値は受け取れ，空ならnullを受け取る
+func genClose(g *Generator, nd *Node) (runtime.Program, error) {
+	if count := countArguments(nd); count != 1 {
+		return nil, fmt.Errorf("close: want 1 arguments, got %d", count)
+	}
+	prog, err := g.genExpr(nd.rhs.lhs)
+	if err != nil {
+		return nil, err
+	}
+	return append(prog, runtime.NewCloseOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1))), nil
+}
+
+// println(v) 標準出力にvと改行を書く
+func genPrintln(g *Generator, nd *Node) (runtime.Program, error) {
+	if count := countArguments(nd); count != 1 {
+		return nil, fmt.Errorf("println: want 1 arguments, got %d", count)
+	}
+	prog, err := g.genExpr(nd.rhs.lhs)
+	if err != nil {
+		return nil, err
+	}
+	stdout := runtime.NewObject(runtime.STD_OUT)
+	return append(prog,
+		runtime.NewSyscallWriteOp(stdout, runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewSyscallWriteOp(stdout, runtime.NewObject('\n')),
+	), nil
+}
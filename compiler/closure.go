@@ -0,0 +1,83 @@
+package compiler
+
+// paramNames ST_FUNCTION_ARGUMENTSから引数名を取り出す
+func paramNames(nd *Node) []string {
+	names := []string{}
+	if nd == nil {
+		return names
+	}
+	for arg := nd.lhs; arg != nil; arg = arg.next {
+		if id, err := arg.leaf.GetIdent(); err == nil {
+			names = append(names, id)
+		}
+	}
+	return names
+}
+
+// collectLocals 関数本体で宣言されているローカル変数を出現順に返す
+// 関数リテラルの中は別の関数なので見ない
+func collectLocals(nd *Node) []string {
+	names := []string{}
+	var walk func(nd *Node)
+	walk = func(nd *Node) {
+		for ; nd != nil; nd = nd.next {
+			switch nd.kind {
+			case ST_FUNCTION_LITERAL, ST_DEFINE_FUNCTION:
+				continue
+			case ST_DEFINE_VARIABLE:
+				if id, err := nd.lhs.leaf.GetIdent(); err == nil {
+					names = append(names, id)
+				}
+			}
+			walk(nd.lhs)
+			walk(nd.rhs)
+		}
+	}
+	walk(nd)
+	return names
+}
+
+// freeVariables 関数リテラルの中で参照されているが，リテラル自身では束縛していない識別子を出現順に返す
+// 内側の関数リテラルの自由変数も，このリテラルで束縛していなければ自由変数になる
+// グローバルな関数名も含まれるので，どれをキャプチャするかは呼び出し側がスコープを見て決める
+func freeVariables(lit *Node) []string {
+	bound := make(map[string]bool)
+	for _, param := range paramNames(lit.lhs) {
+		bound[param] = true
+	}
+	for _, local := range collectLocals(lit.rhs) {
+		bound[local] = true
+	}
+
+	free := []string{}
+	seen := make(map[string]bool)
+	use := func(name string) {
+		if bound[name] || seen[name] {
+			return
+		}
+		seen[name] = true
+		free = append(free, name)
+	}
+
+	var walk func(nd *Node)
+	walk = func(nd *Node) {
+		for ; nd != nil; nd = nd.next {
+			switch nd.kind {
+			case ST_IDENT:
+				if id, err := nd.leaf.GetIdent(); err == nil {
+					use(id)
+				}
+			case ST_FUNCTION_LITERAL:
+				for _, name := range freeVariables(nd) {
+					use(name)
+				}
+			case ST_PRIMITIVE:
+			default:
+				walk(nd.lhs)
+				walk(nd.rhs)
+			}
+		}
+	}
+	walk(lit.rhs)
+	return free
+}
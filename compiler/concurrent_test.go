@@ -0,0 +1,91 @@
+package compiler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mylang/runtime"
+	"sync"
+	"testing"
+)
+
+var concurrentSources = []struct {
+	src  string
+	want int
+}{
+	{"fn main() int { return 1 + 2 }", 3},
+	{"fn add(a, b) int { return a + b } fn main() int { return add(add(1, 2), 3) }", 6},
+	{"fn main() int { var x = 10 var f = fn() int { return x - 3 } return f() }", 7},
+	{"fn twice(f, x) int { return f(f(x)) } fn main() int { return twice(fn(n) int { return n + 5 }, 1) }", 11},
+}
+
+func compileSource(src string) (runtime.Program, error) {
+	head, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := Parse(head)
+	if err != nil {
+		return nil, err
+	}
+	return Generate(nd)
+}
+
+func runProgram(prog runtime.Program) (int, error) {
+	r := runtime.NewRuntime(64, 64, runtime.WithStdout(io.Discard))
+	if err := r.Reload(prog); err != nil {
+		return 0, err
+	}
+	if err := r.Run(); err != nil {
+		return 0, err
+	}
+	return r.Register(runtime.REG_STATUS).GetData(), nil
+}
+
+// TestCompileAndRun_Parallel go test -raceで，コンパイルと実行が互いに状態を共有していないことを確かめる
+func TestCompileAndRun_Parallel(t *testing.T) {
+	expected := make([]runtime.Program, len(concurrentSources))
+	for i, tt := range concurrentSources {
+		prog, err := compileSource(tt.src)
+		assert.Nil(t, err)
+		expected[i] = prog
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				tt := concurrentSources[(worker+i)%len(concurrentSources)]
+				prog, err := compileSource(tt.src)
+				if !assert.Nil(t, err) {
+					return
+				}
+				assert.Equal(t, expected[(worker+i)%len(concurrentSources)], prog)
+				got, err := runProgram(prog)
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got, tt.src)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRun_SharedProgram(t *testing.T) {
+	// 同じプログラムを別々のRuntimeで同時に動かせる
+	prog, err := compileSource(concurrentSources[3].src)
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				got, err := runProgram(prog)
+				assert.Nil(t, err)
+				assert.Equal(t, concurrentSources[3].want, got)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,43 @@
+package compiler
+
+import "mylang/runtime"
+
+// SourcePos 命令を生成した文のソース上の位置
+type SourcePos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// DebugInfo デバッガがプログラムとソースを対応させるための情報
+type DebugInfo struct {
+	Lines   []SourcePos    // 添字はProgramと同じ, 位置がわからない命令はLineが0
+	Symbols map[string]int // 関数名とラベル番号
+}
+
+// recordPosition 文から生成された命令に文の位置を記録する
+// 内側の文で記録済みの命令はそのままにする
+func (g *Generator) recordPosition(stmt *Node, progs ...runtime.Program) {
+	if stmt.pos == nil {
+		return
+	}
+	pos := SourcePos{File: g.file, Line: stmt.pos.line, Column: stmt.pos.column}
+	for _, prog := range progs {
+		for _, op := range prog {
+			if _, ok := g.positions[op]; !ok {
+				g.positions[op] = pos
+			}
+		}
+	}
+}
+
+func (g *Generator) newDebugInfo(prog runtime.Program) *DebugInfo {
+	info := &DebugInfo{Lines: make([]SourcePos, len(prog)), Symbols: make(map[string]int)}
+	for i, op := range prog {
+		info.Lines[i] = g.positions[op]
+	}
+	for name, no := range g.lc.label {
+		info.Symbols[name] = no
+	}
+	return info
+}
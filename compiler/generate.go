@@ -3,17 +3,36 @@ package compiler
 import (
 	"fmt"
 	"mylang/runtime"
-	"slices"
 )
 
-var curt *Node
-var lc *LabelCollector
+// Generator 構文木からプログラムを生成している状態
+// 1回の生成ごとに作り直すので，別々のGeneratorなら並行に使える
+type Generator struct {
+	curt           *Node
+	lc             *LabelCollector
+	mod            *Module         // 生成中のモジュール
+	allowUndefined bool            // 定義されていない関数をリンク時に解決するシンボルとして扱う
+	sc             *scope          // 生成中の関数のスコープ, 関数の外ならnil
+	literals       runtime.Program // 関数リテラルの本体, 最後にまとめて出力する
+	file           string          // 生成中のソースファイル
+	positions      map[*runtime.Operation]SourcePos
+	externs        map[int]int // externで宣言した関数のラベルと引数の数
+	optimize       bool        // 生成する前にfoldする
+}
+
+func NewGenerator(opts ...Option) *Generator {
+	g := &Generator{optimize: true}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
 
-func nextNode() error {
-	if curt.next == nil {
+func (g *Generator) nextNode() error {
+	if g.curt.next == nil {
 		return fmt.Errorf("end of node")
 	}
-	curt = curt.next
+	g.curt = g.curt.next
 	return nil
 }
 func genPrimitive(nd *Node) (*runtime.Object, error) {
@@ -29,151 +48,639 @@ func genPrimitive(nd *Node) (*runtime.Object, error) {
 	}
 }
 
-func genReturn(nd *Node) (runtime.Program, error) {
+// genEpilogue 関数から抜けるコード
+func (g *Generator) genEpilogue() runtime.Program {
+	if g.sc != nil && g.sc.hasFrame {
+		return runtime.Program{
+			runtime.NewLeaveOp(),
+			runtime.NewReturnOp(),
+		}
+	}
+	return runtime.Program{
+		runtime.NewReturnOp(),
+	}
+}
+
+func (g *Generator) genReturn(nd *Node) (runtime.Program, error) {
 	prog := runtime.Program{}
 	switch retValue := nd.lhs; {
 	case retValue == nil:
-		prog = append(prog, runtime.Program{
-			runtime.NewReturnOp(),
-		}...)
 	case retValue.kind == ST_PRIMITIVE:
 		retObj, err := genPrimitive(retValue)
 		if err != nil {
 			return nil, err
 		}
-		prog = append(prog, runtime.Program{
-			runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), retObj),
-			runtime.NewReturnOp(),
-		}...)
+		prog = append(prog, runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), retObj))
 	default:
-		return nil, fmt.Errorf("genReturn: unsupported value: %s", retValue.String())
+		exprProg, err := g.genExpr(retValue)
+		if err != nil {
+			return nil, err
+		}
+		prog = append(prog, exprProg...)
+		prog = append(prog, runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewRegisterObject(runtime.REG_GENERAL_1)))
+	}
+	prog = append(prog, g.genEpilogue()...)
+	return prog, nil
+}
+
+// genExpr 式を評価してGENERAL_1に入れるコード
+func (g *Generator) genExpr(nd *Node) (runtime.Program, error) {
+	switch nd.kind {
+	case ST_PRIMITIVE:
+		obj, err := genPrimitive(nd)
+		if err != nil {
+			return nil, err
+		}
+		return runtime.Program{
+			runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), obj),
+		}, nil
+	case ST_IDENT:
+		return g.genLoadIdent(nd)
+	case ST_FUNCTION_LITERAL:
+		return g.genFunctionLiteral(nd)
+	case ST_CALL:
+		prog, err := g.genCall(nd)
+		if err != nil {
+			return nil, err
+		}
+		return append(prog, runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewRegisterObject(runtime.REG_STATUS))), nil
+	case ST_SPAWN:
+		return g.genSpawn(nd)
+	case ST_RECV:
+		prog, err := g.genExpr(nd.lhs)
+		if err != nil {
+			return nil, err
+		}
+		g1 := runtime.NewRegisterObject(runtime.REG_GENERAL_1)
+		return append(prog, runtime.NewRecvOp(g1, g1)), nil
+	case ST_ADD, ST_SUB, ST_EQ, ST_NE, ST_LT, ST_LE, ST_GT, ST_GE:
+		return g.genBinary(nd)
+	default:
+		return nil, fmt.Errorf("genExpr: unsupported value: %s", nd.kind.String())
+	}
+}
+
+// genBinary 左辺をスタックに退避してから右辺を評価し，GENERAL_1 op GENERAL_2を計算する
+// 比較の結果はBOOL_FLAGに入るのでGENERAL_1に移す
+// a > b はソースの順に評価してから，レジスタを入れ替えてLTにする
+func (g *Generator) genBinary(nd *Node) (runtime.Program, error) {
+	lhsProg, err := g.genExpr(nd.lhs)
+	if err != nil {
+		return nil, err
+	}
+	rhsProg, err := g.genExpr(nd.rhs)
+	if err != nil {
+		return nil, err
+	}
+	g1 := runtime.NewRegisterObject(runtime.REG_GENERAL_1)
+	g2 := runtime.NewRegisterObject(runtime.REG_GENERAL_2)
+	prog := runtime.Program{}
+	prog = append(prog, lhsProg...)
+	prog = append(prog, runtime.NewPushOp(g1))
+	prog = append(prog, rhsProg...)
+	prog = append(prog, runtime.Program{
+		runtime.NewMoveOp(g2, g1),
+		runtime.NewPopOp(g1),
+	}...)
+	switch nd.kind {
+	case ST_ADD:
+		prog = append(prog, runtime.NewAddOp(g1, g2))
+	case ST_SUB:
+		prog = append(prog, runtime.NewSubOp(g1, g2))
+	case ST_EQ:
+		prog = append(prog, runtime.NewEqOp(g1, g2))
+	case ST_NE:
+		prog = append(prog, runtime.NewNeOp(g1, g2))
+	case ST_LT:
+		prog = append(prog, runtime.NewLtOp(g1, g2))
+	case ST_LE:
+		prog = append(prog, runtime.NewLeOp(g1, g2))
+	case ST_GT:
+		prog = append(prog, runtime.NewLtOp(g2, g1))
+	case ST_GE:
+		prog = append(prog, runtime.NewLeOp(g2, g1))
+	}
+	switch nd.kind {
+	case ST_EQ, ST_NE, ST_LT, ST_LE, ST_GT, ST_GE:
+		prog = append(prog, runtime.NewMoveOp(g1, runtime.NewRegisterObject(runtime.REG_BOOL_FLAG)))
+	}
+	return prog, nil
+}
+
+func (g *Generator) genLoadIdent(nd *Node) (runtime.Program, error) {
+	id, err := nd.leaf.GetIdent()
+	if err != nil {
+		return nil, err
+	}
+	if g.sc != nil {
+		if depth, slot, ok := g.sc.lookup(id); ok {
+			return runtime.Program{
+				runtime.NewLoadEnvOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewObject(depth), runtime.NewObject(slot)),
+			}, nil
+		}
+	}
+	// 関数名なら関数そのものを値として扱う
+	no, ok, err := g.resolveFunction(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, extern := g.externs[no]; ok && extern {
+		return nil, fmt.Errorf("genLoadIdent: cannot use extern function as value: %s", id)
+	}
+	if ok {
+		return runtime.Program{
+			runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewFunctionObject(no)),
+		}, nil
+	}
+	return nil, fmt.Errorf("genLoadIdent: undefined: %s", id)
+}
+
+// genCall 呼び出した結果はSTATUSに入る
+// 関数名を直接呼ぶとき以外は，呼び出し先がENVを書き換えるので呼び出し側で保存しておく
+func (g *Generator) genCall(nd *Node) (runtime.Program, error) {
+	callee := nd.lhs
+	label, direct, builtin, err := g.resolveCallee(callee)
+	if err != nil {
+		return nil, err
+	}
+	if builtin != "" {
+		return builtins[builtin](g, nd)
+	}
+	if arity, ok := g.externs[label]; direct && ok {
+		return g.genCallHost(nd, label, arity)
+	}
+
+	prog := runtime.Program{}
+	if !direct {
+		prog = append(prog, runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_ENV)))
+	}
+	argsProg, err := g.genArguments(nd)
+	if err != nil {
+		return nil, err
+	}
+	prog = append(prog, argsProg...)
+	if direct {
+		prog = append(prog, runtime.NewCallOp(runtime.NewLabelObject(label)))
+		return prog, nil
+	}
+	calleeProg, err := g.genExpr(callee)
+	if err != nil {
+		return nil, err
+	}
+	prog = append(prog, calleeProg...)
+	prog = append(prog, runtime.Program{
+		runtime.NewCallOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_ENV)),
+	}...)
+	return prog, nil
+}
+
+// genCallHost externの関数はCALL_HOSTで埋め込んだ側のGoの関数を呼ぶ
+func (g *Generator) genCallHost(nd *Node, label int, arity int) (runtime.Program, error) {
+	if count := countArguments(nd); count != arity {
+		id, _ := nd.lhs.leaf.GetIdent()
+		return nil, fmt.Errorf("%s: want %d arguments, got %d", id, arity, count)
+	}
+	prog, err := g.genArguments(nd)
+	if err != nil {
+		return nil, err
+	}
+	return append(prog, runtime.NewCallHostOp(runtime.NewHostObject(label), runtime.NewObject(arity))), nil
+}
+
+// resolveCallee 呼び出し先が変数でない名前なら，関数のラベルか組み込み関数の名前を返す
+func (g *Generator) resolveCallee(callee *Node) (label int, direct bool, builtin string, err error) {
+	if callee.kind != ST_IDENT {
+		return 0, false, "", nil
+	}
+	id, err := callee.leaf.GetIdent()
+	if err != nil {
+		return 0, false, "", err
+	}
+	if g.sc != nil {
+		if _, _, isVariable := g.sc.lookup(id); isVariable {
+			return 0, false, "", nil
+		}
+	}
+	if _, defined := g.lc.Get(qualify(g.mod.name, id)); !defined {
+		if _, ok := builtins[id]; ok {
+			return 0, false, id, nil
+		}
+	}
+	label, direct, err = g.resolveFunction(id)
+	return label, direct, "", err
+}
+
+// genArguments 引数を前から順にスタックに積む
+func (g *Generator) genArguments(nd *Node) (runtime.Program, error) {
+	prog := runtime.Program{}
+	if nd.rhs == nil {
+		return prog, nil
+	}
+	for arg := nd.rhs.lhs; arg != nil; arg = arg.next {
+		argProg, err := g.genExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		prog = append(prog, argProg...)
+		prog = append(prog, runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1)))
+	}
+	return prog, nil
+}
+
+// genSpawn 関数呼び出しを新しいスレッドで始め，スレッドをGENERAL_1に入れる
+// 新しいスレッドはレジスタを別に持つので，ENVを保存する必要はない
+func (g *Generator) genSpawn(nd *Node) (runtime.Program, error) {
+	call := nd.lhs
+	label, direct, builtin, err := g.resolveCallee(call.lhs)
+	if err != nil {
+		return nil, err
+	}
+	if builtin != "" {
+		return nil, fmt.Errorf("genSpawn: cannot spawn builtin: %s", builtin)
+	}
+	if _, ok := g.externs[label]; direct && ok {
+		id, _ := call.lhs.leaf.GetIdent()
+		return nil, fmt.Errorf("genSpawn: cannot spawn extern function: %s", id)
+	}
+	prog, err := g.genArguments(call)
+	if err != nil {
+		return nil, err
+	}
+	g1 := runtime.NewRegisterObject(runtime.REG_GENERAL_1)
+	argc := runtime.NewObject(countArguments(call))
+	if direct {
+		return append(prog, runtime.NewSpawnOp(g1, runtime.NewLabelObject(label), argc)), nil
 	}
+	calleeProg, err := g.genExpr(call.lhs)
+	if err != nil {
+		return nil, err
+	}
+	prog = append(prog, calleeProg...)
+	return append(prog, runtime.NewSpawnOp(g1, g1, argc)), nil
+}
+
+// genSend チャネルを退避してから値を評価し，SEND GENERAL_1 GENERAL_2にする
+func (g *Generator) genSend(nd *Node) (runtime.Program, error) {
+	chProg, err := g.genExpr(nd.lhs)
+	if err != nil {
+		return nil, err
+	}
+	valueProg, err := g.genExpr(nd.rhs)
+	if err != nil {
+		return nil, err
+	}
+	g1 := runtime.NewRegisterObject(runtime.REG_GENERAL_1)
+	g2 := runtime.NewRegisterObject(runtime.REG_GENERAL_2)
+	prog := runtime.Program{}
+	prog = append(prog, chProg...)
+	prog = append(prog, runtime.NewPushOp(g1))
+	prog = append(prog, valueProg...)
+	return append(prog, runtime.Program{
+		runtime.NewMoveOp(g2, g1),
+		runtime.NewPopOp(g1),
+		runtime.NewSendOp(g1, g2),
+	}...), nil
+}
+
+func (g *Generator) genDefineVariable(nd *Node) (runtime.Program, error) {
+	return g.genStore(nd.lhs, nd.rhs)
+}
+
+func (g *Generator) genAssign(nd *Node) (runtime.Program, error) {
+	return g.genStore(nd.lhs, nd.rhs)
+}
+
+func (g *Generator) genStore(ident *Node, value *Node) (runtime.Program, error) {
+	id, err := ident.leaf.GetIdent()
+	if err != nil {
+		return nil, err
+	}
+	if g.sc == nil {
+		return nil, fmt.Errorf("genStore: variable outside function: %s", id)
+	}
+	// 写しに書いても外側の変数は変わらないので，黙って捨てずにエラーにする
+	if g.sc.captured(id) {
+		return nil, fmt.Errorf("genStore: cannot assign to captured variable: %s", id)
+	}
+	depth, slot, ok := g.sc.lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("genStore: undefined: %s", id)
+	}
+	prog, err := g.genExpr(value)
+	if err != nil {
+		return nil, err
+	}
+	prog = append(prog, runtime.NewStoreEnvOp(runtime.NewObject(depth), runtime.NewObject(slot), runtime.NewRegisterObject(runtime.REG_GENERAL_1)))
 	return prog, nil
 }
 
-func genBlock(nd *Node) (runtime.Program, error) {
-	backup := *curt
-	prog, err := Generate(nd.lhs)
+func (g *Generator) genBlock(nd *Node) (runtime.Program, error) {
+	backup := *g.curt
+	prog, err := g.genStatements(nd.lhs)
 	if err != nil {
 		return nil, err
 	}
-	curt = &backup
+	g.curt = &backup
 	return prog, nil
 }
 
-func genIdent(nd *Node) (int, error) {
+func (g *Generator) genIdent(nd *Node) (int, error) {
 	id, err := nd.leaf.GetIdent()
 	if err != nil {
 		return 0, err
 	}
-	no, ok := lc.Get(id)
+	id = qualify(g.mod.name, id)
+	no, ok := g.lc.Get(id)
 	if ok {
 		return no, nil
 	}
-	no, err = lc.Set(id)
+	no, err = g.lc.Set(id)
 	if err != nil {
 		return 0, err
 	}
 	return no, nil
 }
 
-func genFunctionArguments(nd *Node, fnNameLabel int) runtime.Program {
-	// argumentsとして幾つpushされるのか
+// genFunctionArguments 呼び出し側がpushした引数をフレームに移す
+// 引数の上にはCALLが積んだ戻り先があるので，一旦RETURN_ADDRESSに逃がしておく
+func (g *Generator) genFunctionArguments(params []string) runtime.Program {
 	prog := runtime.Program{}
-	count := 0
-	argHead := nd.lhs
-	args := []Node{}
-	for {
-		if argHead == nil {
-			break
-		}
-		count++
-		args = append(args, *argHead)
-		argHead = argHead.next
-	}
 	// 引数なし
-	if count == 0 {
+	if len(params) == 0 {
 		return prog
 	}
-	// 逆にする
-	slices.Reverse(args)
-	for range args {
+	prog = append(prog, runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_RETURN_ADDRESS)))
+	// 最後の引数から取り出される
+	for i := len(params) - 1; 0 <= i; i-- {
+		_, slot, _ := g.sc.lookup(params[i])
 		prog = append(prog, runtime.Program{
 			runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_TEMP_1)),
-			runtime.NewMoveOp(runtime.NewReferenceObject(fnNameLabel+count), runtime.NewRegisterObject(runtime.REG_TEMP_1)),
+			runtime.NewStoreEnvOp(runtime.NewObject(0), runtime.NewObject(slot), runtime.NewRegisterObject(runtime.REG_TEMP_1)),
 		}...)
-		count--
 	}
-
+	prog = append(prog, runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_RETURN_ADDRESS)))
 	return prog
 }
 
-func analyzeFunctionHeader(nd *Node) (int, runtime.Program, error) {
-	fnNameLabel, err := genIdent(nd.lhs)
+func (g *Generator) analyzeFunctionHeader(nd *Node) (int, string, []string, error) {
+	fnNameLabel, err := g.genIdent(nd.lhs)
 	if err != nil {
-		return 0, nil, err
+		return 0, "", nil, err
 	}
-	fnArgsProg := genFunctionArguments(nd.rhs, fnNameLabel)
-	return fnNameLabel, fnArgsProg, nil
+	fnName, _ := nd.lhs.leaf.GetIdent()
+	return fnNameLabel, qualify(g.mod.name, fnName), paramNames(nd.rhs), nil
 }
 
-func analyzeFunctionDeclaration(nd *Node) (int, runtime.Program, int, error) {
+func (g *Generator) analyzeFunctionDeclaration(nd *Node) (int, string, []string, int, error) {
 	// fnHeader
-	fnNameLabel, fnArgsProg, err := analyzeFunctionHeader(nd.lhs)
+	fnNameLabel, fnName, fnParams, err := g.analyzeFunctionHeader(nd.lhs)
 	if err != nil {
-		return 0, nil, 0, err
+		return 0, "", nil, 0, err
 	}
 	// fnReturns
 	//fnReturnsCount := analyzeFunctionReturns(nd.rhs)
-	return fnNameLabel, fnArgsProg, 0, nil
+	return fnNameLabel, fnName, fnParams, 0, nil
+}
+
+func endsWithReturn(block *Node) bool {
+	last := block.lhs
+	if last == nil {
+		return false
+	}
+	for last.next != nil {
+		last = last.next
+	}
+	return last.kind == ST_RETURN
 }
 
-func genDefineFunction(nd *Node) (runtime.Program, error) {
-	nameLabel, argsProg, _, err := analyzeFunctionDeclaration(nd.lhs)
+// genFunctionBody 関数1つ分のコード
+// 関数リテラルはキャプチャした変数を親として見られるように必ずフレームを作る
+func (g *Generator) genFunctionBody(name string, label int, params []string, captures []string, block *Node, forceFrame bool) (runtime.Program, error) {
+	outer := g.sc
+	g.sc = newScope(name, params, captures, block)
+	defer func() { g.sc = outer }()
+	if forceFrame {
+		g.sc.hasFrame = true
+	}
+
+	prog := runtime.Program{
+		runtime.NewDefLabelOp(runtime.NewLabelObject(label)),
+	}
+	if g.sc.hasFrame {
+		prog = append(prog, runtime.NewEnterOp(runtime.NewObject(g.sc.size)))
+	}
+	prog = append(prog, g.genFunctionArguments(params)...)
+	blockProg, err := g.genBlock(block)
 	if err != nil {
 		return nil, err
 	}
-	blockProg, err := genBlock(nd.rhs)
+	prog = append(prog, blockProg...)
+	if !endsWithReturn(block) {
+		prog = append(prog, g.genEpilogue()...)
+	}
+	return prog, nil
+}
+
+func (g *Generator) genDefineFunction(nd *Node) (runtime.Program, error) {
+	if g.sc != nil {
+		return nil, fmt.Errorf("genDefineFunction: nested function definition: use function literal")
+	}
+	nameLabel, name, params, _, err := g.analyzeFunctionDeclaration(nd.lhs)
 	if err != nil {
 		return nil, err
 	}
+	return g.genFunctionBody(name, nameLabel, params, nil, nd.rhs, false)
+}
 
-	prog := runtime.Program{
-		runtime.NewDefLabelOp(runtime.NewLabelObject(nameLabel)),
+// genFunctionLiteral 関数リテラルを値としてGENERAL_1に入れるコード
+// 自由変数のうち今のスコープの変数だけをキャプチャし，何もキャプチャしなければただの関数になる
+func (g *Generator) genFunctionLiteral(nd *Node) (runtime.Program, error) {
+	if g.sc == nil {
+		return nil, fmt.Errorf("genFunctionLiteral: function literal outside function")
+	}
+	g.sc.literals++
+	name := fmt.Sprintf("%s.func%d", g.sc.name, g.sc.literals)
+	label, err := g.lc.Set(name)
+	if err != nil {
+		return nil, err
 	}
-	prog = append(prog, argsProg...)
-	prog = append(prog, blockProg...)
-	//prog = append(prog, runtime.NewReturnOp())
 
+	prog := runtime.Program{}
+	captures := []string{}
+	for _, free := range freeVariables(nd) {
+		depth, slot, ok := g.sc.lookup(free)
+		if !ok {
+			continue
+		}
+		captures = append(captures, free)
+		prog = append(prog, runtime.Program{
+			runtime.NewLoadEnvOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewObject(depth), runtime.NewObject(slot)),
+			runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		}...)
+	}
+	if len(captures) == 0 {
+		prog = append(prog, runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewFunctionObject(label)))
+	} else {
+		prog = append(prog, runtime.NewMakeClosureOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewLabelObject(label), runtime.NewObject(len(captures))))
+	}
+
+	bodyProg, err := g.genFunctionBody(name, label, paramNames(nd.lhs), captures, nd.rhs, true)
+	if err != nil {
+		return nil, err
+	}
+	g.literals = append(g.literals, bodyProg...)
 	return prog, nil
 }
 
-func Generate(node *Node) (runtime.Program, error) {
-	curt = &Node{next: node} // dummy
-	lc = NewLabelCollector()
-	lc.Init()
+func (g *Generator) genStatements(node *Node) (runtime.Program, error) {
+	g.curt = &Node{next: node} // dummy
 
 	program := runtime.Program{}
 	for {
-		if err := nextNode(); err != nil { // end of node
+		if err := g.nextNode(); err != nil { // end of node
 			break
 		}
-		switch curt.kind {
+		var prog runtime.Program
+		var err error
+		before := len(g.literals)
+		switch g.curt.kind {
 		case ST_RETURN:
-			prog, err := genReturn(curt)
-			if err != nil {
+			prog, err = g.genReturn(g.curt)
+		case ST_DEFINE_FUNCTION:
+			prog, err = g.genDefineFunction(g.curt)
+		case ST_DEFINE_VARIABLE:
+			prog, err = g.genDefineVariable(g.curt)
+		case ST_ASSIGN:
+			prog, err = g.genAssign(g.curt)
+		case ST_CALL:
+			prog, err = g.genCall(g.curt)
+		case ST_SPAWN, ST_RECV:
+			prog, err = g.genExpr(g.curt)
+		case ST_SEND:
+			prog, err = g.genSend(g.curt)
+		case ST_IMPORT: // 読み込みはモジュールを集める段階で済んでいる
+			if g.sc != nil {
+				err = fmt.Errorf("import must be at top level")
+			}
+		case ST_EXTERN_FUNCTION: // 宣言だけなのでdeclareFunctionsで済んでいる
+		default:
+			return nil, fmt.Errorf("unsupported syntax: %v", g.curt.kind.String())
+		}
+		if err != nil {
+			return nil, err
+		}
+		g.recordPosition(g.curt, prog, g.literals[before:])
+		program = append(program, prog...)
+	}
+	return program, nil
+}
+
+// declareFunctions 後ろで定義される関数も呼べるように，先に関数名のラベルを登録しておく
+// externの関数もラベルを割り当て，そのシンボル名でホスト関数を引く
+func (g *Generator) declareFunctions(node *Node, declared map[string]bool) error {
+	for nd := node; nd != nil; nd = nd.next {
+		if nd.kind != ST_DEFINE_FUNCTION && nd.kind != ST_EXTERN_FUNCTION {
+			continue
+		}
+		ident := nd.lhs.lhs.lhs
+		id, err := ident.leaf.GetIdent()
+		if err != nil {
+			return err
+		}
+		if declared[qualify(g.mod.name, id)] {
+			return fmt.Errorf("function redeclared: %s", qualify(g.mod.name, id))
+		}
+		declared[qualify(g.mod.name, id)] = true
+		label, err := g.genIdent(ident)
+		if err != nil {
+			return err
+		}
+		if nd.kind == ST_EXTERN_FUNCTION {
+			g.externs[label] = len(paramNames(nd.lhs.lhs.rhs))
+		}
+	}
+	return nil
+}
+
+// Generate 新しいGeneratorで生成する
+func Generate(node *Node) (runtime.Program, error) {
+	return NewGenerator().Generate(node)
+}
+
+// GenerateObject 新しいGeneratorでオブジェクトファイルを生成する
+func GenerateObject(node *Node) (*runtime.ObjectFile, error) {
+	return NewGenerator().GenerateObject(node)
+}
+
+func (g *Generator) Generate(node *Node) (runtime.Program, error) {
+	return g.generateModules([]*Module{
+		{name: mainModule, files: []*Node{node}},
+	})
+}
+
+// generateModules 全モジュールを1つのプログラムにする
+// ラベルは全モジュールで共有し，関数名はモジュール名で修飾して区別する
+func (g *Generator) generateModules(mods []*Module) (runtime.Program, error) {
+	g.lc = NewLabelCollector()
+	g.lc.Init()
+	g.sc = nil
+	g.literals = runtime.Program{}
+	g.positions = make(map[*runtime.Operation]SourcePos)
+	g.externs = make(map[int]int)
+
+	declared := make(map[string]bool)
+	for _, m := range mods {
+		g.mod = m
+		for _, file := range m.files {
+			if err := g.declareFunctions(file, declared); err != nil {
 				return nil, err
 			}
-			program = append(program, prog...)
-		case ST_DEFINE_FUNCTION:
-			prog, err := genDefineFunction(curt)
+		}
+	}
+	program := runtime.Program{}
+	for _, m := range mods {
+		g.mod = m
+		for i, nd := range m.files {
+			if g.optimize {
+				nd = fold(nd)
+			}
+			g.file = ""
+			if i < len(m.paths) {
+				g.file = m.paths[i]
+			}
+			prog, err := g.genStatements(nd)
 			if err != nil {
 				return nil, err
 			}
 			program = append(program, prog...)
-		default:
-			return nil, fmt.Errorf("unsupported syntax: %v", curt.kind.String())
 		}
 	}
+	program = append(program, g.literals...)
 	return program, nil
 }
+
+// GenerateObject 他のファイルで定義されている関数を呼んでいても，未定義のシンボルとして残してコンパイルする
+// できたオブジェクトファイルはruntime.Linkでまとめる
+func (g *Generator) GenerateObject(node *Node) (*runtime.ObjectFile, error) {
+	g.allowUndefined = true
+	defer func() { g.allowUndefined = false }()
+	prog, err := g.Generate(node)
+	if err != nil {
+		return nil, err
+	}
+	// Initで登録されるmainのように，使っていないラベルはシンボルに含めない
+	used := make(map[int]bool)
+	for _, op := range prog {
+		for _, label := range op.Labels() {
+			used[label] = true
+		}
+	}
+	symbols := make(map[string]int)
+	for name, no := range g.lc.label {
+		if used[no] {
+			symbols[name] = no
+		}
+	}
+	return runtime.NewObjectFile(prog, symbols), nil
+}
@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"mylang/runtime"
 	"testing"
@@ -71,11 +72,404 @@ func TestGenerate_DefineFunction(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, runtime.Program{
 		runtime.NewDefLabelOp(runtime.NewLabelObject(0)),
+		runtime.NewEnterOp(runtime.NewObject(2)),
+		runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_RETURN_ADDRESS)),
 		runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_TEMP_1)),
-		runtime.NewMoveOp(runtime.NewReferenceObject(2), runtime.NewRegisterObject(runtime.REG_TEMP_1)),
+		runtime.NewStoreEnvOp(runtime.NewObject(0), runtime.NewObject(1), runtime.NewRegisterObject(runtime.REG_TEMP_1)),
 		runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_TEMP_1)),
-		runtime.NewMoveOp(runtime.NewReferenceObject(1), runtime.NewRegisterObject(runtime.REG_TEMP_1)),
+		runtime.NewStoreEnvOp(runtime.NewObject(0), runtime.NewObject(0), runtime.NewRegisterObject(runtime.REG_TEMP_1)),
+		runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_RETURN_ADDRESS)),
 		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewObject(100)),
+		runtime.NewLeaveOp(),
 		runtime.NewReturnOp(),
 	}, prog)
 }
+
+func TestFreeVariables(t *testing.T) {
+	// fn(y) { var z = y; return g(x, z) }
+	lit := &Node{
+		kind: ST_FUNCTION_LITERAL,
+		lhs:  &Node{kind: ST_FUNCTION_ARGUMENTS, lhs: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "y")}},
+		rhs: &Node{
+			kind: ST_BLOCK,
+			lhs: &Node{
+				kind: ST_DEFINE_VARIABLE,
+				lhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "z")},
+				rhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "y")},
+				next: &Node{
+					kind: ST_RETURN,
+					lhs: &Node{
+						kind: ST_CALL,
+						lhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "g")},
+						rhs: &Node{
+							kind: ST_CALL_ARGUMENTS,
+							lhs: &Node{
+								kind: ST_IDENT,
+								leaf: NewToken(TK_IDENT, "x"),
+								next: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "z")},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, []string{"g", "x"}, freeVariables(lit))
+
+	// fn() { return fn() { return w } }
+	nested := &Node{
+		kind: ST_FUNCTION_LITERAL,
+		lhs:  &Node{kind: ST_FUNCTION_ARGUMENTS},
+		rhs: &Node{
+			kind: ST_BLOCK,
+			lhs: &Node{
+				kind: ST_RETURN,
+				lhs: &Node{
+					kind: ST_FUNCTION_LITERAL,
+					lhs:  &Node{kind: ST_FUNCTION_ARGUMENTS},
+					rhs:  &Node{kind: ST_BLOCK, lhs: &Node{kind: ST_RETURN, lhs: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "w")}}},
+				},
+			},
+		},
+	}
+	assert.Equal(t, []string{"w"}, freeVariables(nested))
+}
+
+func TestGenerate_Closure(t *testing.T) {
+	// fn main(x) { return fn() { return x } }
+	n := &Node{
+		kind: ST_DEFINE_FUNCTION,
+		lhs: &Node{
+			kind: ST_FUNCTION_DECLARATION,
+			lhs: &Node{
+				kind: ST_FUNCTION_HEADER,
+				lhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "main")},
+				rhs:  &Node{kind: ST_FUNCTION_ARGUMENTS, lhs: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "x")}},
+			},
+			rhs: &Node{kind: ST_FUNCTION_RETURNS},
+		},
+		rhs: &Node{
+			kind: ST_BLOCK,
+			lhs: &Node{
+				kind: ST_RETURN,
+				lhs: &Node{
+					kind: ST_FUNCTION_LITERAL,
+					lhs:  &Node{kind: ST_FUNCTION_ARGUMENTS},
+					rhs:  &Node{kind: ST_BLOCK, lhs: &Node{kind: ST_RETURN, lhs: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "x")}}},
+				},
+			},
+		},
+	}
+	prog, err := Generate(n)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.Program{
+		// main
+		runtime.NewDefLabelOp(runtime.NewLabelObject(0)),
+		runtime.NewEnterOp(runtime.NewObject(1)),
+		runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_RETURN_ADDRESS)),
+		runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_TEMP_1)),
+		runtime.NewStoreEnvOp(runtime.NewObject(0), runtime.NewObject(0), runtime.NewRegisterObject(runtime.REG_TEMP_1)),
+		runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_RETURN_ADDRESS)),
+		runtime.NewLoadEnvOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewObject(0), runtime.NewObject(0)),
+		runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewMakeClosureOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewLabelObject(1), runtime.NewObject(1)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewLeaveOp(),
+		runtime.NewReturnOp(),
+		// main.func1
+		runtime.NewDefLabelOp(runtime.NewLabelObject(1)),
+		runtime.NewEnterOp(runtime.NewObject(0)),
+		runtime.NewLoadEnvOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewObject(1), runtime.NewObject(0)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewLeaveOp(),
+		runtime.NewReturnOp(),
+	}, prog)
+}
+
+func TestGenerate_AssignCaptured(t *testing.T) {
+	// キャプチャした変数は値の写しなので，書き換えはエラーにする
+	for _, src := range []string{
+		"fn main() int { var x = 1 var f = fn() { x = x + 10 } f() return x }",
+		"fn main() int { var x = 1 var f = fn() { var g = fn() { x = 2 } g() } f() return x }",
+	} {
+		_, err := compileSource(src)
+		assert.EqualError(t, err, "genStore: cannot assign to captured variable: x", src)
+	}
+
+	// 同じ名前の変数を宣言すればクロージャのローカル変数になる
+	prog, err := compileSource("fn main() int { var x = 1 var f = fn() int { var x = 5 x = x + 10 return x } return f() + x }")
+	assert.Nil(t, err)
+	r := runtime.NewRuntime(10, 10)
+	assert.Nil(t, r.Load(prog))
+	assert.Nil(t, r.CollectLabel())
+	assert.Nil(t, r.Run())
+	assert.Equal(t, runtime.NewObject(16), r.Register(runtime.REG_STATUS))
+}
+
+func TestGenerate_CallFunctionValue(t *testing.T) {
+	// fn main() { var f = one; return f() }
+	// fn one() { return 1 }
+	n := &Node{
+		kind: ST_DEFINE_FUNCTION,
+		lhs: &Node{
+			kind: ST_FUNCTION_DECLARATION,
+			lhs: &Node{
+				kind: ST_FUNCTION_HEADER,
+				lhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "main")},
+				rhs:  &Node{kind: ST_FUNCTION_ARGUMENTS},
+			},
+			rhs: &Node{kind: ST_FUNCTION_RETURNS},
+		},
+		rhs: &Node{
+			kind: ST_BLOCK,
+			lhs: &Node{
+				kind: ST_DEFINE_VARIABLE,
+				lhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "f")},
+				rhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "one")},
+				next: &Node{
+					kind: ST_RETURN,
+					lhs:  &Node{kind: ST_CALL, lhs: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "f")}, rhs: &Node{kind: ST_CALL_ARGUMENTS}},
+				},
+			},
+		},
+		next: &Node{
+			kind: ST_DEFINE_FUNCTION,
+			lhs: &Node{
+				kind: ST_FUNCTION_DECLARATION,
+				lhs: &Node{
+					kind: ST_FUNCTION_HEADER,
+					lhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "one")},
+					rhs:  &Node{kind: ST_FUNCTION_ARGUMENTS},
+				},
+				rhs: &Node{kind: ST_FUNCTION_RETURNS},
+			},
+			rhs: &Node{
+				kind: ST_BLOCK,
+				lhs:  &Node{kind: ST_RETURN, lhs: &Node{kind: ST_PRIMITIVE, lhs: &Node{kind: ST_INTEGER, leaf: NewToken(TK_INT, "1")}}},
+			},
+		},
+	}
+	prog, err := Generate(n)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.Program{
+		// main
+		runtime.NewDefLabelOp(runtime.NewLabelObject(0)),
+		runtime.NewEnterOp(runtime.NewObject(1)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewFunctionObject(1)),
+		runtime.NewStoreEnvOp(runtime.NewObject(0), runtime.NewObject(0), runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewPushOp(runtime.NewRegisterObject(runtime.REG_ENV)),
+		runtime.NewLoadEnvOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewObject(0), runtime.NewObject(0)),
+		runtime.NewCallOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewPopOp(runtime.NewRegisterObject(runtime.REG_ENV)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewRegisterObject(runtime.REG_STATUS)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewLeaveOp(),
+		runtime.NewReturnOp(),
+		// one
+		runtime.NewDefLabelOp(runtime.NewLabelObject(1)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewObject(1)),
+		runtime.NewReturnOp(),
+	}, prog)
+
+	// 生成したコードがそのまま動くことを確認
+	r := runtime.NewRuntime(10, 10)
+	assert.Nil(t, r.Load(prog))
+	assert.Nil(t, r.CollectLabel())
+	assert.Nil(t, r.Run())
+}
+
+func TestGenerateObject(t *testing.T) {
+	head, err := Tokenize("fn main() { return helper(2) }")
+	assert.Nil(t, err)
+	mainNode, err := Parse(head)
+	assert.Nil(t, err)
+	mainObj, err := GenerateObject(mainNode)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"main"}, mainObj.Defined())
+	assert.Equal(t, []string{"helper"}, mainObj.Undefined())
+
+	head, err = Tokenize("fn helper(x) { return x - 1 }")
+	assert.Nil(t, err)
+	libNode, err := Parse(head)
+	assert.Nil(t, err)
+	libObj, err := GenerateObject(libNode)
+	assert.Nil(t, err)
+	// 使っていないmainはシンボルに含まれない
+	assert.Equal(t, map[string]int{"helper": 1}, libObj.Symbols)
+
+	linked, err := runtime.Link(mainObj, libObj)
+	assert.Nil(t, err)
+	r := runtime.NewRuntime(10, 10)
+	assert.Nil(t, r.Load(linked.Program))
+	assert.Nil(t, r.CollectLabel())
+	assert.Nil(t, r.Run())
+
+	// 普通のGenerateでは未定義のままにはしない
+	_, err = Generate(mainNode)
+	assert.EqualError(t, err, "genLoadIdent: undefined: helper")
+}
+
+func TestGenerate_Builtin(t *testing.T) {
+	head, err := Tokenize("fn main() { var n = readInt() return input() }")
+	assert.Nil(t, err)
+	nd, err := Parse(head)
+	assert.Nil(t, err)
+	prog, err := Generate(nd)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.Program{
+		runtime.NewDefLabelOp(runtime.NewLabelObject(0)),
+		runtime.NewEnterOp(runtime.NewObject(1)),
+		runtime.NewSyscallReadOp(runtime.NewObject(runtime.STD_IN), runtime.NewObject(runtime.READ_INT), runtime.NewRegisterObject(runtime.REG_STATUS)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewRegisterObject(runtime.REG_STATUS)),
+		runtime.NewStoreEnvOp(runtime.NewObject(0), runtime.NewObject(0), runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewSyscallReadOp(runtime.NewObject(runtime.STD_IN), runtime.NewObject(runtime.READ_LINE), runtime.NewRegisterObject(runtime.REG_STATUS)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_GENERAL_1), runtime.NewRegisterObject(runtime.REG_STATUS)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewRegisterObject(runtime.REG_GENERAL_1)),
+		runtime.NewLeaveOp(),
+		runtime.NewReturnOp(),
+	}, prog)
+
+	// 同じ名前の関数を定義すればそちらを呼ぶ
+	head, err = Tokenize("fn main() { return input() } fn input() { return 1 }")
+	assert.Nil(t, err)
+	nd, err = Parse(head)
+	assert.Nil(t, err)
+	prog, err = Generate(nd)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NewCallOp(runtime.NewLabelObject(1)), prog[1])
+
+	head, err = Tokenize("fn main() { return input(1) }")
+	assert.Nil(t, err)
+	nd, err = Parse(head)
+	assert.Nil(t, err)
+	_, err = Generate(nd)
+	assert.EqualError(t, err, "input: want 0 arguments, got 1")
+}
+
+func TestGenerate_Spawn(t *testing.T) {
+	nd, err := parseSource(t, "fn add(a, b) int { yield() return a + b } fn main() { var t = spawn add(1, 2) spawn add(3, 4) return join(t) }")
+	assert.Nil(t, err)
+	prog, err := Generate(nd)
+	assert.Nil(t, err)
+	g1 := runtime.NewRegisterObject(runtime.REG_GENERAL_1)
+	assert.Equal(t, runtime.Program{
+		runtime.NewDefLabelOp(runtime.NewLabelObject(0)),
+		runtime.NewEnterOp(runtime.NewObject(1)),
+		runtime.NewMoveOp(g1, runtime.NewObject(1)),
+		runtime.NewPushOp(g1),
+		runtime.NewMoveOp(g1, runtime.NewObject(2)),
+		runtime.NewPushOp(g1),
+		runtime.NewSpawnOp(g1, runtime.NewLabelObject(1), runtime.NewObject(2)),
+		runtime.NewStoreEnvOp(runtime.NewObject(0), runtime.NewObject(0), g1),
+	}, prog[18:26])
+
+	tests := []struct {
+		name string
+		src  string
+		want int
+	}{
+		{"function", "fn add(a, b) int { yield() return a + b } fn main() int { var t1 = spawn add(1, 2) var t2 = spawn add(3, 4) return join(t1) + join(t2) }", 10},
+		{"closure", "fn main() int { var base = 100 var f = fn(x) int { yield() return x + base } var t = spawn f(1) return join(t) }", 101},
+		{"nested", "fn inner() int { return 5 } fn outer() int { return join(spawn inner()) + 1 } fn main() int { return join(spawn outer()) }", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := compileSource(tt.src)
+			assert.Nil(t, err)
+			got, err := runProgram(prog)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, tt := range []struct {
+		src string
+		err string
+	}{
+		{"fn main() { spawn input() }", "genSpawn: cannot spawn builtin: input"},
+		{"fn main() { join() }", "join: want 1 arguments, got 0"},
+		{"fn main() { yield(1) }", "yield: want 0 arguments, got 1"},
+	} {
+		_, err := compileSource(tt.src)
+		assert.EqualError(t, err, tt.err)
+	}
+}
+
+func TestGenerate_Channel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want int
+	}{
+		{"unbuffered", "fn send(ch, v) { ch <- v ch <- v + 1 close(ch) } fn main() int { var ch = channel() spawn send(ch, 10) var a = <-ch return a + <-ch }", 21},
+		{"buffered", "fn main() int { var ch = channel(2) ch <- 3 ch <- 4 return <-ch - <-ch }", -1},
+		{"closure", "fn main() int { var ch = channel() var f = fn() { ch <- 5 } spawn f() return <-ch }", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := compileSource(tt.src)
+			assert.Nil(t, err)
+			got, err := runProgram(prog)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	prog, err := compileSource("fn main() { var ch = channel() ch <- 1 }")
+	assert.Nil(t, err)
+	_, err = runProgram(prog)
+	assert.EqualError(t, err, "failed to schedule: reason=deadlock: all threads are blocked: threads=1")
+
+	_, err = compileSource("fn main() { channel(1, 2) }")
+	assert.EqualError(t, err, "channel: want 0 or 1 arguments, got 2")
+}
+
+func TestGenerate_Extern(t *testing.T) {
+	nd, err := parseSource(t, "extern fn add(a, b) int fn main() int { return add(1, 2) + 3 }")
+	assert.Nil(t, err)
+	obj, err := GenerateObject(nd)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"add"}, obj.Externs())
+	assert.Equal(t, []string{}, obj.Undefined())
+	assert.Contains(t, obj.Program, runtime.NewCallHostOp(runtime.NewHostObject(obj.Symbols["add"]), runtime.NewObject(2)))
+
+	r := runtime.NewRuntime(10, 10)
+	r.RegisterHostFunc("add", func(args []*runtime.Object) (*runtime.Object, error) {
+		return runtime.NewObject(args[0].GetData() + args[1].GetData()), nil
+	})
+	assert.Nil(t, r.LoadObject(obj))
+	assert.Nil(t, r.CollectLabel())
+	assert.Nil(t, r.Run())
+	assert.Equal(t, 6, r.Register(runtime.REG_STATUS).GetData())
+
+	for _, tt := range []struct {
+		src string
+		err string
+	}{
+		{"extern fn f(a) fn main() { f() }", "f: want 1 arguments, got 0"},
+		{"extern fn f() fn main() { var g = f }", "genLoadIdent: cannot use extern function as value: f"},
+		{"extern fn f() fn main() { spawn f() }", "genSpawn: cannot spawn extern function: f"},
+		{"extern fn f() fn f() {}", "function redeclared: f"},
+	} {
+		_, err := compileSource(tt.src)
+		assert.EqualError(t, err, tt.err)
+	}
+}
+
+func TestGenerate_Greater(t *testing.T) {
+	obj, err := CompileSource(`fn f() int { println(1) return 1 }
+fn g() int { println(2) return 2 }
+fn main() {
+	println(f() > g())
+	println(g() >= f())
+	println(2 >= 2)
+}`)
+	assert.Nil(t, err)
+	var stdout bytes.Buffer
+	r := runtime.NewRuntime(20, 20, runtime.WithStdout(&stdout))
+	assert.Nil(t, r.LoadObject(obj))
+	assert.Nil(t, r.CollectLabel())
+	assert.Nil(t, r.Run())
+	// 左辺から順に評価する
+	assert.Equal(t, "1\n2\nfalse\n2\n1\ntrue\ntrue\n", stdout.String())
+}
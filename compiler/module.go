@@ -0,0 +1,228 @@
+package compiler
+
+import (
+	"fmt"
+	"mylang/runtime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	mainModule = "main"
+	sourceExt  = ".my"
+)
+
+// Module 1つのディレクトリにあるソースファイルの集まり
+// 関数名はモジュールごとの名前空間に入り，大文字で始まる名前だけが他のモジュールから見える
+type Module struct {
+	name    string
+	dir     string
+	imports []string
+	files   []*Node
+	paths   []string // filesと同じ順のファイルパス
+}
+
+// qualify モジュール内の名前をラベル名にする, mainモジュールだけは修飾しない
+func qualify(module, name string) string {
+	if module == mainModule {
+		return name
+	}
+	return module + "." + name
+}
+
+func isExported(name string) bool {
+	for _, r := range name {
+		return unicode.IsUpper(r)
+	}
+	return false
+}
+
+// resolveFunction 関数名をラベル番号にする
+// module.Nameの形なら，importしていて公開されている関数だけを解決する
+func (g *Generator) resolveFunction(id string) (int, bool, error) {
+	module, name, qualified := strings.Cut(id, ".")
+	if !qualified {
+		no, ok := g.lc.Get(qualify(g.mod.name, id))
+		if !ok && g.allowUndefined { // 他のオブジェクトファイルで定義されているはず
+			no, err := g.lc.Set(qualify(g.mod.name, id))
+			return no, err == nil, err
+		}
+		return no, ok, nil
+	}
+	if !slices.Contains(g.mod.imports, module) {
+		return 0, false, fmt.Errorf("undefined: %s: module %s is not imported", id, module)
+	}
+	if !isExported(name) {
+		return 0, false, fmt.Errorf("cannot refer to unexported name %s", id)
+	}
+	no, ok := g.lc.Get(id)
+	if !ok {
+		return 0, false, fmt.Errorf("undefined: %s", id)
+	}
+	return no, true, nil
+}
+
+// ParseModule dirにある全ソースファイルを読んでモジュールにする
+func ParseModule(name, dir string) (*Module, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("module %s: %w", name, err)
+	}
+	m := &Module{name: name, dir: dir}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != sourceExt {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		head, err := Tokenize(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+		file, err := Parse(head)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+		for nd := file; nd != nil; nd = nd.next {
+			if nd.kind != ST_IMPORT {
+				continue
+			}
+			imp, _ := nd.leaf.GetString()
+			if !isModuleName(imp) {
+				return nil, fmt.Errorf("%s:%s: invalid module name: %q", path, nd.leaf.Position(), imp)
+			}
+			if !slices.Contains(m.imports, imp) {
+				m.imports = append(m.imports, imp)
+			}
+		}
+		m.files = append(m.files, file)
+		m.paths = append(m.paths, path)
+	}
+	if len(m.files) == 0 {
+		return nil, fmt.Errorf("module %s: no source files in %s", name, dir)
+	}
+	return m, nil
+}
+
+func isModuleName(name string) bool {
+	if name == "" || name == mainModule {
+		return false
+	}
+	for i, r := range name {
+		if !isIdentPart(r) || (i == 0 && !isIdentStart(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadModules rootをmainモジュールとして，importされているモジュールをroot/<name>から再帰的に読む
+// 依存されている側が先に並ぶ
+func LoadModules(root string) ([]*Module, error) {
+	loaded := make(map[string]*Module)
+	mods := []*Module{}
+
+	var load func(name, dir string, path []string) error
+	load = func(name, dir string, path []string) error {
+		if i := slices.Index(path, name); i != -1 {
+			cycle := append(slices.Clone(path[i:]), name)
+			return fmt.Errorf("import cycle not allowed: %s", strings.Join(cycle, " -> "))
+		}
+		if _, ok := loaded[name]; ok {
+			return nil
+		}
+		m, err := ParseModule(name, dir)
+		if err != nil {
+			return err
+		}
+		for _, imp := range m.imports {
+			if err := load(imp, filepath.Join(root, imp), append(path, name)); err != nil {
+				return err
+			}
+		}
+		loaded[name] = m
+		mods = append(mods, m)
+		return nil
+	}
+
+	if err := load(mainModule, root, nil); err != nil {
+		return nil, err
+	}
+	return mods, nil
+}
+
+// CompileDir ディレクトリにあるソースをimportをたどってまとめてコンパイルする
+func CompileDir(root string, opts ...Option) (runtime.Program, error) {
+	prog, _, err := CompileDirDebug(root, opts...)
+	return prog, err
+}
+
+// CompileSource 1つのソースをmainモジュールとしてコンパイルする
+// 読み込むディレクトリがないのでimportは使えない
+// 関数名はSymbolsに残すので，externの関数もLoadObjectすれば名前で引ける
+func CompileSource(src string, opts ...Option) (*runtime.ObjectFile, error) {
+	head, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := Parse(head)
+	if err != nil {
+		return nil, err
+	}
+	m := &Module{name: mainModule, files: []*Node{nd}}
+	for file := nd; file != nil; file = file.next {
+		if file.kind == ST_IMPORT {
+			return nil, fmt.Errorf("%s: import is not supported in a single source", file.pos.Position())
+		}
+	}
+	g := NewGenerator(opts...)
+	prog, err := g.generateModules([]*Module{m})
+	if err != nil {
+		return nil, err
+	}
+	if !definesMain(m) {
+		return nil, fmt.Errorf("module main: function main is not defined")
+	}
+	symbols := make(map[string]int)
+	for name, no := range g.lc.label {
+		symbols[name] = no
+	}
+	return runtime.NewObjectFile(prog, symbols), nil
+}
+
+// CompileDirDebug CompileDirに加えてデバッガ向けの情報も返す
+func CompileDirDebug(root string, opts ...Option) (runtime.Program, *DebugInfo, error) {
+	mods, err := LoadModules(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := NewGenerator(opts...)
+	prog, err := g.generateModules(mods)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !definesMain(mods[len(mods)-1]) {
+		return nil, nil, fmt.Errorf("module main: function main is not defined")
+	}
+	return prog, g.newDebugInfo(prog), nil
+}
+
+func definesMain(m *Module) bool {
+	for _, file := range m.files {
+		for nd := file; nd != nil; nd = nd.next {
+			if nd.kind != ST_DEFINE_FUNCTION {
+				continue
+			}
+			if id, _ := nd.lhs.lhs.lhs.leaf.GetIdent(); id == "main" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package compiler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"mylang/runtime"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSources(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(root, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.Nil(t, os.WriteFile(path, []byte(src), 0o644))
+	}
+	return root
+}
+
+func TestCompileDir(t *testing.T) {
+	root := writeSources(t, map[string]string{
+		"main.my": `import "math"
+fn main() int { return math.Add(add(1), 2) }`,
+		"util.my":      `fn add(x) int { return x + 1 }`,
+		"math/math.my": `fn Add(a, b) int { return add(a, b) }`,
+		"math/add.my":  `fn add(a, b) int { return a + b }`,
+	})
+	mods, err := LoadModules(root)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(mods))
+	assert.Equal(t, "math", mods[0].name)
+	assert.Equal(t, "main", mods[1].name)
+	assert.Equal(t, []string{"math"}, mods[1].imports)
+
+	prog, info, err := CompileDirDebug(root)
+	assert.Nil(t, err)
+	// モジュールごとの名前空間に入っているので，mainのaddとmathのaddはぶつからない
+	assert.Equal(t, map[string]int{"main": 0, "math.add": 1, "math.Add": 2, "add": 3}, info.Symbols)
+
+	r := runtime.NewRuntime(20, 20)
+	assert.Nil(t, r.Load(prog))
+	assert.Nil(t, r.CollectLabel())
+	assert.Nil(t, r.Run())
+}
+
+func TestCompileDirDebug(t *testing.T) {
+	root := writeSources(t, map[string]string{
+		"main.my": `fn main() {
+	var f = fn(x) {
+		return x + 1
+	}
+	return f(1)
+}`,
+	})
+	prog, info, err := CompileDirDebug(root)
+	assert.Nil(t, err)
+	assert.Equal(t, len(prog), len(info.Lines))
+	assert.Equal(t, map[string]int{"main": 0, "main.func1": 1}, info.Symbols)
+	path := filepath.Join(root, "main.my")
+	// 関数の定義はfnの位置, 中の命令はそれぞれの文の位置になる
+	assert.Equal(t, SourcePos{File: path, Line: 1, Column: 1}, info.Lines[0])
+	lines := map[int]bool{}
+	for i, op := range prog {
+		lines[info.Lines[i].Line] = true
+		if op.GetKind() == runtime.OP_DEF_LABEL && op.Labels()[0] == 1 {
+			// 関数リテラルの本体は最後に置かれるが，リテラルを書いた文の位置になる
+			assert.Equal(t, SourcePos{File: path, Line: 2, Column: 2}, info.Lines[i])
+		}
+	}
+	assert.Equal(t, map[int]bool{1: true, 2: true, 3: true, 5: true}, lines)
+}
+
+func TestCompileDir_Errors(t *testing.T) {
+	root := writeSources(t, map[string]string{
+		"main.my":  `import "a"` + "\n" + `fn main() { return }`,
+		"a/a.my":   `import "b"` + "\n" + `fn A() { return }`,
+		"b/b.my":   `import "a"` + "\n" + `fn B() { return }`,
+		"c/c.my":   `fn helper() { return }`,
+		"d/d.my":   `fn D() { return }`,
+		"e/e.my":   `fn E() { return }`,
+		"e/e2.my":  `fn E() { return }`,
+		"x/none.s": `EXIT`,
+	})
+	_, err := CompileDir(root)
+	assert.EqualError(t, err, "import cycle not allowed: a -> b -> a")
+
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "main.my"), []byte(`import "c"`+"\n"+`fn main() { return c.helper() }`), 0o644))
+	_, err = CompileDir(root)
+	assert.EqualError(t, err, "cannot refer to unexported name c.helper")
+
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "main.my"), []byte(`import "c"`+"\n"+`fn main() { return d.D() }`), 0o644))
+	_, err = CompileDir(root)
+	assert.EqualError(t, err, "undefined: d.D: module d is not imported")
+
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "main.my"), []byte(`import "e"`+"\n"+`fn main() { return }`), 0o644))
+	_, err = CompileDir(root)
+	assert.EqualError(t, err, "function redeclared: e.E")
+
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "main.my"), []byte(`import "x"`+"\n"+`fn main() { return }`), 0o644))
+	_, err = CompileDir(root)
+	assert.EqualError(t, err, "module x: no source files in "+filepath.Join(root, "x"))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "main.my"), []byte(`fn notmain() { return }`), 0o644))
+	_, err = CompileDir(root)
+	assert.EqualError(t, err, "module main: function main is not defined")
+}
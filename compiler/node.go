@@ -6,35 +6,77 @@ const (
 	ST_ILLEGAL Syntax = iota
 
 	ST_DEFINE_FUNCTION
+	ST_EXTERN_FUNCTION
 	ST_FUNCTION_DECLARATION
 	ST_FUNCTION_HEADER
 	ST_FUNCTION_ARGUMENTS
 	ST_FUNCTION_RETURNS
+	ST_FUNCTION_LITERAL
+	ST_CALL
+	ST_CALL_ARGUMENTS
+	ST_SPAWN
+	ST_SEND
+	ST_RECV
 
 	ST_IDENT
 
 	ST_PRIMITIVE
 	ST_INTEGER
 
+	ST_ADD
+	ST_SUB
+	ST_MUL
+	ST_DIV
+	ST_EQ
+	ST_NE
+	ST_LT
+	ST_LE
+	ST_GT
+	ST_GE
+
 	ST_BLOCK
 	ST_RETURN
+	ST_DEFINE_VARIABLE
+	ST_ASSIGN
+	ST_IMPORT
 )
 
 var stKinds = [...]string{
 	ST_ILLEGAL: "ILLEGAL",
 
 	ST_DEFINE_FUNCTION:      "DEFINE_FUNCTION",
+	ST_EXTERN_FUNCTION:      "EXTERN_FUNCTION",
 	ST_FUNCTION_DECLARATION: "FUNCTION_DECLARATION",
 	ST_FUNCTION_HEADER:      "FUNCTION_HEADER",
 	ST_FUNCTION_ARGUMENTS:   "FUNCTION_ARGUMENTS",
 	ST_FUNCTION_RETURNS:     "FUNCTION_RETURNS",
+	ST_FUNCTION_LITERAL:     "FUNCTION_LITERAL",
+	ST_CALL:                 "CALL",
+	ST_CALL_ARGUMENTS:       "CALL_ARGUMENTS",
+	ST_SPAWN:                "SPAWN",
+	ST_SEND:                 "SEND",
+	ST_RECV:                 "RECV",
 
 	ST_IDENT:     "IDENT",
 	ST_PRIMITIVE: "PRIMITIVE",
 	ST_INTEGER:   "INTEGER",
 
-	ST_BLOCK:  "BLOCK",
-	ST_RETURN: "RETURN",
+	ST_ADD: "ADD",
+	ST_SUB: "SUB",
+	ST_MUL: "MUL",
+	ST_DIV: "DIV",
+	ST_EQ:  "EQ",
+	ST_NE:  "NE",
+	ST_LT:  "LT",
+	ST_LE:  "LE",
+	ST_GT:  "GT",
+	ST_GE:  "GE",
+
+	ST_BLOCK:           "BLOCK",
+	ST_RETURN:          "RETURN",
+	ST_DEFINE_VARIABLE: "DEFINE_VARIABLE",
+	ST_ASSIGN:          "ASSIGN",
+	ST_IMPORT:          "IMPORT",
 }
 
 func (st Syntax) String() string {
@@ -47,6 +89,7 @@ type Node struct {
 	lhs  *Node // 1個しか要素がないならLHSを使う
 	rhs  *Node
 	next *Node
+	pos  *Token // 文の先頭のトークン, 生成したコードとソースの対応に使う
 }
 
 func (n *Node) String() string {
@@ -0,0 +1,75 @@
+package compiler
+
+import "strconv"
+
+// Option Generatorの設定
+type Option func(*Generator)
+
+// WithoutOptimization foldによる最適化をしない, 生成される命令をソースの通りに並べたいときに使う
+func WithoutOptimization() Option {
+	return func(g *Generator) {
+		g.optimize = false
+	}
+}
+
+// fold 整数だけの足し算と引き算を計算済みの値にし，x + 0やx - 0をxにする
+// 式の部分だけを書き換えるので，文の位置はそのまま残る
+// 渡した木は書き換えず，畳み込んだ写しを返す
+//
+// MULとDIVは命令がなく，畳み込むと最適化したときだけコンパイルできてしまうので，命令ができるまで残す
+// ifやwhileの条件が定数のときに分岐を消すのも，言語にifとwhileができるまで行わない
+func fold(nd *Node) *Node {
+	if nd == nil {
+		return nil
+	}
+	cp := *nd
+	cp.lhs = fold(nd.lhs)
+	cp.rhs = fold(nd.rhs)
+	cp.next = fold(nd.next)
+	switch cp.kind {
+	case ST_ADD, ST_SUB:
+	default:
+		return &cp
+	}
+
+	folded := &cp
+	lhs, lconst := intValue(cp.lhs)
+	rhs, rconst := intValue(cp.rhs)
+	switch {
+	case lconst && rconst:
+		if v, ok := calculate(cp.kind, lhs, rhs); ok {
+			folded = newIntNode(v, cp.lhs)
+		}
+	case rconst && rhs == 0:
+		folded = cp.lhs
+	case lconst && lhs == 0 && cp.kind == ST_ADD:
+		folded = cp.rhs
+	}
+	folded.next = cp.next
+	return folded
+}
+
+func calculate(kind Syntax, lhs, rhs int) (int, bool) {
+	switch kind {
+	case ST_ADD:
+		return lhs + rhs, true
+	case ST_SUB:
+		return lhs - rhs, true
+	}
+	return 0, false
+}
+
+func intValue(nd *Node) (int, bool) {
+	if nd == nil || nd.kind != ST_PRIMITIVE || nd.lhs.kind != ST_INTEGER {
+		return 0, false
+	}
+	i, err := nd.lhs.leaf.GetInt()
+	return i, err == nil
+}
+
+// newIntNode 畳み込んだ値, 位置は元の式の左端にする
+func newIntNode(v int, at *Node) *Node {
+	tok := NewToken(TK_INT, strconv.Itoa(v))
+	tok.line, tok.column = at.lhs.leaf.line, at.lhs.leaf.column
+	return &Node{kind: ST_PRIMITIVE, lhs: &Node{kind: ST_INTEGER, leaf: tok}}
+}
@@ -0,0 +1,115 @@
+package compiler
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"mylang/runtime"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	compile := func(src string, opts ...Option) (runtime.Program, error) {
+		obj, err := CompileSource(src, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Program, nil
+	}
+	run := func(prog runtime.Program) *runtime.Object {
+		r := runtime.NewRuntime(20, 20)
+		assert.Nil(t, r.Load(prog))
+		assert.Nil(t, r.CollectLabel())
+		assert.Nil(t, r.Run())
+		return r.Register(runtime.REG_STATUS)
+	}
+
+	// 整数だけの足し算と引き算は計算済みの値になる
+	prog, err := compile(`fn main() int { return 2 + 3 + 4 - 1 }`)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.Program{
+		runtime.NewDefLabelOp(runtime.NewLabelObject(0)),
+		runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewObject(8)),
+		runtime.NewReturnOp(),
+	}, prog)
+
+	prog, err = compile(`fn main() int { var x = 5 return 2 + 4 + x }`)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NewObject(11), run(prog))
+
+	// x + 0やx - 0はxと同じ命令になる
+	want, err := compile(`fn main() int { var x = 5 return x }`)
+	assert.Nil(t, err)
+	for _, expr := range []string{"x + 0", "0 + x", "x - 0", "(x + 0) - (3 - 3)"} {
+		prog, err := compile(`fn main() int { var x = 5 return ` + expr + ` }`)
+		assert.Nil(t, err, expr)
+		assert.Equal(t, want, prog, expr)
+	}
+
+	prog, err = compile(`fn main() int { var x = 5 return -3 + x }`)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NewObject(2), run(prog))
+
+	// MULとDIVは命令がないので，定数でも畳み込まずにエラーにする
+	for _, expr := range []string{"2 * 3", "x * 1", "1 * x", "6 / 2", "x / 1"} {
+		_, err := compile(`fn main() int { var x = 5 return ` + expr + ` }`)
+		assert.NotNil(t, err, expr)
+	}
+
+	// 最適化しなければソースの通りに生成する
+	prog, err = compile(`fn main() int { var x = 5 return x + 0 }`, WithoutOptimization())
+	assert.Nil(t, err)
+	assert.NotEqual(t, want, prog)
+	assert.Equal(t, runtime.NewObject(5), run(prog))
+}
+
+func TestFold_SameResult(t *testing.T) {
+	// 最適化してもしなくても，コンパイルできるかと実行結果は変わらない
+	run := func(src string, opts ...Option) (*runtime.Object, string, error) {
+		obj, err := CompileSource(src, opts...)
+		if err != nil {
+			return nil, "", err
+		}
+		var stdout bytes.Buffer
+		r := runtime.NewRuntime(100, 100, runtime.WithStdout(&stdout))
+		assert.Nil(t, r.Load(obj.Program))
+		assert.Nil(t, r.CollectLabel())
+		err = r.Run()
+		return r.Register(runtime.REG_STATUS), stdout.String(), err
+	}
+	for _, src := range []string{
+		`fn main() int { return 2 + 3 - 10 }`,
+		`fn main() int { var x = 5 return (x + 0) - (0 + 1) + (2 - 2) }`,
+		`fn main() { var x = 1 - 1 + 7 println(x + 0) println(0 + x) }`,
+		`fn f(a) int { return a - 0 } fn main() int { return f(4 + 0) + f(0 + 3) }`,
+		`fn main() int { var x = 2 return x < 1 + 2 }`,
+		`fn main() int { return 2 * 3 }`,
+		`fn main() int { var x = 5 return x * 1 }`,
+		`fn main() int { return 10 / 5 + 0 }`,
+		`fn main() int { return 1 / 0 }`,
+	} {
+		want, wantOut, wantErr := run(src, WithoutOptimization())
+		got, gotOut, gotErr := run(src)
+		assert.Equal(t, wantErr, gotErr, src)
+		assert.Equal(t, want, got, src)
+		assert.Equal(t, wantOut, gotOut, src)
+	}
+}
+
+func TestFold_KeepsInput(t *testing.T) {
+	// foldは写しを返し，渡した木は同じまま残る
+	src := `fn main() int { var x = 5 return 2 + 3 + (x - 0) }`
+	nd, err := parseSource(t, src)
+	assert.Nil(t, err)
+	want, err := parseSource(t, src)
+	assert.Nil(t, err)
+	folded := fold(nd)
+	assert.Equal(t, want, nd)
+	assert.NotEqual(t, want, folded)
+
+	// 同じ木から何度生成しても同じ命令になる
+	first, err := Generate(nd)
+	assert.Nil(t, err)
+	second, err := Generate(nd)
+	assert.Nil(t, err)
+	assert.Equal(t, first, second)
+}
@@ -0,0 +1,475 @@
+package compiler
+
+import (
+	"fmt"
+)
+
+// parser 1つのトークン列を読んでいる状態
+type parser struct {
+	tok *Token
+}
+
+func (p *parser) nextToken() {
+	if p.tok.next != nil {
+		p.tok = p.tok.next
+	}
+}
+
+func (p *parser) isSymbol(kind TokenKind) bool {
+	return p.tok.kind == kind
+}
+
+func (p *parser) isKeywordToken(kw string) bool {
+	return p.tok.kind == TK_KEYWORD && p.tok.text == kw
+}
+
+func (p *parser) unexpected(want string) error {
+	return fmt.Errorf("%s: unexpected %s: want %s", p.tok.Position(), p.tok.String(), want)
+}
+
+func (p *parser) expect(kind TokenKind) (*Token, error) {
+	if p.tok.kind != kind {
+		return nil, p.unexpected(kind.String())
+	}
+	t := p.tok
+	p.nextToken()
+	return t, nil
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.isKeywordToken(kw) {
+		return p.unexpected(kw)
+	}
+	p.nextToken()
+	return nil
+}
+
+// Parse トークン列をトップレベルの宣言の連結リストにする
+//
+//	program    = { import | function | extern }
+//	import     = "import" STRING
+//	function   = "fn" IDENT arguments [IDENT] block
+//	extern     = "extern" "fn" IDENT arguments [IDENT]
+//	arguments  = "(" [IDENT {"," IDENT}] ")"
+//	block      = "{" { statement } "}"
+//	statement  = "return" [expr] | "var" IDENT "=" expr | IDENT "=" expr | expr "<-" expr | call | spawn | recv
+//	expr       = equality
+//	equality   = relational { ("==" | "!=") relational }
+//	relational = add { ("<" | "<=" | ">" | ">=") add }
+//	add        = mul { ("+" | "-") mul }
+//	mul        = unary { ("*" | "/") unary }
+//	unary      = ["-"] postfix | spawn | recv
+//	spawn      = "spawn" call
+//	recv       = "<-" unary
+//	postfix    = primary { "(" [expr {"," expr}] ")" }
+//	primary    = INT | IDENT ["." IDENT] | "fn" arguments [IDENT] block | "(" expr ")"
+func Parse(head *Token) (*Node, error) {
+	p := &parser{tok: head}
+	top := &Node{} // dummy
+	tail := top
+	for !p.isSymbol(TK_EOF) {
+		var nd *Node
+		var err error
+		switch {
+		case p.isKeywordToken("import"):
+			nd, err = p.parseImport()
+		case p.isKeywordToken("fn"):
+			nd, err = p.parseDefineFunction()
+		case p.isKeywordToken("extern"):
+			nd, err = p.parseExternFunction()
+		default:
+			err = p.unexpected("import, fn or extern")
+		}
+		if err != nil {
+			return nil, err
+		}
+		tail.next = nd
+		tail = nd
+	}
+	return top.next, nil
+}
+
+func (p *parser) parseImport() (*Node, error) {
+	start := p.tok
+	if err := p.expectKeyword("import"); err != nil {
+		return nil, err
+	}
+	path, err := p.expect(TK_STRING)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{kind: ST_IMPORT, leaf: path, pos: start}, nil
+}
+
+func (p *parser) parseIdent() (*Node, error) {
+	id, err := p.expect(TK_IDENT)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{kind: ST_IDENT, leaf: id}, nil
+}
+
+func (p *parser) parseFunctionArguments() (*Node, error) {
+	if _, err := p.expect(TK_LRB); err != nil {
+		return nil, err
+	}
+	args := &Node{kind: ST_FUNCTION_ARGUMENTS}
+	head := &Node{} // dummy
+	tail := head
+	for !p.isSymbol(TK_RRB) {
+		if head != tail {
+			if _, err := p.expect(TK_COMMA); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		tail.next = arg
+		tail = arg
+	}
+	p.nextToken()
+	args.lhs = head.next
+	return args, nil
+}
+
+func (p *parser) parseFunctionReturns() (*Node, error) {
+	returns := &Node{kind: ST_FUNCTION_RETURNS}
+	if p.isSymbol(TK_IDENT) {
+		typ, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		returns.lhs = typ
+	}
+	return returns, nil
+}
+
+func (p *parser) parseDefineFunction() (*Node, error) {
+	start := p.tok
+	if err := p.expectKeyword("fn"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	args, err := p.parseFunctionArguments()
+	if err != nil {
+		return nil, err
+	}
+	returns, err := p.parseFunctionReturns()
+	if err != nil {
+		return nil, err
+	}
+	block, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{
+		kind: ST_DEFINE_FUNCTION,
+		lhs: &Node{
+			kind: ST_FUNCTION_DECLARATION,
+			lhs:  &Node{kind: ST_FUNCTION_HEADER, lhs: name, rhs: args},
+			rhs:  returns,
+		},
+		rhs: block,
+		pos: start,
+	}, nil
+}
+
+// parseExternFunction 本体のない関数の宣言, 呼ぶと埋め込んだ側が登録したホスト関数になる
+func (p *parser) parseExternFunction() (*Node, error) {
+	start := p.tok
+	if err := p.expectKeyword("extern"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("fn"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	args, err := p.parseFunctionArguments()
+	if err != nil {
+		return nil, err
+	}
+	returns, err := p.parseFunctionReturns()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{
+		kind: ST_EXTERN_FUNCTION,
+		lhs: &Node{
+			kind: ST_FUNCTION_DECLARATION,
+			lhs:  &Node{kind: ST_FUNCTION_HEADER, lhs: name, rhs: args},
+			rhs:  returns,
+		},
+		pos: start,
+	}, nil
+}
+
+func (p *parser) parseBlock() (*Node, error) {
+	if _, err := p.expect(TK_LCB); err != nil {
+		return nil, err
+	}
+	head := &Node{} // dummy
+	tail := head
+	for !p.isSymbol(TK_RCB) {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		tail.next = stmt
+		tail = stmt
+	}
+	p.nextToken()
+	return &Node{kind: ST_BLOCK, lhs: head.next}, nil
+}
+
+func (p *parser) parseStatement() (*Node, error) {
+	start := p.tok
+	nd, err := p.parseStatementBody()
+	if err != nil {
+		return nil, err
+	}
+	nd.pos = start
+	return nd, nil
+}
+
+func (p *parser) parseStatementBody() (*Node, error) {
+	switch {
+	case p.isKeywordToken("return"):
+		p.nextToken()
+		if p.isSymbol(TK_RCB) {
+			return &Node{kind: ST_RETURN}, nil
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{kind: ST_RETURN, lhs: value}, nil
+	case p.isKeywordToken("var"):
+		p.nextToken()
+		name, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(TK_ASSIGN); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{kind: ST_DEFINE_VARIABLE, lhs: name, rhs: value}, nil
+	}
+
+	start := p.tok
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.isSymbol(TK_ASSIGN) {
+		if expr.kind != ST_IDENT {
+			return nil, fmt.Errorf("%s: cannot assign to %s", start.Position(), expr.kind.String())
+		}
+		p.nextToken()
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{kind: ST_ASSIGN, lhs: expr, rhs: value}, nil
+	}
+	if p.isSymbol(TK_ARROW) { // ch <- v
+		p.nextToken()
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{kind: ST_SEND, lhs: expr, rhs: value}, nil
+	}
+	if expr.kind != ST_CALL && expr.kind != ST_SPAWN && expr.kind != ST_RECV {
+		return nil, fmt.Errorf("%s: %s is not a statement", start.Position(), expr.kind.String())
+	}
+	return expr, nil
+}
+
+func (p *parser) parseExpr() (*Node, error) {
+	return p.parseEquality()
+}
+
+// parseBinary 左結合の二項演算子をまとめて読む
+func (p *parser) parseBinary(next func() (*Node, error), ops map[TokenKind]Syntax) (*Node, error) {
+	lhs, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		kind, ok := ops[p.tok.kind]
+		if !ok {
+			return lhs, nil
+		}
+		p.nextToken()
+		rhs, err := next()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &Node{kind: kind, lhs: lhs, rhs: rhs}
+	}
+}
+
+func (p *parser) parseEquality() (*Node, error) {
+	return p.parseBinary(p.parseRelational, map[TokenKind]Syntax{
+		TK_EQ: ST_EQ,
+		TK_NE: ST_NE,
+	})
+}
+
+func (p *parser) parseRelational() (*Node, error) {
+	return p.parseBinary(p.parseAdd, map[TokenKind]Syntax{
+		TK_LT: ST_LT,
+		TK_LE: ST_LE,
+		TK_GT: ST_GT,
+		TK_GE: ST_GE,
+	})
+}
+
+func (p *parser) parseAdd() (*Node, error) {
+	return p.parseBinary(p.parseMul, map[TokenKind]Syntax{
+		TK_ADD: ST_ADD,
+		TK_SUB: ST_SUB,
+	})
+}
+
+// parseMul *と/はまだ命令がないので，生成まで進まずにここでエラーにする
+func (p *parser) parseMul() (*Node, error) {
+	nd, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isSymbol(TK_MUL) || p.isSymbol(TK_DIV) {
+		return nil, fmt.Errorf("%s: operator %s is not supported", p.tok.Position(), p.tok.text)
+	}
+	return nd, nil
+}
+
+func (p *parser) parseUnary() (*Node, error) {
+	if p.isKeywordToken("spawn") {
+		return p.parseSpawn()
+	}
+	if p.isSymbol(TK_ARROW) { // <-ch
+		p.nextToken()
+		ch, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{kind: ST_RECV, lhs: ch}, nil
+	}
+	if p.isSymbol(TK_SUB) {
+		zero := NewToken(TK_INT, "0")
+		zero.line, zero.column = p.tok.line, p.tok.column
+		p.nextToken()
+		operand, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{kind: ST_SUB, lhs: &Node{kind: ST_PRIMITIVE, lhs: &Node{kind: ST_INTEGER, leaf: zero}}, rhs: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parseSpawn spawn f(x)は関数呼び出しを新しいスレッドで始める
+func (p *parser) parseSpawn() (*Node, error) {
+	start := p.tok
+	p.nextToken()
+	call, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	if call.kind != ST_CALL {
+		return nil, fmt.Errorf("%s: spawn needs a call: got %s", start.Position(), call.kind.String())
+	}
+	return &Node{kind: ST_SPAWN, lhs: call}, nil
+}
+
+func (p *parser) parsePostfix() (*Node, error) {
+	nd, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol(TK_LRB) {
+		p.nextToken()
+		head := &Node{} // dummy
+		tail := head
+		for !p.isSymbol(TK_RRB) {
+			if head != tail {
+				if _, err := p.expect(TK_COMMA); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			tail.next = arg
+			tail = arg
+		}
+		p.nextToken()
+		nd = &Node{kind: ST_CALL, lhs: nd, rhs: &Node{kind: ST_CALL_ARGUMENTS, lhs: head.next}}
+	}
+	return nd, nil
+}
+
+func (p *parser) parsePrimary() (*Node, error) {
+	switch {
+	case p.isSymbol(TK_INT):
+		i := p.tok
+		p.nextToken()
+		return &Node{kind: ST_PRIMITIVE, lhs: &Node{kind: ST_INTEGER, leaf: i}}, nil
+	case p.isSymbol(TK_IDENT):
+		id, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		if p.isSymbol(TK_DOT) { // module.Name
+			p.nextToken()
+			name, err := p.expect(TK_IDENT)
+			if err != nil {
+				return nil, err
+			}
+			qualified := NewToken(TK_IDENT, id.leaf.text+"."+name.text)
+			qualified.line, qualified.column = id.leaf.line, id.leaf.column
+			id.leaf = qualified
+		}
+		return id, nil
+	case p.isKeywordToken("fn"):
+		p.nextToken()
+		args, err := p.parseFunctionArguments()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.parseFunctionReturns(); err != nil {
+			return nil, err
+		}
+		block, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{kind: ST_FUNCTION_LITERAL, lhs: args, rhs: block}, nil
+	case p.isSymbol(TK_LRB):
+		p.nextToken()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(TK_RRB); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	default:
+		return nil, p.unexpected("expression")
+	}
+}
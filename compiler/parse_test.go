@@ -0,0 +1,146 @@
+package compiler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func parseSource(t *testing.T, src string) (*Node, error) {
+	t.Helper()
+	head, err := Tokenize(src)
+	assert.Nil(t, err)
+	return Parse(head)
+}
+
+func TestParse_DefineFunction(t *testing.T) {
+	nd, err := parseSource(t, "fn main(arg1, arg2) int { return 100 }")
+	assert.Nil(t, err)
+	prog, err := Generate(nd)
+	assert.Nil(t, err)
+
+	// 手で組み立てた木と同じコードになる
+	expected, err := Generate(&Node{
+		kind: ST_DEFINE_FUNCTION,
+		lhs: &Node{
+			kind: ST_FUNCTION_DECLARATION,
+			lhs: &Node{
+				kind: ST_FUNCTION_HEADER,
+				lhs:  &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "main")},
+				rhs: &Node{
+					kind: ST_FUNCTION_ARGUMENTS,
+					lhs: &Node{
+						kind: ST_IDENT,
+						leaf: NewToken(TK_IDENT, "arg1"),
+						next: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "arg2")},
+					},
+				},
+			},
+			rhs: &Node{kind: ST_FUNCTION_RETURNS, lhs: &Node{kind: ST_IDENT, leaf: NewToken(TK_IDENT, "int")}},
+		},
+		rhs: &Node{
+			kind: ST_BLOCK,
+			lhs:  &Node{kind: ST_RETURN, lhs: &Node{kind: ST_PRIMITIVE, lhs: &Node{kind: ST_INTEGER, leaf: NewToken(TK_INT, "100")}}},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, prog)
+}
+
+func TestParse_Expression(t *testing.T) {
+	nd, err := parseSource(t, "fn main() { return 1 - 2 + f(3, x) > y }")
+	assert.Nil(t, err)
+	ret := nd.rhs.lhs
+	assert.Equal(t, ST_RETURN, ret.kind)
+	// a > b はソースの順のまま
+	cmp := ret.lhs
+	assert.Equal(t, ST_GT, cmp.kind)
+	assert.Equal(t, ST_IDENT, cmp.rhs.kind)
+	assert.Equal(t, "y", cmp.rhs.leaf.text)
+	// (1 - 2) + f(3, x)
+	add := cmp.lhs
+	assert.Equal(t, ST_ADD, add.kind)
+	assert.Equal(t, ST_SUB, add.lhs.kind)
+	assert.Equal(t, ST_CALL, add.rhs.kind)
+	assert.Equal(t, "f", add.rhs.lhs.leaf.text)
+	assert.Equal(t, "3", add.rhs.rhs.lhs.lhs.leaf.text)
+	assert.Equal(t, "x", add.rhs.rhs.lhs.next.leaf.text)
+}
+
+func TestParse_Statements(t *testing.T) {
+	nd, err := parseSource(t, `fn main() {
+	var f = fn(a) { return a }
+	f = math.Abs
+	f(1)
+	return
+}`)
+	assert.Nil(t, err)
+	stmt := nd.rhs.lhs
+	assert.Equal(t, ST_DEFINE_VARIABLE, stmt.kind)
+	assert.Equal(t, ST_FUNCTION_LITERAL, stmt.rhs.kind)
+	stmt = stmt.next
+	assert.Equal(t, ST_ASSIGN, stmt.kind)
+	assert.Equal(t, "math.Abs", stmt.rhs.leaf.text)
+	stmt = stmt.next
+	assert.Equal(t, ST_CALL, stmt.kind)
+	stmt = stmt.next
+	assert.Equal(t, ST_RETURN, stmt.kind)
+	assert.Nil(t, stmt.lhs)
+	assert.Nil(t, stmt.next)
+}
+
+func TestParse_Error(t *testing.T) {
+	_, err := parseSource(t, "fn main() { 1 + 2 }")
+	assert.EqualError(t, err, "1:13: ADD is not a statement")
+	_, err = parseSource(t, "fn main() { return 1")
+	assert.EqualError(t, err, "1:21: unexpected EOF: want expression")
+	_, err = parseSource(t, "var x = 1")
+	assert.EqualError(t, err, "1:1: unexpected var: want import, fn or extern")
+	// *と/は生成できないので読むときにエラーにする
+	_, err = parseSource(t, "fn main() { return 2 * 3 }")
+	assert.EqualError(t, err, "1:22: operator * is not supported")
+	_, err = parseSource(t, "fn main() { return 1 + 6 / x }")
+	assert.EqualError(t, err, "1:26: operator / is not supported")
+}
+
+func TestParse_Spawn(t *testing.T) {
+	nd, err := parseSource(t, "fn main() { spawn f(1) var t = spawn g.h() }")
+	assert.Nil(t, err)
+	stmt := nd.rhs.lhs
+	assert.Equal(t, ST_SPAWN, stmt.kind)
+	assert.Equal(t, ST_CALL, stmt.lhs.kind)
+	assert.Equal(t, "f", stmt.lhs.lhs.leaf.text)
+	stmt = stmt.next
+	assert.Equal(t, ST_DEFINE_VARIABLE, stmt.kind)
+	assert.Equal(t, ST_SPAWN, stmt.rhs.kind)
+	assert.Equal(t, "g.h", stmt.rhs.lhs.lhs.leaf.text)
+
+	_, err = parseSource(t, "fn main() { spawn f }")
+	assert.EqualError(t, err, "1:13: spawn needs a call: got IDENT")
+}
+
+func TestParse_Channel(t *testing.T) {
+	nd, err := parseSource(t, "fn main() { ch <- 1 + 2 var v = <-ch <-ch }")
+	assert.Nil(t, err)
+	stmt := nd.rhs.lhs
+	assert.Equal(t, ST_SEND, stmt.kind)
+	assert.Equal(t, "ch", stmt.lhs.leaf.text)
+	assert.Equal(t, ST_ADD, stmt.rhs.kind)
+	stmt = stmt.next
+	assert.Equal(t, ST_DEFINE_VARIABLE, stmt.kind)
+	assert.Equal(t, ST_RECV, stmt.rhs.kind)
+	assert.Equal(t, "ch", stmt.rhs.lhs.leaf.text)
+	stmt = stmt.next
+	assert.Equal(t, ST_RECV, stmt.kind)
+}
+
+func TestParse_Extern(t *testing.T) {
+	nd, err := parseSource(t, "extern fn now() int fn main() { return now() }")
+	assert.Nil(t, err)
+	assert.Equal(t, ST_EXTERN_FUNCTION, nd.kind)
+	assert.Equal(t, "now", nd.lhs.lhs.lhs.leaf.text)
+	assert.Equal(t, "int", nd.lhs.rhs.lhs.leaf.text)
+	assert.Equal(t, ST_DEFINE_FUNCTION, nd.next.kind)
+
+	_, err = parseSource(t, "extern now()")
+	assert.EqualError(t, err, "1:8: unexpected now: want fn")
+}
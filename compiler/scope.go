@@ -0,0 +1,63 @@
+package compiler
+
+// scope 生成中の関数1つ分の変数の置き場所
+// 引数とローカル変数はENTERで作るフレームに，キャプチャした変数はクロージャの環境に置く
+// キャプチャした変数はMAKE_CLOSUREのときの値の写しなので，書き換えても元の変数には届かない
+type scope struct {
+	name     string
+	locals   map[string]int // フレームのスロット
+	captures map[string]int // クロージャの環境のスロット
+	size     int
+	hasFrame bool
+	literals int // 関数リテラルの連番
+}
+
+func newScope(name string, params []string, captures []string, body *Node) *scope {
+	sc := &scope{
+		name:     name,
+		locals:   make(map[string]int),
+		captures: make(map[string]int),
+	}
+	for _, param := range params {
+		sc.declare(param)
+	}
+	for _, local := range collectLocals(body) {
+		sc.declare(local)
+	}
+	for i, capture := range captures {
+		sc.captures[capture] = i
+	}
+	sc.hasFrame = sc.size != 0
+	return sc
+}
+
+func (sc *scope) declare(name string) {
+	if _, ok := sc.locals[name]; ok {
+		return
+	}
+	sc.locals[name] = sc.size
+	sc.size++
+}
+
+// captured nameがローカル変数でなくキャプチャした変数か
+func (sc *scope) captured(name string) bool {
+	if _, ok := sc.locals[name]; ok {
+		return false
+	}
+	_, ok := sc.captures[name]
+	return ok
+}
+
+// lookup 変数の環境の深さとスロットを返す
+func (sc *scope) lookup(name string) (int, int, bool) {
+	if slot, ok := sc.locals[name]; ok {
+		return 0, slot, true
+	}
+	if slot, ok := sc.captures[name]; ok {
+		if sc.hasFrame { // フレームの親がクロージャの環境
+			return 1, slot, true
+		}
+		return 0, slot, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,224 @@
+package compiler
+
+import (
+	"fmt"
+	"maps"
+	"mylang/runtime"
+)
+
+// replFrameSize REPLのトップレベルで宣言できる変数の数
+const replFrameSize = 256
+
+// Session REPLの入力を1つずつコンパイルし，前の入力までのプログラムの後ろに足せるようにする
+// 定義した関数とトップレベルの変数は後の入力からも使える
+// 変数はInitの関数が作ったフレームに置き，入力ごとの関数はフレームを作らずにそれを使う
+type Session struct {
+	g        *Generator
+	declared map[string]bool
+	globals  *scope
+	entries  int
+	last     *sessionState // 直前にコンパイルした入力の前の状態, Discardで戻す
+}
+
+// Entry 1つの入力をコンパイルしたもの
+type Entry struct {
+	Object *runtime.ObjectFile // 前の入力までのプログラムの後ろに足す
+	Label  int                 // 呼び出す関数, 関数を定義しただけなら-1
+	Expr   bool                // 式ならCallの後のSTATUSが値, 値を返さなければnull
+}
+
+func NewSession(opts ...Option) *Session {
+	g := NewGenerator(opts...)
+	g.lc = NewLabelCollector()
+	g.lc.Init()
+	g.mod = &Module{name: mainModule}
+	g.curt = &Node{}
+	g.positions = make(map[*runtime.Operation]SourcePos)
+	g.externs = make(map[int]int)
+	return &Session{
+		g:        g,
+		declared: make(map[string]bool),
+		globals: &scope{
+			name:     "repl",
+			locals:   make(map[string]int),
+			captures: make(map[string]int),
+		},
+	}
+}
+
+// Init 変数を置くフレームを作る関数, 最初の入力より前に1度だけ足して呼ぶ
+// LEAVEせずに戻るので，作ったフレームは呼んだ後もENVに残る
+func (s *Session) Init() *Entry {
+	label, _ := s.g.lc.Set("repl.init")
+	prog := runtime.Program{
+		runtime.NewDefLabelOp(runtime.NewLabelObject(label)),
+		runtime.NewEnterOp(runtime.NewObject(replFrameSize)),
+		runtime.NewReturnOp(),
+	}
+	return &Entry{Object: s.object(prog), Label: label}
+}
+
+// Compile 入力を1つコンパイルする
+// 入力はトップレベルの宣言の並び，1つの式，文の並びのどれか
+// エラーになったときはその入力で宣言した関数や変数を残さない
+func (s *Session) Compile(src string) (*Entry, error) {
+	head, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	s.entries++
+	saved := s.save()
+	entry, err := s.compile(head)
+	if err != nil {
+		s.restore(saved)
+		return nil, err
+	}
+	s.last = &saved
+	return entry, nil
+}
+
+// Discard 直前にCompileした入力で宣言した関数や変数を取り消す
+// コンパイルできてもRuntimeに足せなかったときに，後の入力から使えないようにする
+func (s *Session) Discard() {
+	if s.last != nil {
+		s.restore(*s.last)
+		s.last = nil
+	}
+}
+
+func (s *Session) compile(head *Token) (*Entry, error) {
+	g := s.g
+	g.literals = nil
+	if isDeclaration(head) {
+		nd, err := Parse(head)
+		if err != nil {
+			return nil, err
+		}
+		if g.optimize {
+			nd = fold(nd)
+		}
+		for decl := nd; decl != nil; decl = decl.next {
+			if decl.kind == ST_IMPORT {
+				return nil, fmt.Errorf("%s: import is not supported in repl", decl.pos.Position())
+			}
+		}
+		if err := g.declareFunctions(nd, s.declared); err != nil {
+			return nil, err
+		}
+		prog, err := g.genStatements(nd)
+		if err != nil {
+			return nil, err
+		}
+		return &Entry{Object: s.object(append(prog, g.literals...)), Label: -1}, nil
+	}
+
+	block, expr, err := parseEntry(head)
+	if err != nil {
+		return nil, err
+	}
+	if g.optimize {
+		block = fold(block)
+	}
+	label, err := g.lc.Set(fmt.Sprintf("repl.%d", s.entries))
+	if err != nil {
+		return nil, err
+	}
+	for _, local := range collectLocals(block) {
+		s.globals.declare(local)
+	}
+	if replFrameSize < s.globals.size {
+		return nil, fmt.Errorf("too many variables in repl: max=%d", replFrameSize)
+	}
+	g.sc = s.globals
+	defer func() { g.sc = nil }()
+
+	prog := runtime.Program{
+		runtime.NewDefLabelOp(runtime.NewLabelObject(label)),
+	}
+	if expr { // 値を返さない組み込み関数の結果をnullにする
+		prog = append(prog, runtime.NewMoveOp(runtime.NewRegisterObject(runtime.REG_STATUS), runtime.NewNullObject()))
+	}
+	blockProg, err := g.genBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	prog = append(prog, blockProg...)
+	if !endsWithReturn(block) {
+		prog = append(prog, g.genEpilogue()...)
+	}
+	return &Entry{Object: s.object(append(prog, g.literals...)), Label: label, Expr: expr}, nil
+}
+
+// isDeclaration 関数リテラルでないfn，externとimportで始まる入力はトップレベルの宣言
+func isDeclaration(head *Token) bool {
+	p := &parser{tok: head}
+	switch {
+	case p.isKeywordToken("extern"), p.isKeywordToken("import"):
+		return true
+	case p.isKeywordToken("fn"):
+		return head.next != nil && head.next.kind == TK_IDENT
+	}
+	return false
+}
+
+// parseEntry 式だけの入力はreturnする文にして，文の並びをブロックにする
+//
+//	entry = expr | { statement }
+func parseEntry(head *Token) (*Node, bool, error) {
+	p := &parser{tok: head}
+	if nd, err := p.parseExpr(); err == nil && p.isSymbol(TK_EOF) {
+		ret := &Node{kind: ST_RETURN, lhs: nd, pos: head}
+		return &Node{kind: ST_BLOCK, lhs: ret}, true, nil
+	}
+
+	p = &parser{tok: head}
+	top := &Node{} // dummy
+	tail := top
+	for !p.isSymbol(TK_EOF) {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, false, err
+		}
+		tail.next = stmt
+		tail = stmt
+	}
+	return &Node{kind: ST_BLOCK, lhs: top.next}, false, nil
+}
+
+func (s *Session) object(prog runtime.Program) *runtime.ObjectFile {
+	return runtime.NewObjectFile(prog, maps.Clone(s.g.lc.label))
+}
+
+// sessionState 失敗した入力を取り消すために覚えておく状態
+type sessionState struct {
+	labels   map[string]int
+	counter  int
+	declared map[string]bool
+	externs  map[int]int
+	locals   map[string]int
+	size     int
+	literals int
+}
+
+func (s *Session) save() sessionState {
+	return sessionState{
+		labels:   maps.Clone(s.g.lc.label),
+		counter:  s.g.lc.counter,
+		declared: maps.Clone(s.declared),
+		externs:  maps.Clone(s.g.externs),
+		locals:   maps.Clone(s.globals.locals),
+		size:     s.globals.size,
+		literals: s.globals.literals,
+	}
+}
+
+func (s *Session) restore(st sessionState) {
+	s.g.lc.label = st.labels
+	s.g.lc.counter = st.counter
+	s.declared = st.declared
+	s.g.externs = st.externs
+	s.globals.locals = st.locals
+	s.globals.size = st.size
+	s.globals.literals = st.literals
+	s.g.literals = nil
+}
@@ -0,0 +1,79 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"mylang/runtime"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	var stdout bytes.Buffer
+	r := runtime.NewRuntime(100, 1024, runtime.WithStdout(&stdout))
+	assert.Nil(t, r.Reload(runtime.Program{}))
+	s := NewSession()
+
+	eval := func(src string) (*runtime.Object, error) {
+		t.Helper()
+		entry, err := s.Compile(src)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.AppendObject(entry.Object); err != nil {
+			return nil, err
+		}
+		if entry.Label < 0 {
+			return nil, nil
+		}
+		if err := r.Call(context.Background(), entry.Label); err != nil {
+			return nil, err
+		}
+		if !entry.Expr {
+			return nil, nil
+		}
+		return r.Register(runtime.REG_STATUS), nil
+	}
+
+	init := s.Init()
+	assert.Nil(t, r.AppendObject(init.Object))
+	assert.Nil(t, r.Call(context.Background(), init.Label))
+
+	// 変数と関数は後の入力からも使える
+	_, err := eval(`var x = 40`)
+	assert.Nil(t, err)
+	_, err = eval(`fn add(a, b) int { return a + b }`)
+	assert.Nil(t, err)
+	v, err := eval(`add(x, 2)`)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NewObject(42), v)
+	_, err = eval(`x = x + 1 var f = fn(y) { return x + y }`)
+	assert.Nil(t, err)
+	v, err = eval(`f(1)`)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NewObject(42), v)
+
+	// 値を返さない組み込み関数はnull
+	v, err = eval(`println(x)`)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NewNullObject(), v)
+	assert.Equal(t, "41\n", stdout.String())
+
+	// エラーになった入力の宣言は残らない
+	_, err = eval(`var y = 1 y = undefined`)
+	assert.NotNil(t, err)
+	_, err = eval(`y`)
+	assert.EqualError(t, err, "genLoadIdent: undefined: y")
+	_, err = eval(`fn sub(a, b) int { return a - c }`)
+	assert.NotNil(t, err)
+	_, err = eval(`fn sub(a, b) int { return a - b }`)
+	assert.Nil(t, err)
+	v, err = eval(`sub(x, 1)`)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NewObject(40), v)
+
+	_, err = eval(`fn add(a, b) int { return a }`)
+	assert.EqualError(t, err, "function redeclared: add")
+	_, err = eval(`import "math"`)
+	assert.EqualError(t, err, "1:1: import is not supported in repl")
+}
@@ -20,10 +20,12 @@ const (
 	TK_COMMENT    // // this is comment, start with double slash
 	TK_WHITESPACE // " ", "\n", "\t"
 
-	TK_LRB // (
-	TK_RRB // )
-	TK_LCB // {
-	TK_RCB // }
+	TK_LRB   // (
+	TK_RRB   // )
+	TK_LCB   // {
+	TK_RCB   // }
+	TK_COMMA // ,
+	TK_DOT   // .
 
 	TK_EQ // ==
 	TK_NE // !=
@@ -37,6 +39,9 @@ const (
 	TK_SUB    // -
 	TK_MUL    // *
 	TK_DIV    // /
+	TK_ARROW  // <-
+
+	TK_EOF
 )
 
 var tokKinds = [...]string{
@@ -52,10 +57,12 @@ var tokKinds = [...]string{
 	TK_WHITESPACE: "WHITESPACE",
 	TK_COMMENT:    "COMMENT",
 
-	TK_LRB: "(",
-	TK_RRB: ")",
-	TK_LCB: "{",
-	TK_RCB: "}",
+	TK_LRB:   "(",
+	TK_RRB:   ")",
+	TK_LCB:   "{",
+	TK_RCB:   "}",
+	TK_COMMA: ",",
+	TK_DOT:   ".",
 
 	TK_EQ: "==",
 	TK_NE: "!=",
@@ -69,6 +76,9 @@ var tokKinds = [...]string{
 	TK_SUB:    "-",
 	TK_MUL:    "*",
 	TK_DIV:    "/",
+	TK_ARROW:  "<-",
+
+	TK_EOF: "EOF",
 }
 
 func (tk TokenKind) String() string {
@@ -83,8 +93,26 @@ func NewToken(kind TokenKind, text string) *Token {
 }
 
 type Token struct {
-	kind TokenKind
-	text string
+	kind   TokenKind
+	text   string
+	line   int
+	column int
+	next   *Token
+}
+
+func (t *Token) Position() string {
+	return fmt.Sprintf("%d:%d", t.line, t.column)
+}
+
+func (t *Token) String() string {
+	switch t.kind {
+	case TK_INT, TK_FLOAT, TK_IDENT, TK_KEYWORD:
+		return t.text
+	case TK_STRING:
+		return strconv.Quote(t.text)
+	default:
+		return t.kind.String()
+	}
 }
 
 func (t *Token) GetInt() (int, error) {
@@ -0,0 +1,176 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var keywords = []string{
+	"fn",
+	"var",
+	"return",
+	"import",
+	"spawn",
+	"extern",
+}
+
+func isKeyword(text string) bool {
+	for _, kw := range keywords {
+		if kw == text {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+func hasPrefixAt(runes []rune, pos int, prefix string) bool {
+	for _, r := range prefix {
+		if len(runes) <= pos || runes[pos] != r {
+			return false
+		}
+		pos++
+	}
+	return true
+}
+
+// 2文字の記号を先に見る
+var symbols = []struct {
+	text string
+	kind TokenKind
+}{
+	{"==", TK_EQ},
+	{"!=", TK_NE},
+	{"<=", TK_LE},
+	{">=", TK_GE},
+	{"<-", TK_ARROW},
+	{"(", TK_LRB},
+	{")", TK_RRB},
+	{"{", TK_LCB},
+	{"}", TK_RCB},
+	{",", TK_COMMA},
+	{".", TK_DOT},
+	{"<", TK_LT},
+	{">", TK_GT},
+	{"=", TK_ASSIGN},
+	{"+", TK_ADD},
+	{"-", TK_SUB},
+	{"*", TK_MUL},
+	{"/", TK_DIV},
+}
+
+// Tokenize ソースをトークンの連結リストにする, 空白とコメントは捨てる
+// 最後には必ずTK_EOFが付く
+func Tokenize(src string) (*Token, error) {
+	head := &Token{} // dummy
+	tail := head
+	runes := []rune(src)
+	line, column := 1, 1
+	pos := 0
+
+	add := func(kind TokenKind, text string, l, c int) {
+		tail.next = &Token{kind: kind, text: text, line: l, column: c}
+		tail = tail.next
+	}
+	advance := func(n int) {
+		for i := 0; i < n; i++ {
+			if runes[pos] == '\n' {
+				line++
+				column = 1
+			} else {
+				column++
+			}
+			pos++
+		}
+	}
+
+tokenLoop:
+	for pos < len(runes) {
+		r := runes[pos]
+		startLine, startColumn := line, column
+		switch {
+		case unicode.IsSpace(r):
+			advance(1)
+		case hasPrefixAt(runes, pos, "//"):
+			for pos < len(runes) && runes[pos] != '\n' {
+				advance(1)
+			}
+		case unicode.IsDigit(r):
+			start := pos
+			kind := TK_INT
+			for pos < len(runes) && unicode.IsDigit(runes[pos]) {
+				advance(1)
+			}
+			if pos+1 < len(runes) && runes[pos] == '.' && unicode.IsDigit(runes[pos+1]) {
+				kind = TK_FLOAT
+				advance(1)
+				for pos < len(runes) && unicode.IsDigit(runes[pos]) {
+					advance(1)
+				}
+			}
+			add(kind, string(runes[start:pos]), startLine, startColumn)
+		case isIdentStart(r):
+			start := pos
+			for pos < len(runes) && isIdentPart(runes[pos]) {
+				advance(1)
+			}
+			text := string(runes[start:pos])
+			switch {
+			case text == "null":
+				add(TK_NULL, text, startLine, startColumn)
+			case isKeyword(text):
+				add(TK_KEYWORD, text, startLine, startColumn)
+			default:
+				add(TK_IDENT, text, startLine, startColumn)
+			}
+		case r == '"':
+			advance(1)
+			var sb strings.Builder
+			for {
+				if pos >= len(runes) || runes[pos] == '\n' {
+					return nil, fmt.Errorf("%d:%d: unterminated string", startLine, startColumn)
+				}
+				c := runes[pos]
+				if c == '"' {
+					advance(1)
+					break
+				}
+				if c == '\\' && pos+1 < len(runes) {
+					switch runes[pos+1] {
+					case 'n':
+						c = '\n'
+					case 't':
+						c = '\t'
+					case '"', '\\':
+						c = runes[pos+1]
+					default:
+						return nil, fmt.Errorf("%d:%d: unknown escape: \\%c", line, column, runes[pos+1])
+					}
+					advance(1)
+				}
+				sb.WriteRune(c)
+				advance(1)
+			}
+			add(TK_STRING, sb.String(), startLine, startColumn)
+		default:
+			for _, sym := range symbols {
+				if hasPrefixAt(runes, pos, sym.text) {
+					add(sym.kind, sym.text, startLine, startColumn)
+					advance(len([]rune(sym.text)))
+					continue tokenLoop
+				}
+			}
+			return nil, fmt.Errorf("%d:%d: unexpected character: %q", startLine, startColumn, r)
+		}
+	}
+	add(TK_EOF, "", line, column)
+	return head.next, nil
+}
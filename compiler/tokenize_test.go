@@ -0,0 +1,45 @@
+package compiler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	head, err := Tokenize(`import "math" // comment
+fn main() int { return math.Abs(-12) <= 3 }`)
+	assert.Nil(t, err)
+	kinds := []TokenKind{}
+	texts := []string{}
+	for tk := head; tk != nil; tk = tk.next {
+		kinds = append(kinds, tk.kind)
+		texts = append(texts, tk.text)
+	}
+	assert.Equal(t, []TokenKind{
+		TK_KEYWORD, TK_STRING,
+		TK_KEYWORD, TK_IDENT, TK_LRB, TK_RRB, TK_IDENT, TK_LCB,
+		TK_KEYWORD, TK_IDENT, TK_DOT, TK_IDENT, TK_LRB, TK_SUB, TK_INT, TK_RRB, TK_LE, TK_INT,
+		TK_RCB, TK_EOF,
+	}, kinds)
+	assert.Equal(t, "math", texts[1])
+	assert.Equal(t, "12", texts[14])
+	assert.Equal(t, "2:1", head.next.next.Position())
+}
+
+func TestTokenize_Error(t *testing.T) {
+	_, err := Tokenize("fn main() {\n  return @\n}")
+	assert.EqualError(t, err, "2:10: unexpected character: '@'")
+	_, err = Tokenize(`import "math`)
+	assert.EqualError(t, err, "1:8: unterminated string")
+}
+
+func TestTokenize_Arrow(t *testing.T) {
+	// 空白がなければ<-は1つのトークンになる
+	head, err := Tokenize("ch <- x<-1 < -1")
+	assert.Nil(t, err)
+	kinds := []TokenKind{}
+	for tk := head; tk != nil; tk = tk.next {
+		kinds = append(kinds, tk.kind)
+	}
+	assert.Equal(t, []TokenKind{TK_IDENT, TK_ARROW, TK_IDENT, TK_ARROW, TK_INT, TK_LT, TK_SUB, TK_INT, TK_EOF}, kinds)
+}
@@ -0,0 +1,415 @@
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mylang/compiler"
+	"mylang/runtime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	threadId = 1 // スレッドは1つしかない
+
+	registersReference = 1
+	stackReference     = 2
+	memoryReference    = 3
+
+	defaultStackSize  = 1024
+	defaultMemorySize = 1024
+)
+
+// Server 1つのクライアントに対して1つのプログラムをデバッグする
+// 実行はリクエストを処理する中で行うので，実行中はpauseなど他のリクエストに応えられない
+type Server struct {
+	conn        *conn
+	runtime     *runtime.Runtime
+	debugger    *runtime.Debugger
+	positions   map[*runtime.Operation]compiler.SourcePos
+	functions   map[int]string // ラベル番号と関数名
+	breakpoints map[string][]int
+	stopOnEntry bool
+	terminated  bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{conn: newConn(in, out), breakpoints: make(map[string][]int)}
+}
+
+// Serve disconnectされるか入力が終わるまでリクエストを処理する
+func (s *Server) Serve() error {
+	for {
+		req, err := s.conn.readRequest()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		done, err := s.handle(req)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+func (s *Server) handle(req *request) (bool, error) {
+	var body any
+	var err error
+	// 応答の後に送るイベント
+	var after func() error
+	switch req.Command {
+	case "initialize":
+		body = map[string]any{"supportsConfigurationDoneRequest": true}
+	case "launch":
+		if err = s.launch(req.Arguments); err == nil {
+			after = func() error { return s.conn.send("initialized", nil) }
+		}
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "configurationDone":
+		err = s.requireLaunched()
+		if err == nil && s.stopOnEntry {
+			after = func() error { return s.stopped("entry") }
+		} else if err == nil {
+			after = func() error { return s.resume(s.debugger.Continue, "breakpoint") }
+		}
+	case "threads":
+		body = map[string]any{"threads": []thread{{Id: threadId, Name: "main"}}}
+	case "stackTrace":
+		if err = s.requireLaunched(); err == nil {
+			frames := s.stackTrace()
+			body = map[string]any{"stackFrames": frames, "totalFrames": len(frames)}
+		}
+	case "scopes":
+		body = map[string]any{"scopes": []scope{
+			{Name: "Registers", VariablesReference: registersReference},
+			{Name: "Stack", VariablesReference: stackReference},
+			{Name: "Memory", VariablesReference: memoryReference, Expensive: true},
+		}}
+	case "variables":
+		body, err = s.variables(req.Arguments)
+	case "continue":
+		if err = s.requireRunning(); err == nil {
+			body = map[string]any{"allThreadsContinued": true}
+			after = func() error { return s.resume(s.debugger.Continue, "breakpoint") }
+		}
+	case "next":
+		if err = s.requireRunning(); err == nil {
+			after = func() error { return s.resume(func() error { return s.stepLine(true) }, "step") }
+		}
+	case "stepIn":
+		if err = s.requireRunning(); err == nil {
+			after = func() error { return s.resume(func() error { return s.stepLine(false) }, "step") }
+		}
+	case "stepOut":
+		if err = s.requireRunning(); err == nil {
+			after = func() error { return s.resume(s.debugger.StepOut, "step") }
+		}
+	case "disconnect", "terminate":
+		if err := s.conn.respond(req, nil, nil); err != nil {
+			return true, err
+		}
+		return true, s.terminate()
+	default:
+		err = fmt.Errorf("unsupported command: %s", req.Command)
+	}
+	if err := s.conn.respond(req, body, err); err != nil {
+		return false, err
+	}
+	if after != nil {
+		return false, after()
+	}
+	return false, nil
+}
+
+func (s *Server) requireLaunched() error {
+	if s.debugger == nil {
+		return fmt.Errorf("program is not launched")
+	}
+	return nil
+}
+
+func (s *Server) requireRunning() error {
+	if err := s.requireLaunched(); err != nil {
+		return err
+	}
+	if s.terminated {
+		return fmt.Errorf("program has exited")
+	}
+	return nil
+}
+
+// outputWriter プログラムの出力をoutputイベントにする
+type outputWriter struct {
+	conn     *conn
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	err := w.conn.send("output", map[string]any{"category": w.category, "output": string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// launch programはソースのディレクトリかその中のファイル
+func (s *Server) launch(raw json.RawMessage) error {
+	args := launchArguments{StackSize: defaultStackSize, MemorySize: defaultMemorySize}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	prog, info, err := compiler.CompileDirDebug(dir)
+	if err != nil {
+		return err
+	}
+
+	s.positions = make(map[*runtime.Operation]compiler.SourcePos)
+	for i, op := range prog {
+		if info.Lines[i].Line != 0 {
+			s.positions[op] = info.Lines[i]
+		}
+	}
+	s.functions = make(map[int]string)
+	for name, no := range info.Symbols {
+		s.functions[no] = name
+	}
+
+	s.runtime = runtime.NewRuntime(args.StackSize, args.MemorySize,
+		runtime.WithStdin(strings.NewReader("")),
+		runtime.WithStdout(outputWriter{conn: s.conn, category: "stdout"}),
+		runtime.WithStderr(outputWriter{conn: s.conn, category: "stderr"}),
+	)
+	if err := s.runtime.Load(prog); err != nil {
+		return err
+	}
+	if err := s.runtime.CollectLabel(); err != nil {
+		return err
+	}
+	s.debugger = runtime.NewDebugger(s.runtime)
+	s.stopOnEntry = args.StopOnEntry
+	s.terminated = false
+	return s.debugger.Start()
+}
+
+// position pcにある命令を生成したソースの位置
+func (s *Server) position(pc int) (compiler.SourcePos, bool) {
+	op, ok := s.debugger.Operation(pc)
+	if !ok {
+		return compiler.SourcePos{}, false
+	}
+	pos, ok := s.positions[op]
+	return pos, ok
+}
+
+// linePC ファイルのline行目にある最初の命令, なければその後ろで一番近い行の最初の命令
+func (s *Server) linePC(path string, line int) (int, int, bool) {
+	found, foundLine := -1, 0
+	for pc := 0; ; pc++ {
+		op, ok := s.debugger.Operation(pc)
+		if !ok {
+			break
+		}
+		pos, ok := s.positions[op]
+		if !ok || pos.File != path || pos.Line < line {
+			continue
+		}
+		if found == -1 || pos.Line < foundLine {
+			found, foundLine = pc, pos.Line
+		}
+	}
+	return found, foundLine, found != -1
+}
+
+func (s *Server) setBreakpoints(raw json.RawMessage) (any, error) {
+	if err := s.requireLaunched(); err != nil {
+		return nil, err
+	}
+	args := setBreakpointsArguments{}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, pc := range s.breakpoints[path] {
+		s.debugger.ClearBreakpoint(pc)
+	}
+	lines := args.Lines
+	if args.Breakpoints != nil {
+		lines = nil
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+		}
+	}
+	pcs := []int{}
+	result := []breakpoint{}
+	for _, line := range lines {
+		pc, actual, ok := s.linePC(path, line)
+		if !ok {
+			result = append(result, breakpoint{Verified: false, Line: line})
+			continue
+		}
+		if err := s.debugger.SetBreakpoint(pc); err != nil {
+			return nil, err
+		}
+		pcs = append(pcs, pc)
+		result = append(result, breakpoint{Verified: true, Line: actual, Source: &source{Name: filepath.Base(path), Path: path}})
+	}
+	s.breakpoints[path] = pcs
+	return map[string]any{"breakpoints": result}, nil
+}
+
+// functionName pcを含む関数, 直前にある関数のDEF_LABELを探す
+func (s *Server) functionName(pc int) string {
+	for ; 0 <= pc; pc-- {
+		op, ok := s.debugger.Operation(pc)
+		if !ok || op.GetKind() != runtime.OP_DEF_LABEL {
+			continue
+		}
+		if name, ok := s.functions[op.Labels()[0]]; ok {
+			return name
+		}
+	}
+	return "<startup>"
+}
+
+func (s *Server) stackTrace() []stackFrame {
+	pcs := []int{s.debugger.PC()}
+	calls := s.debugger.CallStack()
+	for i := len(calls) - 1; 0 <= i; i-- {
+		pcs = append(pcs, calls[i])
+	}
+	frames := []stackFrame{}
+	for i, pc := range pcs {
+		frame := stackFrame{Id: i, Name: s.functionName(pc)}
+		if pos, ok := s.position(pc); ok {
+			frame.Source = &source{Name: filepath.Base(pos.File), Path: pos.File}
+			frame.Line, frame.Column = pos.Line, pos.Column
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func (s *Server) variables(raw json.RawMessage) (any, error) {
+	if err := s.requireLaunched(); err != nil {
+		return nil, err
+	}
+	args := variablesArguments{}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	vars := []variable{}
+	switch args.VariablesReference {
+	case registersReference:
+		for kind, obj := range s.debugger.Registers() {
+			vars = append(vars, variable{Name: runtime.RegisterKind(kind).String(), Value: fmt.Sprint(obj)})
+		}
+	case stackReference:
+		for i, obj := range s.debugger.Stack() {
+			vars = append(vars, variable{Name: strconv.Itoa(i), Value: fmt.Sprint(obj)})
+		}
+	case memoryReference:
+		for addr := 0; addr < s.debugger.MemorySize(); addr++ {
+			if obj, _ := s.debugger.Memory(addr); obj != nil {
+				vars = append(vars, variable{Name: strconv.Itoa(addr), Value: obj.String()})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown variablesReference: %d", args.VariablesReference)
+	}
+	return map[string]any{"variables": vars}, nil
+}
+
+// stepLine 別の行に着くまで進める
+// overなら呼び出し先の中では止まらず，関数から戻ったら呼び出し元で止まる
+func (s *Server) stepLine(over bool) error {
+	d := s.debugger
+	start, _ := s.position(d.PC())
+	depth := d.Depth()
+	for {
+		if err := d.Step(); err != nil {
+			return err
+		}
+		if d.Exited() || s.atBreakpoint() {
+			return nil
+		}
+		if over && depth < d.Depth() {
+			continue
+		}
+		pos, ok := s.position(d.PC())
+		if !ok {
+			continue
+		}
+		if pos.File != start.File || pos.Line != start.Line || d.Depth() != depth {
+			return nil
+		}
+	}
+}
+
+func (s *Server) atBreakpoint() bool {
+	for _, pc := range s.debugger.Breakpoints() {
+		if pc == s.debugger.PC() {
+			return true
+		}
+	}
+	return false
+}
+
+// resume runで進めてから止まった理由か終了をイベントで伝える
+func (s *Server) resume(run func() error, reason string) error {
+	if err := run(); err != nil {
+		if err := s.conn.send("output", map[string]any{"category": "stderr", "output": err.Error() + "\n"}); err != nil {
+			return err
+		}
+		return s.exit(1)
+	}
+	if s.debugger.Exited() {
+		code := 0
+		if status := s.debugger.Register(runtime.REG_STATUS); status != nil && status.GetKind() == runtime.OBJ_INT {
+			code = status.GetData()
+		}
+		return s.exit(code)
+	}
+	if s.atBreakpoint() {
+		reason = "breakpoint"
+	}
+	return s.stopped(reason)
+}
+
+func (s *Server) stopped(reason string) error {
+	return s.conn.send("stopped", map[string]any{"reason": reason, "threadId": threadId, "allThreadsStopped": true})
+}
+
+func (s *Server) exit(code int) error {
+	s.terminated = true
+	if err := s.conn.send("exited", map[string]any{"exitCode": code}); err != nil {
+		return err
+	}
+	return s.conn.send("terminated", nil)
+}
+
+func (s *Server) terminate() error {
+	if s.runtime == nil {
+		return nil
+	}
+	return s.runtime.Close()
+}
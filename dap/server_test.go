@@ -0,0 +1,182 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClient struct {
+	t   *testing.T
+	in  *textproto.Reader
+	out io.Writer
+	seq int
+}
+
+func (c *testClient) request(command string, args any) {
+	c.seq++
+	body, _ := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	_, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	assert.Nil(c.t, err)
+}
+
+// read 次のメッセージ, イベントならevent, 応答ならcommandの名前が入っている
+func (c *testClient) read() map[string]any {
+	header, err := c.in.ReadMIMEHeader()
+	assert.Nil(c.t, err)
+	length, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.in.R, body)
+	assert.Nil(c.t, err)
+	msg := map[string]any{}
+	assert.Nil(c.t, json.Unmarshal(body, &msg))
+	return msg
+}
+
+func (c *testClient) expectResponse(command string) map[string]any {
+	msg := c.read()
+	assert.Equal(c.t, "response", msg["type"])
+	assert.Equal(c.t, command, msg["command"])
+	assert.Equal(c.t, true, msg["success"], msg["message"])
+	body, _ := msg["body"].(map[string]any)
+	return body
+}
+
+func (c *testClient) expectEvent(event string) map[string]any {
+	msg := c.read()
+	assert.Equal(c.t, "event", msg["type"])
+	assert.Equal(c.t, event, msg["event"])
+	body, _ := msg["body"].(map[string]any)
+	return body
+}
+
+// topFrame 一番内側のフレームの関数名と行
+func (c *testClient) topFrame() (string, float64) {
+	c.request("stackTrace", map[string]any{"threadId": threadId})
+	frames := c.expectResponse("stackTrace")["stackFrames"].([]any)
+	top := frames[0].(map[string]any)
+	return top["name"].(string), top["line"].(float64)
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.my")
+	assert.Nil(t, os.WriteFile(path, []byte(`fn add(a, b) {
+	return a + b
+}
+
+fn main() {
+	var x = add(1, 2)
+	x = x + 1
+	return x
+}
+`), 0644))
+
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewServer(serverIn, serverOut).Serve()
+	}()
+	c := &testClient{t: t, in: textproto.NewReader(bufio.NewReader(clientIn)), out: clientOut}
+
+	c.request("initialize", map[string]any{"adapterID": "mylang"})
+	c.expectResponse("initialize")
+	c.request("launch", map[string]any{"program": dir})
+	c.expectResponse("launch")
+	c.expectEvent("initialized")
+
+	// 空行の3行目は次の行にずらされ，100行目はない
+	c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": path}, "breakpoints": []any{
+		map[string]any{"line": 2}, map[string]any{"line": 7}, map[string]any{"line": 3}, map[string]any{"line": 100},
+	}})
+	bps := c.expectResponse("setBreakpoints")["breakpoints"].([]any)
+	assert.Equal(t, []any{
+		map[string]any{"verified": true, "line": 2.0, "source": map[string]any{"name": "main.my", "path": path}},
+		map[string]any{"verified": true, "line": 7.0, "source": map[string]any{"name": "main.my", "path": path}},
+		map[string]any{"verified": true, "line": 5.0, "source": map[string]any{"name": "main.my", "path": path}},
+		map[string]any{"verified": false, "line": 100.0},
+	}, bps)
+	// 5行目は取り消す
+	c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": path}, "lines": []int{2, 7}})
+	c.expectResponse("setBreakpoints")
+
+	c.request("configurationDone", nil)
+	c.expectResponse("configurationDone")
+	assert.Equal(t, "breakpoint", c.expectEvent("stopped")["reason"])
+
+	c.request("stackTrace", map[string]any{"threadId": threadId})
+	frames := c.expectResponse("stackTrace")["stackFrames"].([]any)
+	assert.Equal(t, 3, len(frames))
+	assert.Equal(t, "add", frames[0].(map[string]any)["name"])
+	assert.Equal(t, 2.0, frames[0].(map[string]any)["line"])
+	assert.Equal(t, "main", frames[1].(map[string]any)["name"])
+	assert.Equal(t, 6.0, frames[1].(map[string]any)["line"])
+	assert.Equal(t, "<startup>", frames[2].(map[string]any)["name"])
+
+	c.request("scopes", map[string]any{"frameId": 0})
+	assert.Equal(t, 3, len(c.expectResponse("scopes")["scopes"].([]any)))
+	c.request("variables", map[string]any{"variablesReference": stackReference})
+	vars := c.expectResponse("variables")["variables"].([]any)
+	assert.Equal(t, 2, len(vars)) // startupとmainのCALLが積んだ戻り先
+
+	c.request("stepOut", map[string]any{"threadId": threadId})
+	c.expectResponse("stepOut")
+	assert.Equal(t, "step", c.expectEvent("stopped")["reason"])
+	name, line := c.topFrame()
+	assert.Equal(t, "main", name)
+	assert.Equal(t, 6.0, line)
+
+	c.request("next", map[string]any{"threadId": threadId})
+	c.expectResponse("next")
+	assert.Equal(t, "breakpoint", c.expectEvent("stopped")["reason"])
+	_, line = c.topFrame()
+	assert.Equal(t, 7.0, line)
+
+	c.request("next", map[string]any{"threadId": threadId})
+	c.expectResponse("next")
+	assert.Equal(t, "step", c.expectEvent("stopped")["reason"])
+	_, line = c.topFrame()
+	assert.Equal(t, 8.0, line)
+
+	c.request("continue", map[string]any{"threadId": threadId})
+	c.expectResponse("continue")
+	assert.Equal(t, 4.0, c.expectEvent("exited")["exitCode"])
+	c.expectEvent("terminated")
+
+	c.request("next", map[string]any{"threadId": threadId})
+	msg := c.read()
+	assert.Equal(t, false, msg["success"])
+	assert.Equal(t, "program has exited", msg["message"])
+
+	c.request("disconnect", nil)
+	c.expectResponse("disconnect")
+	assert.Nil(t, <-done)
+}
+
+func TestServer_LaunchError(t *testing.T) {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	go func() {
+		_ = NewServer(serverIn, serverOut).Serve()
+	}()
+	c := &testClient{t: t, in: textproto.NewReader(bufio.NewReader(clientIn)), out: clientOut}
+
+	c.request("launch", map[string]any{"program": t.TempDir()})
+	msg := c.read()
+	assert.Equal(t, false, msg["success"])
+
+	c.request("stackTrace", map[string]any{"threadId": threadId})
+	msg = c.read()
+	assert.Equal(t, false, msg["success"])
+	assert.Equal(t, "program is not launched", msg["message"])
+	_ = clientOut.Close()
+}
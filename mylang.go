@@ -0,0 +1,197 @@
+package mylang
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"mylang/compiler"
+	"mylang/runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStackSize  = 1024
+	defaultMemorySize = 1024
+)
+
+// Result 1回実行した結果
+type Result struct {
+	Status   int    // mainの戻り値, エラーで止まったらruntime.STAT_ERR
+	Stdout   string // 標準出力に書かれたもの
+	Stderr   string // 標準エラー出力に書かれたもの
+	Executed int    // 実行した命令数
+}
+
+type config struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	fsys       runtime.FileSystem
+	stackSize  int
+	memorySize int
+	fuel       int
+	timeout    time.Duration
+	hostFuncs  map[string]runtime.HostFunc
+}
+
+// Option CompileやEvalに渡す設定
+type Option func(*config)
+
+// WithStdin input()やreadInt()の読み込み元, 指定しなければ空の入力になる
+// Moduleごとに1つのバッファを通して読むので，前のRunが読み残したものは次のRunが続けて読む
+func WithStdin(in io.Reader) Option {
+	return func(c *config) {
+		c.stdin = in
+	}
+}
+
+// WithStdout 標準出力をResultに残すのに加えてoutにも書く
+func WithStdout(out io.Writer) Option {
+	return func(c *config) {
+		c.stdout = out
+	}
+}
+
+// WithStderr 標準エラー出力をResultに残すのに加えてoutにも書く
+func WithStderr(out io.Writer) Option {
+	return func(c *config) {
+		c.stderr = out
+	}
+}
+
+// WithFS 開けるファイルの置き場所, 指定しなければどのファイルも開けない
+func WithFS(fsys runtime.FileSystem) Option {
+	return func(c *config) {
+		c.fsys = fsys
+	}
+}
+
+func WithStackSize(size int) Option {
+	return func(c *config) {
+		c.stackSize = size
+	}
+}
+
+func WithMemorySize(size int) Option {
+	return func(c *config) {
+		c.memorySize = size
+	}
+}
+
+// WithFuel 1回のRunで実行できる命令数, 使い切るとruntime.ErrFuelExhaustedで止まる
+func WithFuel(fuel int) Option {
+	return func(c *config) {
+		c.fuel = fuel
+	}
+}
+
+// WithTimeout 1回のRunにかけられる時間, 過ぎるとcontext.DeadlineExceededで止まる
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithHostFunc externで宣言した関数nameとしてfnを呼べるようにする
+func WithHostFunc(name string, fn runtime.HostFunc) Option {
+	return func(c *config) {
+		c.hostFuncs[name] = fn
+	}
+}
+
+// Module コンパイル済みのプログラム
+// Runのたびに新しいRuntimeを作るが，WithStdin，WithStdout，WithStderr，WithFSとWithHostFuncで渡したものは
+// すべてのRunで共有する. 複数のgoroutineから同時に動かすときは，それらを同時に使えるものにすること
+// 標準入力のバッファは同時に読めないので，WithStdinを使うなら同時に動かさないこと
+type Module struct {
+	obj *runtime.ObjectFile
+	cfg config
+}
+
+// Compile ソースをコンパイルする, optsは後のRunで使う
+func Compile(src string, opts ...Option) (*Module, error) {
+	obj, err := compiler.CompileSource(src)
+	if err != nil {
+		return nil, err
+	}
+	cfg := config{
+		stackSize:  defaultStackSize,
+		memorySize: defaultMemorySize,
+		hostFuncs:  make(map[string]runtime.HostFunc),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.stdin != nil {
+		// Runごとに包み直すと，前のRunがバッファに読み込んだ分が失われる
+		cfg.stdin = bufio.NewReader(cfg.stdin)
+	}
+	return &Module{obj: obj, cfg: cfg}, nil
+}
+
+// Run mainを実行する
+// エラーで止まっても，それまでの出力はResultに入っている
+func (m *Module) Run(ctx context.Context) (Result, error) {
+	if m.cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.cfg.timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	var stdin io.Reader = strings.NewReader("")
+	if m.cfg.stdin != nil {
+		stdin = m.cfg.stdin
+	}
+	opts := []runtime.Option{
+		runtime.WithStdin(stdin),
+		runtime.WithStdout(tee(&stdout, m.cfg.stdout)),
+		runtime.WithStderr(tee(&stderr, m.cfg.stderr)),
+		runtime.WithFuel(m.cfg.fuel),
+	}
+	if m.cfg.fsys != nil {
+		opts = append(opts, runtime.WithFS(m.cfg.fsys))
+	}
+	r := runtime.NewRuntime(m.cfg.stackSize, m.cfg.memorySize, opts...)
+	for name, fn := range m.cfg.hostFuncs {
+		r.RegisterHostFunc(name, fn)
+	}
+
+	err := r.LoadObject(m.obj)
+	if err == nil {
+		err = r.CollectLabel()
+	}
+	if err == nil {
+		err = r.RunContext(ctx)
+	}
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	result := Result{
+		Status:   int(runtime.STAT_ERR),
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Executed: r.InstructionCount(),
+	}
+	if status := r.Register(runtime.REG_STATUS); status != nil {
+		result.Status = status.GetData()
+	}
+	return result, err
+}
+
+// Eval ソースをコンパイルしてすぐに実行する
+func Eval(src string, opts ...Option) (Result, error) {
+	m, err := Compile(src, opts...)
+	if err != nil {
+		return Result{Status: int(runtime.STAT_ERR)}, err
+	}
+	return m.Run(context.Background())
+}
+
+func tee(buf *bytes.Buffer, out io.Writer) io.Writer {
+	if out == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, out)
+}
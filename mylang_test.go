@@ -0,0 +1,108 @@
+package mylang
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"mylang/runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	result, err := Eval(`fn main() int {
+	println(1)
+	println(2 + 3)
+	return 7
+}`)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, result.Status)
+	assert.Equal(t, "1\n5\n", result.Stdout)
+	assert.Equal(t, "", result.Stderr)
+	assert.Less(t, 0, result.Executed)
+}
+
+func TestEval_Options(t *testing.T) {
+	var out bytes.Buffer
+	result, err := Eval(`extern fn double(n) int
+fn main() int {
+	var n = readInt()
+	println(double(n))
+	return double(double(n))
+}`,
+		WithStdin(strings.NewReader("5\n")),
+		WithStdout(&out),
+		WithHostFunc("double", func(args []*runtime.Object) (*runtime.Object, error) {
+			return runtime.NewObject(args[0].GetData() * 2), nil
+		}),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, result.Status)
+	assert.Equal(t, "10\n", result.Stdout)
+	// Resultに残すのに加えて指定した先にも書く
+	assert.Equal(t, "10\n", out.String())
+}
+
+func TestEval_Errors(t *testing.T) {
+	// コンパイルエラー
+	_, err := Eval("fn main() { return x }")
+	assert.EqualError(t, err, "genLoadIdent: undefined: x")
+	_, err = Eval(`import "math" fn main() {}`)
+	assert.EqualError(t, err, "1:1: import is not supported in a single source")
+	_, err = Eval("fn f() {}")
+	assert.EqualError(t, err, "module main: function main is not defined")
+
+	// ホスト関数のエラー, それまでの出力は残る
+	errHost := errors.New("boom")
+	result, err := Eval("extern fn fail() fn main() { println(1) fail() }", WithHostFunc("fail", func([]*runtime.Object) (*runtime.Object, error) {
+		return nil, errHost
+	}))
+	assert.ErrorIs(t, err, errHost)
+	assert.Equal(t, int(runtime.STAT_ERR), result.Status)
+	assert.Equal(t, "1\n", result.Stdout)
+
+	// 上限
+	loop := "fn main() { var ch = channel(1) spawn loop(ch) join(spawn loop(ch)) } fn loop(ch) { yield() loop(ch) }"
+	_, err = Eval(loop, WithFuel(1000))
+	assert.ErrorIs(t, err, runtime.ErrFuelExhausted)
+	_, err = Eval("fn main() { main() }", WithStackSize(10))
+	assert.ErrorContains(t, err, "stack overflow")
+	_, err = Eval(loop, WithStackSize(1<<20), WithMemorySize(1<<20), WithTimeout(10*time.Millisecond))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestModule_Run(t *testing.T) {
+	// 1回コンパイルしたものを何度でも，同時にでも動かせる
+	m, err := Compile("fn add(a, b) int { return a + b } fn main() int { println(add(1, 2)) return add(3, 4) }")
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := m.Run(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, Result{Status: 7, Stdout: "3\n", Executed: result.Executed}, result)
+		}()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = m.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestModule_Run_Stdin(t *testing.T) {
+	// 前のRunが読み残した入力は次のRunが読む
+	m, err := Compile("fn main() int { return readInt() }", WithStdin(strings.NewReader("1\n2\n3\n")))
+	assert.Nil(t, err)
+	for _, want := range []int{1, 2, 3} {
+		result, err := m.Run(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, want, result.Status)
+	}
+}
@@ -0,0 +1,325 @@
+package runtime
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Assemble テキストのアセンブリをオブジェクトファイルにする
+// 1行に1命令で，Exportの出力に加えて名前付きのラベルが書ける
+//
+//	check_x15:              // DEF_LABELの代わり
+//	  JUMP check_x15        // ラベルを名前で参照する
+//	  CALL label(3)         // 番号でも参照できる
+//	  MOVE register(GENERAL_1) function(main.func1)
+//	  CALL_HOST host(now) 0 // ホスト関数も名前で参照する
+//	  SYSCALL_WRITE 2 ' '   // 文字はシングルクォートで囲む
+//
+// 名前にはラベル番号が割り当てられてSymbolsに記録される, mainは0番になる
+// 参照しているのに定義していない名前は未定義のシンボルとして残る
+func Assemble(src string) (*ObjectFile, error) {
+	type fixup struct {
+		obj  *Object
+		name string
+	}
+	program := Program{}
+	fixups := []fixup{}
+	names := []string{} // 出現順
+	numbered := make(map[int]bool)
+
+	useName := func(obj *Object, name string) {
+		fixups = append(fixups, fixup{obj: obj, name: name})
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for i, line := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		fields, err := splitAsmLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to assemble: line %d: %w", lineNo, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		// name:
+		if len(fields) == 1 && strings.HasSuffix(fields[0], ":") {
+			name := strings.TrimSuffix(fields[0], ":")
+			if !isAsmName(name) {
+				return nil, fmt.Errorf("failed to assemble: line %d: invalid label name: %s", lineNo, name)
+			}
+			label := NewLabelObject(0)
+			useName(label, name)
+			program = append(program, NewDefLabelOp(label))
+			continue
+		}
+
+		kind, ok := lookupOperationKind(fields[0])
+		if !ok {
+			return nil, fmt.Errorf("failed to assemble: line %d: unknown operation: %s", lineNo, fields[0])
+		}
+		if 5 < len(fields) {
+			return nil, fmt.Errorf("failed to assemble: line %d: too many operands", lineNo)
+		}
+		params := make([]*Object, 4)
+		for j, field := range fields[1:] {
+			obj, name, err := parseAsmOperand(field)
+			if err != nil {
+				return nil, fmt.Errorf("failed to assemble: line %d: %w", lineNo, err)
+			}
+			if name != "" {
+				useName(obj, name)
+			} else if isLabelRef(obj) {
+				numbered[obj.data] = true
+			}
+			params[j] = obj
+		}
+		program = append(program, &Operation{kind: kind, param1: params[0], param2: params[1], param3: params[2], param4: params[3]})
+	}
+
+	// 名前にラベル番号を割り当てる
+	symbols := make(map[string]int)
+	next := 0
+	for _, name := range names {
+		if name == "main" {
+			if numbered[0] {
+				return nil, fmt.Errorf("failed to assemble: main must be label(0) but label(0) is already used")
+			}
+			symbols[name] = 0
+			numbered[0] = true
+		}
+	}
+	for _, name := range names {
+		if _, ok := symbols[name]; ok {
+			continue
+		}
+		for numbered[next] {
+			next++
+		}
+		symbols[name] = next
+		numbered[next] = true
+	}
+	for _, f := range fixups {
+		f.obj.data = symbols[f.name]
+	}
+
+	seen := make(map[int]bool)
+	for _, op := range program {
+		if op.kind != OP_DEF_LABEL {
+			continue
+		}
+		if !isLabelRef(op.param1) {
+			return nil, fmt.Errorf("failed to assemble: DEF_LABEL needs label: %s", op.String())
+		}
+		if seen[op.param1.data] {
+			return nil, fmt.Errorf("failed to assemble: label defined twice: %s", asmOperandString(op.param1, invertSymbols(symbols)))
+		}
+		seen[op.param1.data] = true
+	}
+	return NewObjectFile(program, symbols), nil
+}
+
+func lookupOperationKind(name string) (OperationKind, bool) {
+	for kind, str := range opKinds {
+		if str == name && OperationKind(kind) != OP_ILLEGAL {
+			return OperationKind(kind), true
+		}
+	}
+	return OP_ILLEGAL, false
+}
+
+// LookupRegisterKind GENERAL_1のような名前からレジスタを探す
+func LookupRegisterKind(name string) (RegisterKind, bool) {
+	for kind, str := range regKinds {
+		if str == name {
+			return RegisterKind(kind), true
+		}
+	}
+	return 0, false
+}
+
+func isAsmName(name string) bool {
+	if name == "" || name == "true" || name == "false" || name == "null" || name == "invalid" {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || unicode.IsLetter(r) {
+			continue
+		}
+		if 0 < i && (r == '.' || unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// splitAsmLine 空白で区切る, コメントを取り除き'...'の中の空白では区切らない
+func splitAsmLine(line string) ([]string, error) {
+	fields := []string{}
+	runes := []rune(line)
+	var field []rune
+	flush := func() {
+		if len(field) != 0 {
+			fields = append(fields, string(field))
+			field = nil
+		}
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			flush()
+			return fields, nil
+		case unicode.IsSpace(r):
+			flush()
+		case r == '\'':
+			start := i
+			for i++; i < len(runes) && runes[i] != '\''; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			if len(runes) <= i {
+				return nil, fmt.Errorf("unterminated char: %s", string(runes[start:]))
+			}
+			field = append(field, runes[start:i+1]...)
+		default:
+			field = append(field, r)
+		}
+	}
+	flush()
+	return fields, nil
+}
+
+// ParseOperand Assembleと同じ書き方でオペランドを1つ読む, ラベルを名前では参照できない
+func ParseOperand(s string) (*Object, error) {
+	obj, name, err := parseAsmOperand(s)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		return nil, fmt.Errorf("invalid operand: %s", s)
+	}
+	return obj, nil
+}
+
+// parseAsmOperand オペランドを読む, ラベルを名前で参照していればその名前も返す
+func parseAsmOperand(s string) (*Object, string, error) {
+	switch s {
+	case "invalid":
+		return &Object{kind: OBJ_INVALID}, "", nil
+	case "true":
+		return NewObject(true), "", nil
+	case "false":
+		return NewObject(false), "", nil
+	case "null":
+		return NewNullObject(), "", nil
+	}
+	if strings.HasPrefix(s, "'") {
+		c, err := strconv.Unquote(s)
+		if err != nil || len([]rune(c)) != 1 {
+			return nil, "", fmt.Errorf("invalid char: %s", s)
+		}
+		return NewObject([]rune(c)[0]), "", nil
+	}
+	if i, err := strconv.Atoi(s); err == nil {
+		return NewObject(i), "", nil
+	}
+	if open := strings.Index(s, "("); open != -1 && strings.HasSuffix(s, ")") {
+		fn, arg := s[:open], s[open+1:len(s)-1]
+		if fn == "register" {
+			reg, ok := LookupRegisterKind(arg)
+			if !ok {
+				return nil, "", fmt.Errorf("unknown register: %s", arg)
+			}
+			return NewRegisterObject(reg), "", nil
+		}
+		constructors := map[string]func(int) *Object{
+			"list":      NewListObject,
+			"label":     NewLabelObject,
+			"reference": NewReferenceObject,
+			"function":  NewFunctionObject,
+			"closure":   NewClosureObject,
+			"thread":    NewThreadObject,
+			"channel":   NewChannelObject,
+			"host":      NewHostObject,
+		}
+		constructor, ok := constructors[fn]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown operand: %s", s)
+		}
+		if n, err := strconv.Atoi(arg); err == nil {
+			return constructor(n), "", nil
+		}
+		if (fn == "label" || fn == "function" || fn == "host") && isAsmName(arg) {
+			return constructor(0), arg, nil
+		}
+		return nil, "", fmt.Errorf("invalid operand: %s", s)
+	}
+	if isAsmName(s) {
+		return NewLabelObject(0), s, nil
+	}
+	return nil, "", fmt.Errorf("invalid operand: %s", s)
+}
+
+func invertSymbols(symbols map[string]int) map[int]string {
+	names := make(map[int]string)
+	for name, no := range symbols {
+		names[no] = name
+	}
+	return names
+}
+
+// asmOperandString Assembleで読める形のオペランド, 名前のあるラベルは名前で書く
+func asmOperandString(obj *Object, names map[int]string) string {
+	switch obj.kind {
+	case OBJ_CHAR:
+		return strconv.QuoteRune(rune(obj.data))
+	case OBJ_LABEL:
+		if name, ok := names[obj.data]; ok {
+			return name
+		}
+	case OBJ_FUNCTION:
+		if name, ok := names[obj.data]; ok {
+			return fmt.Sprintf("function(%s)", name)
+		}
+	case OBJ_HOST:
+		if name, ok := names[obj.data]; ok {
+			return fmt.Sprintf("host(%s)", name)
+		}
+	}
+	return obj.String()
+}
+
+// asmOperationString Assembleで読める形の命令
+func asmOperationString(op *Operation, names map[int]string) string {
+	line := op.kind.String()
+	for _, param := range op.params() {
+		if param != nil {
+			line += " " + asmOperandString(param, names)
+		}
+	}
+	return line
+}
+
+// Disassemble オブジェクトファイルをAssembleで読めるテキストにする
+// ラベルは名前があれば名前で表示する
+func Disassemble(obj *ObjectFile) string {
+	names := invertSymbols(obj.Symbols)
+	lines := []string{}
+	for _, op := range obj.Program {
+		if op.kind == OP_DEF_LABEL && op.param1 != nil && op.param1.kind == OBJ_LABEL {
+			if name, ok := names[op.param1.data]; ok {
+				lines = append(lines, name+":")
+				continue
+			}
+		}
+		lines = append(lines, "  "+asmOperationString(op, names))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,110 @@
+package runtime
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	obj, err := Assemble(`
+main:
+  MOVE register(GENERAL_1) 1
+  CALL loop
+  RETURN
+
+// 15まで数字とfizzを書く
+loop:
+  SYSCALL_WRITE 2 register(GENERAL_1)
+  CALL check_x3
+  JUMP_FALSE newline
+  SYSCALL_WRITE 2 'f'
+newline:
+  SYSCALL_WRITE 2 '\n'
+  ADD register(GENERAL_1) 1
+  LE register(GENERAL_1) 6
+  JUMP_TRUE loop
+  RETURN
+
+check_x3:
+  PUSH register(GENERAL_1)
+loop_c3:
+  SUB register(GENERAL_1) 3
+  LT 0 register(GENERAL_1)
+  JUMP_TRUE loop_c3
+  EQ register(GENERAL_1) 0
+  POP register(GENERAL_1)
+  RETURN
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"main": 0, "loop": 1, "check_x3": 2, "newline": 3, "loop_c3": 4}, obj.Symbols)
+	assert.Equal(t, NewDefLabelOp(NewLabelObject(2)), obj.Program[15])
+	assert.Equal(t, &Operation{kind: OP_JUMP_TRUE, param1: NewLabelObject(4)}, obj.Program[20])
+
+	var buf bytes.Buffer
+	runtime := NewRuntime(10, 10, WithStdout(&buf))
+	assert.Nil(t, runtime.Load(obj.Program))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, "1\n2\n3f\n4\n5\n6f\n", buf.String())
+}
+
+func TestAssemble_NumberedLabels(t *testing.T) {
+	// Exportの出力はそのまま読める. 名前は使われていない番号から割り当てる
+	obj, err := Assemble("DEF_LABEL label(0)\nCALL helper\nJUMP label(1)\nDEF_LABEL label(1)\nRETURN")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"helper": 2}, obj.Symbols)
+	assert.Equal(t, []string{"helper"}, obj.Undefined())
+	assert.Equal(t, "DEF_LABEL label(0)\nCALL label(2)\nJUMP label(1)\nDEF_LABEL label(1)\nRETURN", Export(obj.Program))
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	_, err := Assemble("main:\n  FOO 1")
+	assert.EqualError(t, err, "failed to assemble: line 2: unknown operation: FOO")
+	_, err = Assemble("main:\n  MOVE register(GENERAL_9) 1")
+	assert.EqualError(t, err, "failed to assemble: line 2: unknown register: GENERAL_9")
+	_, err = Assemble("main:\n  SYSCALL_WRITE 2 'ab'")
+	assert.EqualError(t, err, "failed to assemble: line 2: invalid char: 'ab'")
+	_, err = Assemble("main:\nmain:")
+	assert.EqualError(t, err, "failed to assemble: label defined twice: main")
+	_, err = Assemble("DEF_LABEL label(0)\nmain:")
+	assert.EqualError(t, err, "failed to assemble: main must be label(0) but label(0) is already used")
+}
+
+func TestDisassemble(t *testing.T) {
+	src := `main:
+  MOVE register(GENERAL_1) function(main.func1)
+  MAKE_CLOSURE register(GENERAL_2) main.func1 0
+  SYSCALL_WRITE 2 ' '
+  JUMP label(7)
+  DEF_LABEL label(7)
+  RETURN
+main.func1:
+  RETURN`
+	obj, err := Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, src, Disassemble(obj))
+	// Disassembleした結果をもう一度読むと同じものになる
+	again, err := Assemble(Disassemble(obj))
+	assert.Nil(t, err)
+	assert.Equal(t, obj, again)
+}
+
+func TestAssemble_LargeLabel(t *testing.T) {
+	// 大きすぎるラベル番号は表を確保する前にエラーにする
+	obj, err := Assemble("DEF_LABEL label(0)\nRETURN\nDEF_LABEL label(1099511627776)\nRETURN")
+	assert.Nil(t, err)
+	runtime := NewRuntime(10, 10)
+	assert.Nil(t, runtime.Load(obj.Program))
+	assert.EqualError(t, runtime.CollectLabel(), "failed to set symbol: reason=label is out of range: l_1099511627776, limit=1027")
+
+	// ラベルが命令より多くても，詰めて振られていれば登録できる
+	prog := Program{}
+	for label := 0; label < 3000; label++ {
+		prog = append(prog, NewDefLabelOp(NewLabelObject(label)))
+	}
+	prog = append(prog, NewReturnOp())
+	runtime = NewRuntime(10, 10)
+	assert.Nil(t, runtime.Reload(prog))
+	assert.Nil(t, runtime.Run())
+}
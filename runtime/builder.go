@@ -0,0 +1,145 @@
+package runtime
+
+// Asm 命令を順に足してプログラムを組み立てる
+// ラベルは名前で参照でき，初めて使ったときに番号を割り当ててSymbolsに記録する
+// mainはLoadが挿入するstartupから呼ばれるので必ず0番にする
+//
+//	a := NewAsm()
+//	a.Def("main").
+//		Move(NewRegisterObject(REG_GENERAL_1), NewObject(3)).
+//		Call(a.Label("helper")).
+//		Return()
+type Asm struct {
+	program Program
+	symbols map[string]int
+	next    int
+}
+
+func NewAsm() *Asm {
+	return &Asm{
+		symbols: map[string]int{"main": 0},
+		next:    1,
+	}
+}
+
+func (a *Asm) labelNo(name string) int {
+	if no, ok := a.symbols[name]; ok {
+		return no
+	}
+	a.symbols[name] = a.next
+	a.next++
+	return a.symbols[name]
+}
+
+// Label nameのラベル
+func (a *Asm) Label(name string) *Object {
+	return NewLabelObject(a.labelNo(name))
+}
+
+// Function nameの関数を値として扱うときのオブジェクト
+func (a *Asm) Function(name string) *Object {
+	return NewFunctionObject(a.labelNo(name))
+}
+
+// Host CALL_HOSTで呼ぶホスト関数
+func (a *Asm) Host(name string) *Object {
+	return NewHostObject(a.labelNo(name))
+}
+
+// NewLabel 名前のないラベル, ループの飛び先などに使う
+func (a *Asm) NewLabel() *Object {
+	label := NewLabelObject(a.next)
+	a.next++
+	return label
+}
+
+// Emit 命令をそのまま足す
+func (a *Asm) Emit(ops ...*Operation) *Asm {
+	a.program = append(a.program, ops...)
+	return a
+}
+
+// Def nameのラベルをここに定義する
+func (a *Asm) Def(name string) *Asm {
+	return a.Emit(NewDefLabelOp(a.Label(name)))
+}
+
+// DefLabel NewLabelで作ったラベルをここに定義する
+func (a *Asm) DefLabel(label *Object) *Asm {
+	return a.Emit(NewDefLabelOp(label))
+}
+
+func (a *Asm) Exit() *Asm                  { return a.Emit(NewExitOp()) }
+func (a *Asm) Move(dest, src *Object) *Asm { return a.Emit(NewMoveOp(dest, src)) }
+func (a *Asm) Push(src *Object) *Asm       { return a.Emit(NewPushOp(src)) }
+func (a *Asm) Pop(dest *Object) *Asm       { return a.Emit(NewPopOp(dest)) }
+func (a *Asm) Call(label *Object) *Asm     { return a.Emit(NewCallOp(label)) }
+func (a *Asm) Return() *Asm                { return a.Emit(NewReturnOp()) }
+func (a *Asm) Add(dest, src *Object) *Asm  { return a.Emit(NewAddOp(dest, src)) }
+func (a *Asm) Sub(dest, src *Object) *Asm  { return a.Emit(NewSubOp(dest, src)) }
+func (a *Asm) Jump(label *Object) *Asm     { return a.Emit(NewJumpOp(label)) }
+func (a *Asm) JumpTrue(label *Object) *Asm { return a.Emit(NewJumpTrueOp(label)) }
+func (a *Asm) JumpFalse(label *Object) *Asm {
+	return a.Emit(NewJumpFalseOp(label))
+}
+func (a *Asm) Eq(obj1, obj2 *Object) *Asm { return a.Emit(NewEqOp(obj1, obj2)) }
+func (a *Asm) Ne(obj1, obj2 *Object) *Asm { return a.Emit(NewNeOp(obj1, obj2)) }
+func (a *Asm) Lt(obj1, obj2 *Object) *Asm { return a.Emit(NewLtOp(obj1, obj2)) }
+func (a *Asm) Le(obj1, obj2 *Object) *Asm { return a.Emit(NewLeOp(obj1, obj2)) }
+func (a *Asm) SyscallWrite(dest, src *Object) *Asm {
+	return a.Emit(NewSyscallWriteOp(dest, src))
+}
+func (a *Asm) SyscallRead(src, mode, dest *Object) *Asm {
+	return a.Emit(NewSyscallReadOp(src, mode, dest))
+}
+func (a *Asm) SyscallOpen(path, mode, dest *Object) *Asm {
+	return a.Emit(NewSyscallOpenOp(path, mode, dest))
+}
+func (a *Asm) SyscallClose(fd *Object) *Asm { return a.Emit(NewSyscallCloseOp(fd)) }
+func (a *Asm) Enter(size *Object) *Asm      { return a.Emit(NewEnterOp(size)) }
+func (a *Asm) Leave() *Asm                  { return a.Emit(NewLeaveOp()) }
+func (a *Asm) LoadEnv(dest, depth, slot *Object) *Asm {
+	return a.Emit(NewLoadEnvOp(dest, depth, slot))
+}
+func (a *Asm) StoreEnv(depth, slot, src *Object) *Asm {
+	return a.Emit(NewStoreEnvOp(depth, slot, src))
+}
+func (a *Asm) MakeClosure(dest, label, count *Object) *Asm {
+	return a.Emit(NewMakeClosureOp(dest, label, count))
+}
+func (a *Asm) Peek(dest, offset *Object) *Asm { return a.Emit(NewPeekOp(dest, offset)) }
+func (a *Asm) Spawn(dest, fn, argc *Object) *Asm {
+	return a.Emit(NewSpawnOp(dest, fn, argc))
+}
+func (a *Asm) Yield() *Asm                    { return a.Emit(NewYieldOp()) }
+func (a *Asm) Join(dest, thread *Object) *Asm { return a.Emit(NewJoinOp(dest, thread)) }
+func (a *Asm) MakeChannel(dest, capacity *Object) *Asm {
+	return a.Emit(NewMakeChannelOp(dest, capacity))
+}
+func (a *Asm) Send(channel, src *Object) *Asm  { return a.Emit(NewSendOp(channel, src)) }
+func (a *Asm) Recv(dest, channel *Object) *Asm { return a.Emit(NewRecvOp(dest, channel)) }
+func (a *Asm) Close(channel *Object) *Asm      { return a.Emit(NewCloseOp(channel)) }
+func (a *Asm) CallHost(fn, argc *Object) *Asm  { return a.Emit(NewCallHostOp(fn, argc)) }
+
+// Program ここまでに足した命令
+func (a *Asm) Program() Program {
+	return a.program
+}
+
+// Object 名前のついたラベルをSymbolsにしたオブジェクトファイル
+// 使っていない名前は含めない
+func (a *Asm) Object() *ObjectFile {
+	used := make(map[int]bool)
+	for _, op := range a.program {
+		for _, label := range op.Labels() {
+			used[label] = true
+		}
+	}
+	symbols := make(map[string]int)
+	for name, no := range a.symbols {
+		if used[no] {
+			symbols[name] = no
+		}
+	}
+	return NewObjectFile(a.program, symbols)
+}
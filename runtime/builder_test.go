@@ -0,0 +1,79 @@
+package runtime
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAsm(t *testing.T) {
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	a := NewAsm()
+	loop := a.NewLabel()
+	a.Def("main").
+		Move(g1, NewObject(3)).
+		DefLabel(loop).
+		Push(g1).
+		Call(a.Label("print")).
+		Pop(g1).
+		Sub(g1, NewObject(1)).
+		Lt(NewObject(0), g1).
+		JumpTrue(loop).
+		CallHost(a.Host("done"), NewObject(0)).
+		Return()
+	a.Def("print").
+		Peek(NewRegisterObject(REG_TEMP_1), NewObject(1)).
+		SyscallWrite(NewObject(STD_OUT), NewRegisterObject(REG_TEMP_1)).
+		Return()
+
+	// テキストで書いたものと同じになる
+	expected, err := Assemble(`main:
+  MOVE register(GENERAL_1) 3
+  DEF_LABEL label(1)
+  PUSH register(GENERAL_1)
+  CALL print
+  POP register(GENERAL_1)
+  SUB register(GENERAL_1) 1
+  LT 0 register(GENERAL_1)
+  JUMP_TRUE label(1)
+  CALL_HOST host(done) 0
+  RETURN
+print:
+  PEEK register(TEMP_1) 1
+  SYSCALL_WRITE 2 register(TEMP_1)
+  RETURN`)
+	assert.Nil(t, err)
+	obj := a.Object()
+	assert.Equal(t, Export(expected.Program), Export(obj.Program))
+	assert.Equal(t, map[string]int{"main": 0, "print": 2, "done": 3}, obj.Symbols)
+	assert.Equal(t, []string{"done"}, obj.Externs())
+
+	var out bytes.Buffer
+	runtime := NewRuntime(10, 1, WithStdout(&out))
+	called := false
+	runtime.RegisterHostFunc("done", func([]*Object) (*Object, error) {
+		called = true
+		return nil, nil
+	})
+	assert.Nil(t, runtime.LoadObject(obj))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, "321", out.String())
+	assert.True(t, called)
+}
+
+func TestNewOperation(t *testing.T) {
+	op, err := NewOperation(OP_MOVE, NewRegisterObject(REG_GENERAL_1), NewObject(1))
+	assert.Nil(t, err)
+	assert.Equal(t, NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewObject(1)), op)
+	assert.Equal(t, OP_MOVE, op.GetKind())
+	assert.Equal(t, []*Object{NewRegisterObject(REG_GENERAL_1), NewObject(1)}, op.Params())
+	assert.Equal(t, []*Object{}, NewExitOp().Params())
+
+	_, err = NewOperation(OP_ILLEGAL)
+	assert.EqualError(t, err, "unsupported operation value: reason=unknown kind: kind=0")
+	_, err = NewOperation(OperationKind(100))
+	assert.EqualError(t, err, "unsupported operation value: reason=unknown kind: kind=100")
+	_, err = NewOperation(OP_EXIT, NewObject(1), NewObject(2), NewObject(3), NewObject(4), NewObject(5))
+	assert.EqualError(t, err, "unsupported operation value: reason=too many params: kind=EXIT, params=5")
+}
@@ -0,0 +1,99 @@
+package runtime
+
+import "fmt"
+
+// channel スレッドの間で値を受け渡す
+// 送った値はbufferに並べ，capacityを超えた分は受け取られるまで送ったスレッドを止める
+type channel struct {
+	id       int
+	capacity int
+	buffer   []*Object
+	closed   bool
+	sent     int // これまでに送った数
+	received int // これまでに受け取った数
+}
+
+func (r *Runtime) channel(op string, obj *Object) (*channel, error) {
+	if obj.kind == OBJ_REGISTER {
+		obj = r.register[RegisterKind(obj.data)]
+	}
+	if obj == nil || obj.kind != OBJ_CHANNEL || obj.data < 0 || len(r.channels) <= obj.data {
+		return nil, fmt.Errorf("unsupported %s value: reason=channel is not CHANNEL: channel=%v", op, obj)
+	}
+	return r.channels[obj.data], nil
+}
+
+func (r *Runtime) doMakeChannel(dest, capacity *Object) error {
+	if dest.kind != OBJ_REGISTER {
+		return fmt.Errorf("unsupported make_channel value: reason=dest is not REGISTER: dest=%v", dest)
+	}
+	if capacity.kind == OBJ_REGISTER {
+		capacity = r.register[RegisterKind(capacity.data)]
+	}
+	if capacity == nil || capacity.kind != OBJ_INT || capacity.data < 0 {
+		return fmt.Errorf("unsupported make_channel value: reason=capacity is not INT: capacity=%v", capacity)
+	}
+	c := &channel{id: len(r.channels), capacity: capacity.data}
+	r.channels = append(r.channels, c)
+	return r.setRegister(RegisterKind(dest.data), NewChannelObject(c.id))
+}
+
+// doSend バッファに空きがなければ，送った値が受け取られるまで止まる
+func (r *Runtime) doSend(ch, src *Object) error {
+	c, err := r.channel("send", ch)
+	if err != nil {
+		return err
+	}
+	if c.closed {
+		return fmt.Errorf("failed to send: reason=channel is closed: channel=%v", NewChannelObject(c.id))
+	}
+	if src.kind == OBJ_REGISTER {
+		src = r.register[RegisterKind(src.data)]
+	}
+	if src == nil {
+		src = NewNullObject()
+	}
+	c.buffer = append(c.buffer, src.Clone())
+	c.sent++
+	if c.capacity < len(c.buffer) {
+		ticket := c.sent
+		r.block(func() bool { return ticket <= c.received })
+	}
+	return nil
+}
+
+// doRecv 空なら送られてくるか閉じられるまで待ってからRECVをやり直す
+func (r *Runtime) doRecv(dest, ch *Object) error {
+	if dest.kind != OBJ_REGISTER {
+		return fmt.Errorf("unsupported recv value: reason=dest is not REGISTER: dest=%v", dest)
+	}
+	c, err := r.channel("recv", ch)
+	if err != nil {
+		return err
+	}
+	if len(c.buffer) == 0 {
+		if c.closed {
+			return r.setRegister(RegisterKind(dest.data), NewNullObject())
+		}
+		r.retry(func() bool { return len(c.buffer) != 0 || c.closed })
+		return nil
+	}
+	obj := c.buffer[0]
+	c.buffer[0] = nil
+	c.buffer = c.buffer[1:]
+	c.received++
+	return r.setRegister(RegisterKind(dest.data), obj)
+}
+
+// doClose 閉じた後もバッファに残っている値は受け取れる
+func (r *Runtime) doClose(ch *Object) error {
+	c, err := r.channel("close", ch)
+	if err != nil {
+		return err
+	}
+	if c.closed {
+		return fmt.Errorf("failed to close: reason=channel is already closed: channel=%v", NewChannelObject(c.id))
+	}
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRuntime_Run_Channel(t *testing.T) {
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	t1 := NewRegisterObject(REG_TEMP_1)
+	ra := NewRegisterObject(REG_RETURN_ADDRESS)
+	stdout := NewObject(STD_OUT)
+	tests := []struct {
+		name     string
+		capacity int
+		want     string
+	}{
+		// 受け取られるまで送った側は止まるので交互になる
+		{"unbuffered", 0, ">a>b"},
+		// バッファに入る分は止まらずに送れる
+		{"buffered", 2, ">>ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			runtime := NewRuntime(10, 1, WithStdout(&out))
+			assert.Nil(t, runtime.Reload(Program{
+				NewDefLabelOp(NewLabelObject(0)),
+				NewMakeChannelOp(g1, NewObject(tt.capacity)),
+				NewPushOp(g1),
+				NewSpawnOp(NewRegisterObject(REG_GENERAL_2), NewLabelObject(1), NewObject(1)),
+				NewRecvOp(t1, g1),
+				NewSyscallWriteOp(stdout, t1),
+				NewRecvOp(t1, g1),
+				NewSyscallWriteOp(stdout, t1),
+				NewRecvOp(t1, g1),
+				NewReturnOp(),
+				// producer
+				NewDefLabelOp(NewLabelObject(1)),
+				NewPopOp(ra),
+				NewPopOp(g1),
+				NewPushOp(ra),
+				NewSyscallWriteOp(stdout, NewObject('>')),
+				NewSendOp(g1, NewObject('a')),
+				NewSyscallWriteOp(stdout, NewObject('>')),
+				NewSendOp(g1, NewObject('b')),
+				NewCloseOp(g1),
+				NewReturnOp(),
+			}))
+			assert.Nil(t, runtime.Run())
+			assert.Equal(t, tt.want, out.String())
+			// 閉じていて空ならnullを受け取る
+			assert.Equal(t, NewNullObject(), runtime.register[REG_TEMP_1])
+		})
+	}
+}
+
+func TestRuntime_Run_ChannelErrors(t *testing.T) {
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	tests := []struct {
+		name string
+		ops  Program
+		err  string
+	}{
+		{"recv deadlock", Program{NewMakeChannelOp(g1, NewObject(0)), NewRecvOp(g1, g1)}, "failed to schedule: reason=deadlock: all threads are blocked: threads=1"},
+		{"send deadlock", Program{NewMakeChannelOp(g1, NewObject(0)), NewSendOp(g1, NewObject(1))}, "failed to schedule: reason=deadlock: all threads are blocked: threads=1"},
+		{"send closed", Program{NewMakeChannelOp(g1, NewObject(1)), NewCloseOp(g1), NewSendOp(g1, NewObject(1))}, "failed to send: reason=channel is closed: channel=channel(0)"},
+		{"close twice", Program{NewMakeChannelOp(g1, NewObject(1)), NewCloseOp(g1), NewCloseOp(g1)}, "failed to close: reason=channel is already closed: channel=channel(0)"},
+		{"capacity", Program{NewMakeChannelOp(g1, NewObject(-1))}, "unsupported make_channel value: reason=capacity is not INT: capacity=-1"},
+		{"not channel", Program{NewRecvOp(g1, NewObject(1))}, "unsupported recv value: reason=channel is not CHANNEL: channel=1"},
+		{"unknown channel", Program{NewSendOp(NewChannelObject(0), NewObject(1))}, "unsupported send value: reason=channel is not CHANNEL: channel=channel(0)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := NewRuntime(10, 1)
+			program := append(Program{NewDefLabelOp(NewLabelObject(0))}, tt.ops...)
+			assert.Nil(t, runtime.Reload(append(program, NewReturnOp())))
+			assert.EqualError(t, runtime.Run(), tt.err)
+		})
+	}
+}
+
+func TestRuntime_Run_ChannelBuffered(t *testing.T) {
+	// バッファに残った値は閉じた後でも受け取れる
+	runtime := NewRuntime(10, 1)
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	assert.Nil(t, runtime.Reload(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMakeChannelOp(g1, NewObject(1)),
+		NewSendOp(g1, NewObject(7)),
+		NewCloseOp(g1),
+		NewRecvOp(NewRegisterObject(REG_STATUS), g1),
+		NewReturnOp(),
+	}))
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewObject(7), runtime.register[REG_STATUS])
+	assert.Equal(t, 1, len(runtime.channels))
+
+	// 次のRunでは作り直す
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, 1, len(runtime.channels))
+}
@@ -0,0 +1,205 @@
+package runtime
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Debugger Runtimeを1命令ずつ動かして中身を見たり書き換えたりする
+// LoadとCollectLabelを済ませたRuntimeを渡してStartしてから使う
+type Debugger struct {
+	runtime     *Runtime
+	breakpoints map[int]bool
+	calls       map[int][]int // スレッドごとの実行中の関数を呼び出したCALLのアドレス, startupから呼ばれたmainの中なら1個
+	exited      bool
+}
+
+func NewDebugger(r *Runtime) *Debugger {
+	return &Debugger{runtime: r, breakpoints: make(map[int]bool), calls: make(map[int][]int)}
+}
+
+// Start エントリーポイントで止まった状態にする
+func (d *Debugger) Start() error {
+	if err := d.runtime.start(); err != nil {
+		return err
+	}
+	d.calls = make(map[int][]int)
+	d.exited = false
+	return nil
+}
+
+func (d *Debugger) Exited() bool {
+	return d.exited
+}
+
+// PC 次に実行する命令のアドレス
+func (d *Debugger) PC() int {
+	return d.runtime.register[REG_PROGRAM_COUNTER].data
+}
+
+// Depth 今のスレッドの関数呼び出しの深さ
+func (d *Debugger) Depth() int {
+	return len(d.calls[d.runtime.ThreadID()])
+}
+
+// CallStack 今のスレッドで実行中の関数を呼び出したCALLのアドレスを外側から順に
+// SPAWNで始まったスレッドでは，SPAWNのアドレスが一番外側になる
+func (d *Debugger) CallStack() []int {
+	return slices.Clone(d.calls[d.runtime.ThreadID()])
+}
+
+// Operation pcにある命令
+func (d *Debugger) Operation(pc int) (*Operation, bool) {
+	if pc < 0 || len(d.runtime.program) <= pc {
+		return nil, false
+	}
+	return d.runtime.program[pc], true
+}
+
+func (d *Debugger) SetBreakpoint(pc int) error {
+	if _, ok := d.Operation(pc); !ok {
+		return fmt.Errorf("failed to set breakpoint: reason=pc is out of program: pc=%d", pc)
+	}
+	d.breakpoints[pc] = true
+	return nil
+}
+
+// SetBreakpointAtLabel ラベルを定義しているDEF_LABELに止まる
+func (d *Debugger) SetBreakpointAtLabel(label int) error {
+	pc, err := d.runtime.symbolTable.Get(label)
+	if err != nil {
+		return fmt.Errorf("failed to set breakpoint: %w", err)
+	}
+	return d.SetBreakpoint(pc)
+}
+
+func (d *Debugger) ClearBreakpoint(pc int) {
+	delete(d.breakpoints, pc)
+}
+
+// Breakpoints 設定されているブレークポイントを小さい順に
+func (d *Debugger) Breakpoints() []int {
+	pcs := []int{}
+	for pc := range d.breakpoints {
+		pcs = append(pcs, pc)
+	}
+	slices.Sort(pcs)
+	return pcs
+}
+
+// Step 1命令だけ実行する
+func (d *Debugger) Step() error {
+	if d.exited {
+		return fmt.Errorf("failed to step: reason=program has exited")
+	}
+	op, ok := d.Operation(d.PC())
+	if !ok {
+		return fmt.Errorf("failed to step: reason=pc is out of program: pc=%d", d.PC())
+	}
+	pc, id := d.PC(), d.runtime.ThreadID()
+	exited, err := d.runtime.step()
+	d.runtime.executed++
+	if err != nil {
+		return err
+	}
+	switch op.kind {
+	case OP_CALL:
+		d.calls[id] = append(d.calls[id], pc)
+	case OP_SPAWN:
+		// newThreadは作ったスレッドを最後に足す
+		t := d.runtime.threads[len(d.runtime.threads)-1]
+		d.calls[t.id] = []int{pc}
+	case OP_RETURN:
+		if calls := d.calls[id]; len(calls) != 0 {
+			d.calls[id] = calls[:len(calls)-1]
+		}
+	case OP_EXIT:
+		if id != 0 {
+			delete(d.calls, id)
+		}
+	}
+	d.exited = exited
+	return nil
+}
+
+// runUntil 1命令以上実行して，stopがtrueになるかブレークポイントに着くか終了するまで進める
+func (d *Debugger) runUntil(stop func() bool) error {
+	for {
+		if err := d.Step(); err != nil {
+			return err
+		}
+		if d.exited || stop() || d.breakpoints[d.PC()] {
+			return nil
+		}
+	}
+}
+
+// Continue ブレークポイントに着くか終了するまで実行する
+func (d *Debugger) Continue() error {
+	return d.runUntil(func() bool { return false })
+}
+
+// StepOver CALLなら呼び出し先から戻ってくるまで実行する, それ以外はStepと同じ
+// 途中で別のスレッドに切り替わっても，元のスレッドの深さで止まるかを決める
+func (d *Debugger) StepOver() error {
+	id, depth := d.runtime.ThreadID(), d.Depth()
+	return d.runUntil(func() bool { return d.threadDone(id) || d.runtime.ThreadID() == id && d.Depth() <= depth })
+}
+
+// StepOut 今の関数からRETURNで戻るまで実行する
+func (d *Debugger) StepOut() error {
+	id, depth := d.runtime.ThreadID(), d.Depth()
+	return d.runUntil(func() bool { return d.threadDone(id) || d.runtime.ThreadID() == id && d.Depth() < depth })
+}
+
+// threadDone SPAWNしたスレッドidがEXITで終わった
+func (d *Debugger) threadDone(id int) bool {
+	_, ok := d.calls[id]
+	return id != 0 && !ok
+}
+
+// Registers 全レジスタの写し
+func (d *Debugger) Registers() Register {
+	return slices.Clone(d.runtime.register)
+}
+
+func (d *Debugger) Register(kind RegisterKind) *Object {
+	return d.runtime.register[kind]
+}
+
+func (d *Debugger) SetRegister(kind RegisterKind, obj *Object) error {
+	if kind < 0 || len(d.runtime.register) <= int(kind) {
+		return fmt.Errorf("failed to set register: reason=unknown register: kind=%d", kind)
+	}
+	return d.runtime.setRegister(kind, obj)
+}
+
+// Stack 積まれている値を底から順に
+func (d *Debugger) Stack() []*Object {
+	return slices.Clone(d.runtime.stack.Items())
+}
+
+func (d *Debugger) SetStack(i int, obj *Object) error {
+	items := d.runtime.stack.Items()
+	if i < 0 || len(items) <= i {
+		return fmt.Errorf("failed to set stack: reason=index is out of stack: index=%d, depth=%d", i, len(items))
+	}
+	d.runtime.stack.objects[i] = obj
+	return nil
+}
+
+func (d *Debugger) Memory(addr int) (*Object, error) {
+	if addr < 0 || len(*d.runtime.memory) <= addr {
+		return nil, fmt.Errorf("failed to get memory: reason=addr is out of memory: addr=%d", addr)
+	}
+	return d.runtime.memory.GetAt(addr), nil
+}
+
+func (d *Debugger) SetMemory(addr int, obj *Object) error {
+	return d.runtime.memory.SetAt(addr, obj)
+}
+
+// MemorySize メモリの大きさ
+func (d *Debugger) MemorySize() int {
+	return len(*d.runtime.memory)
+}
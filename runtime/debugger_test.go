@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func newTestDebugger(t *testing.T) *Debugger {
+	// main:            3
+	//   MOVE g1 1      4
+	//   CALL add       5
+	//   MOVE g2 g1     6
+	//   RETURN         7
+	// add:             8
+	//   ADD g1 2       9
+	//   RETURN         10
+	runtime := NewRuntime(4, 4)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewObject(1)),
+		NewCallOp(NewLabelObject(1)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_2), NewRegisterObject(REG_GENERAL_1)),
+		NewReturnOp(),
+		NewDefLabelOp(NewLabelObject(1)),
+		NewAddOp(NewRegisterObject(REG_GENERAL_1), NewObject(2)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	d := NewDebugger(runtime)
+	assert.Nil(t, d.Start())
+	return d
+}
+
+func TestDebugger_Step(t *testing.T) {
+	d := newTestDebugger(t)
+	assert.Equal(t, 0, d.PC())
+	assert.Nil(t, d.Step()) // DEF_LABEL -1
+	assert.Nil(t, d.Step()) // CALL main
+	assert.Equal(t, 3, d.PC())
+	assert.Equal(t, 1, d.Depth())
+
+	// CALLを飛ばす
+	assert.Nil(t, d.Step())
+	assert.Nil(t, d.Step())
+	assert.Nil(t, d.StepOver())
+	assert.Equal(t, 6, d.PC())
+	assert.Equal(t, NewObject(3), d.Register(REG_GENERAL_1))
+
+	assert.Nil(t, d.StepOut())
+	assert.Equal(t, 2, d.PC())
+	assert.Equal(t, 0, d.Depth())
+	assert.Nil(t, d.Step())
+	assert.True(t, d.Exited())
+	assert.EqualError(t, d.Step(), "failed to step: reason=program has exited")
+}
+
+func TestDebugger_Breakpoint(t *testing.T) {
+	d := newTestDebugger(t)
+	assert.Nil(t, d.SetBreakpointAtLabel(1))
+	assert.Nil(t, d.SetBreakpoint(6))
+	assert.Equal(t, []int{6, 8}, d.Breakpoints())
+	assert.EqualError(t, d.SetBreakpoint(100), "failed to set breakpoint: reason=pc is out of program: pc=100")
+	assert.EqualError(t, d.SetBreakpointAtLabel(5), "failed to set breakpoint: failed to get symbol: not registered: l_5")
+
+	assert.Nil(t, d.Continue())
+	assert.Equal(t, 8, d.PC())
+	assert.Equal(t, 2, d.Depth())
+	assert.Equal(t, []int{1, 5}, d.CallStack())
+	// 戻りアドレスはmainのCALLの次
+	assert.Equal(t, []*Object{NewReferenceObject(2), NewReferenceObject(6)}, d.Stack())
+
+	// 呼び出し先で値を書き換える
+	assert.Nil(t, d.SetRegister(REG_GENERAL_1, NewObject(10)))
+	// 呼び出し元に戻ったところで止まる
+	assert.Nil(t, d.StepOut())
+	assert.Equal(t, 6, d.PC())
+	assert.Equal(t, NewObject(12), d.Register(REG_GENERAL_1))
+
+	d.ClearBreakpoint(8)
+	assert.Nil(t, d.Continue())
+	assert.True(t, d.Exited())
+	assert.Equal(t, NewObject(12), d.Register(REG_GENERAL_2))
+}
+
+func TestDebugger_Memory(t *testing.T) {
+	d := newTestDebugger(t)
+	assert.Nil(t, d.SetMemory(1, NewObject('a')))
+	obj, err := d.Memory(1)
+	assert.Nil(t, err)
+	assert.Equal(t, NewObject('a'), obj)
+	_, err = d.Memory(4)
+	assert.EqualError(t, err, "failed to get memory: reason=addr is out of memory: addr=4")
+
+	assert.Nil(t, d.Step())
+	assert.Nil(t, d.Step())
+	assert.Nil(t, d.SetStack(0, NewReferenceObject(1)))
+	assert.Equal(t, []*Object{NewReferenceObject(1)}, d.Stack())
+	assert.EqualError(t, d.SetStack(1, NewNullObject()), "failed to set stack: reason=index is out of stack: index=1, depth=1")
+}
+
+func TestDebugger_Thread(t *testing.T) {
+	// 呼び出しの深さはスレッドごとに数え，SPAWNしたスレッドのRETURNでmainの深さは変わらない
+	runtime := NewRuntime(10, 1)
+	assert.Nil(t, runtime.Load(newWorkerProgram()))
+	assert.Nil(t, runtime.CollectLabel())
+	d := NewDebugger(runtime)
+	assert.Nil(t, d.Start())
+	assert.Nil(t, d.SetBreakpoint(8)) // JOIN
+	assert.Nil(t, d.Continue())
+	assert.Equal(t, []int{1}, d.CallStack())
+
+	spawned := map[int]bool{}
+	for !d.Exited() {
+		assert.Nil(t, d.Step())
+		switch id := runtime.ThreadID(); {
+		case d.Exited(), id == 0 && d.PC() <= startupExitPC:
+			assert.Equal(t, 0, d.Depth())
+		case id == 0:
+			assert.Equal(t, []int{1}, d.CallStack())
+		case d.Depth() != 0:
+			// SPAWNのアドレスから始まる
+			spawned[d.CallStack()[0]] = true
+		}
+	}
+	assert.Equal(t, map[int]bool{5: true, 7: true}, spawned)
+	assert.Equal(t, []int{0}, slices.Collect(maps.Keys(d.calls)))
+}
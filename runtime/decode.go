@@ -0,0 +1,216 @@
+package runtime
+
+import "fmt"
+
+// instruction Loadの時点で前処理した命令
+// 実行する関数をオペランドの種類まで見て選んでおき，ラベルは飛び先のPCに解決しておく
+type instruction struct {
+	op      *Operation
+	handler handler
+	target  int // ラベルを解決したPC, 解決できなければ-1
+}
+
+// handler 1命令を実行する, EXITならexitedがtrueになる
+type handler func(r *Runtime, ins *instruction) (exited bool, err error)
+
+// handlers 命令の種類ごとの実行関数
+// decodeでより速いものに差し替えることがある
+var handlers = [...]handler{
+	OP_EXIT: func(r *Runtime, _ *instruction) (bool, error) { return r.doExit(), nil },
+	OP_MOVE: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doMove(ins.op.param1, ins.op.param2)
+	},
+	OP_PUSH: func(r *Runtime, ins *instruction) (bool, error) { return false, r.doPush(ins.op.param1) },
+	OP_POP:  func(r *Runtime, ins *instruction) (bool, error) { return false, r.doPop(ins.op.param1) },
+	OP_CALL: func(r *Runtime, ins *instruction) (bool, error) { return false, r.doCall(ins.op.param1) },
+	OP_RETURN: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doReturn()
+	},
+	OP_ADD: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doAdd(ins.op.param1, ins.op.param2)
+	},
+	OP_SUB: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doSub(ins.op.param1, ins.op.param2)
+	},
+	OP_JUMP: func(r *Runtime, ins *instruction) (bool, error) { return false, r.doJump(ins.op.param1) },
+	OP_JUMP_TRUE: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doJumpTrue(ins.op.param1)
+	},
+	OP_JUMP_FALSE: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doJumpFalse(ins.op.param1)
+	},
+	OP_DEF_LABEL: func(*Runtime, *instruction) (bool, error) { return false, nil },
+	OP_SYSCALL_WRITE: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doSyscallWrite(ins.op.param1, ins.op.param2)
+	},
+	OP_SYSCALL_READ: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doSyscallRead(ins.op.param1, ins.op.param2, ins.op.param3)
+	},
+	OP_SYSCALL_OPEN: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doSyscallOpen(ins.op.param1, ins.op.param2, ins.op.param3)
+	},
+	OP_SYSCALL_CLOSE: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doSyscallClose(ins.op.param1)
+	},
+	OP_ENTER: func(r *Runtime, ins *instruction) (bool, error) { return false, r.doEnter(ins.op.param1) },
+	OP_LEAVE: func(r *Runtime, ins *instruction) (bool, error) { return false, r.doLeave() },
+	OP_LOAD_ENV: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doLoadEnv(ins.op.param1, ins.op.param2, ins.op.param3)
+	},
+	OP_STORE_ENV: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doStoreEnv(ins.op.param1, ins.op.param2, ins.op.param3)
+	},
+	OP_MAKE_CLOSURE: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doMakeClosure(ins.op.param1, ins.op.param2, ins.op.param3)
+	},
+	OP_PEEK: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doPeek(ins.op.param1, ins.op.param2)
+	},
+	OP_SPAWN: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doSpawn(ins.op.param1, ins.op.param2, ins.op.param3)
+	},
+	OP_YIELD: func(r *Runtime, _ *instruction) (bool, error) { return false, r.doYield() },
+	OP_JOIN: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doJoin(ins.op.param1, ins.op.param2)
+	},
+	OP_MAKE_CHANNEL: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doMakeChannel(ins.op.param1, ins.op.param2)
+	},
+	OP_SEND: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doSend(ins.op.param1, ins.op.param2)
+	},
+	OP_RECV: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doRecv(ins.op.param1, ins.op.param2)
+	},
+	OP_CLOSE: func(r *Runtime, ins *instruction) (bool, error) { return false, r.doClose(ins.op.param1) },
+	OP_CALL_HOST: func(r *Runtime, ins *instruction) (bool, error) {
+		return false, r.doCallHost(ins.op.param1, ins.op.param2)
+	},
+}
+
+func execUnsupported(_ *Runtime, ins *instruction) (bool, error) {
+	return false, fmt.Errorf("unsupported Op: %s", ins.op.kind.String())
+}
+
+// 飛び先を解決できた分岐
+func execJumpResolved(r *Runtime, ins *instruction) (bool, error) {
+	r.setPC(ins.target)
+	return false, nil
+}
+func execJumpTrueResolved(r *Runtime, ins *instruction) (bool, error) {
+	flag := r.register[REG_BOOL_FLAG]
+	if flag == nil || flag.kind != OBJ_BOOL { // エラーはdoJumpTrueに任せる
+		return false, r.doJumpTrue(ins.op.param1)
+	}
+	if flag.data == 1 {
+		r.setPC(ins.target)
+	}
+	return false, nil
+}
+func execJumpFalseResolved(r *Runtime, ins *instruction) (bool, error) {
+	flag := r.register[REG_BOOL_FLAG]
+	if flag == nil || flag.kind != OBJ_BOOL { // エラーはdoJumpFalseに任せる
+		return false, r.doJumpFalse(ins.op.param1)
+	}
+	if flag.data == 0 {
+		r.setPC(ins.target)
+	}
+	return false, nil
+}
+func execCallResolved(r *Runtime, ins *instruction) (bool, error) {
+	return false, r.callAt(ins.target)
+}
+
+var resolvedJumps = map[OperationKind]handler{
+	OP_JUMP:       execJumpResolved,
+	OP_JUMP_TRUE:  execJumpTrueResolved,
+	OP_JUMP_FALSE: execJumpFalseResolved,
+}
+
+// compareHandler 比較する2つの値をどこから読むかを先に決めておく
+func compareHandler(cmp func(a, b int) bool, obj1, obj2 *Object) handler {
+	switch {
+	case obj1.kind == OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
+		reg1, reg2 := RegisterKind(obj1.data), RegisterKind(obj2.data)
+		return func(r *Runtime, _ *instruction) (bool, error) {
+			r.setBoolFlag(cmp(r.register[reg1].data, r.register[reg2].data))
+			return false, nil
+		}
+	case obj1.kind == OBJ_REGISTER:
+		reg1, val2 := RegisterKind(obj1.data), obj2.data
+		return func(r *Runtime, _ *instruction) (bool, error) {
+			r.setBoolFlag(cmp(r.register[reg1].data, val2))
+			return false, nil
+		}
+	case obj2.kind == OBJ_REGISTER:
+		val1, reg2 := obj1.data, RegisterKind(obj2.data)
+		return func(r *Runtime, _ *instruction) (bool, error) {
+			r.setBoolFlag(cmp(val1, r.register[reg2].data))
+			return false, nil
+		}
+	default:
+		result := cmp(obj1.data, obj2.data)
+		return func(r *Runtime, _ *instruction) (bool, error) {
+			r.setBoolFlag(result)
+			return false, nil
+		}
+	}
+}
+
+var comparators = map[OperationKind]func(a, b int) bool{
+	OP_EQ: func(a, b int) bool { return a == b },
+	OP_NE: func(a, b int) bool { return a != b },
+	OP_LT: func(a, b int) bool { return a < b },
+	OP_LE: func(a, b int) bool { return a <= b },
+}
+
+// decode 命令ごとに実行関数を選び，ラベルをPCに解決する
+// 解決できないラベルやオペランドの誤りは，これまで通り実行した時にエラーにする
+func decode(program Program) []instruction {
+	labels := NewSymbolTable()
+	labels.SetLimit(program)
+	for pc, op := range program {
+		if op.kind == OP_DEF_LABEL && op.param1 != nil && op.param1.kind == OBJ_LABEL {
+			_ = labels.Set(op.param1.data, pc) // 二重定義はCollectLabelでエラーにする
+		}
+	}
+	resolve := func(obj *Object, kinds ...ObjectKind) (int, bool) {
+		if obj == nil {
+			return -1, false
+		}
+		for _, kind := range kinds {
+			if obj.kind == kind {
+				pc, err := labels.Get(obj.data)
+				return pc, err == nil
+			}
+		}
+		return -1, false
+	}
+
+	code := make([]instruction, len(program))
+	for pc, op := range program {
+		ins := instruction{op: op, handler: execUnsupported, target: -1}
+		if int(op.kind) < len(handlers) && handlers[op.kind] != nil {
+			ins.handler = handlers[op.kind]
+		}
+		switch op.kind {
+		case OP_JUMP, OP_JUMP_TRUE, OP_JUMP_FALSE:
+			if target, ok := resolve(op.param1, OBJ_LABEL); ok {
+				ins.target = target
+				ins.handler = resolvedJumps[op.kind]
+			}
+		case OP_CALL:
+			if target, ok := resolve(op.param1, OBJ_LABEL, OBJ_FUNCTION); ok {
+				ins.target = target
+				ins.handler = execCallResolved
+			}
+		case OP_EQ, OP_NE, OP_LT, OP_LE:
+			if op.param1 == nil || op.param2 == nil {
+				break
+			}
+			ins.handler = compareHandler(comparators[op.kind], op.param1, op.param2)
+		}
+		code[pc] = ins
+	}
+	return code
+}
@@ -0,0 +1,71 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	code := decode(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewJumpOp(NewLabelObject(1)),
+		NewCallOp(NewLabelObject(0)),
+		NewJumpTrueOp(NewLabelObject(9)), // 定義されていない
+		NewDefLabelOp(NewLabelObject(1)),
+		NewEqOp(NewRegisterObject(REG_GENERAL_1), NewObject(1)),
+	})
+	assert.Equal(t, 6, len(code))
+	assert.Equal(t, 4, code[1].target)
+	assert.Equal(t, 0, code[2].target)
+	assert.Equal(t, -1, code[3].target)
+	for _, ins := range code {
+		assert.NotNil(t, ins.handler)
+	}
+}
+
+func TestRuntime_Run_UnresolvedLabel(t *testing.T) {
+	// 解決できないラベルは実行した時にエラーになる
+	runtime := NewRuntime(2, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewJumpOp(NewLabelObject(9)),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "failed to get symbol: not registered: l_9")
+	assert.Equal(t, NewObject(int(STAT_ERR)), runtime.register[REG_STATUS])
+
+	runtime = NewRuntime(2, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewJumpOp(NewObject(1)),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "unsupported jump value: reason=dest is not label: dest=1")
+}
+
+func TestRuntime_Run_JumpTrueNotBool(t *testing.T) {
+	runtime := NewRuntime(2, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_BOOL_FLAG), NewObject(3)),
+		NewJumpTrueOp(NewLabelObject(0)),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "unsupported jump_true value: reason=bool_flag has not bool: 3")
+}
+
+func TestRuntime_Run_CompareImmediate(t *testing.T) {
+	runtime := NewRuntime(2, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewLtOp(NewObject(1), NewObject(2)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewRegisterObject(REG_BOOL_FLAG)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_2), NewObject(2)),
+		NewLeOp(NewObject(3), NewRegisterObject(REG_GENERAL_2)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewObject(true), runtime.register[REG_GENERAL_1])
+	assert.Equal(t, NewObject(false), runtime.register[REG_BOOL_FLAG])
+}
@@ -0,0 +1,182 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileSystem SYSCALL_OPENで開けるファイルの置き場所
+// nameはfs.ValidPathの形式で，ルートの外は指せない
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	OpenWriter(name string, append bool) (io.WriteCloser, error)
+}
+
+type dirFS string
+
+// DirFS dirをルートにしたFileSystem
+// dirの中のシンボリックリンクはたどるが，dirの外を指すものは開けない
+func DirFS(dir string) FileSystem {
+	return dirFS(dir)
+}
+
+// open 開くたびにルートを開き直すので，dirを後から作っても使える
+func (dir dirFS) open(name string, flag int) (*os.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := os.OpenRoot(string(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.OpenFile(filepath.FromSlash(name), flag, 0644)
+}
+
+func (dir dirFS) Open(name string) (io.ReadCloser, error) {
+	return dir.open(name, os.O_RDONLY)
+}
+
+func (dir dirFS) OpenWriter(name string, append bool) (io.WriteCloser, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if append {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	return dir.open(name, flag)
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+// ReadOnlyFS fs.FSを読み込み専用のFileSystemにする, 書き込もうとするとfs.ErrPermissionになる
+func ReadOnlyFS(fsys fs.FS) FileSystem {
+	return readOnlyFS{fsys: fsys}
+}
+
+func (ro readOnlyFS) Open(name string) (io.ReadCloser, error) {
+	return ro.fsys.Open(name)
+}
+
+func (ro readOnlyFS) OpenWriter(name string, _ bool) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+// openFile ファイルディスクリプタの先
+// 標準入出力はcloserを持たない
+type openFile struct {
+	reader *bufio.Reader
+	writer io.Writer
+	closer io.Closer
+}
+
+func (r *Runtime) initFiles() {
+	r.files = map[int]*openFile{
+		STD_IN:  {reader: r.stdin},
+		STD_OUT: {writer: r.stdout},
+		STD_ERR: {writer: r.stderr},
+	}
+}
+
+// fileAt fdが指すファイル, レジスタならその中身をfdとして使う
+func (r *Runtime) fileAt(fd *Object) (int, *openFile, bool) {
+	if fd.kind == OBJ_REGISTER {
+		fd = r.register[RegisterKind(fd.data)]
+	}
+	if fd == nil || fd.kind != OBJ_INT {
+		return 0, nil, false
+	}
+	f, ok := r.files[fd.data]
+	return fd.data, f, ok
+}
+
+// newFd 使われていない一番小さいファイルディスクリプタ
+func (r *Runtime) newFd() int {
+	fd := STD_ERR + 1
+	for {
+		if _, ok := r.files[fd]; !ok {
+			return fd
+		}
+		fd++
+	}
+}
+
+// doSyscallOpen 開けたらBOOL_FLAGをtrueにしてdestにファイルディスクリプタを入れる
+// ファイルがない・FileSystemが設定されていないなど開けなければBOOL_FLAGをfalseにしてdestにはnullを入れる
+func (r *Runtime) doSyscallOpen(path, mode, dest *Object) error {
+	if path.kind == OBJ_REGISTER {
+		path = r.register[RegisterKind(path.data)]
+	}
+	if path == nil || path.kind != OBJ_REFERENCE {
+		return fmt.Errorf("unsupported syscall_open value: reason=path is not REFERENCE: path=%v", path)
+	}
+	name, ok := r.stringAt(path.data)
+	if !ok {
+		return fmt.Errorf("unsupported syscall_open value: reason=path is not string: path=%v", path)
+	}
+	if dest.kind != OBJ_REGISTER && dest.kind != OBJ_REFERENCE {
+		return fmt.Errorf("unsupported syscall_open value: reason=dest is nor REGISTER, REFERENCE: dest=%v", dest)
+	}
+
+	f := &openFile{}
+	var err error
+	switch {
+	case r.fsys == nil:
+		err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	case mode.IsSame(NewObject(OPEN_READ)):
+		var rc io.ReadCloser
+		if rc, err = r.fsys.Open(name); err == nil {
+			f.reader, f.closer = bufio.NewReader(rc), rc
+		}
+	case mode.IsSame(NewObject(OPEN_WRITE)), mode.IsSame(NewObject(OPEN_APPEND)):
+		var wc io.WriteCloser
+		if wc, err = r.fsys.OpenWriter(name, mode.IsSame(NewObject(OPEN_APPEND))); err == nil {
+			f.writer, f.closer = wc, wc
+		}
+	default:
+		return fmt.Errorf("unsupported syscall_open value: reason=unknown mode: mode=%v", mode)
+	}
+
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		r.register[REG_BOOL_FLAG] = NewObject(false)
+		return r.storeValue(dest, NewNullObject())
+	}
+	if err != nil {
+		return err
+	}
+	fd := r.newFd()
+	r.files[fd] = f
+	r.register[REG_BOOL_FLAG] = NewObject(true)
+	return r.storeValue(dest, NewObject(fd))
+}
+
+func (r *Runtime) doSyscallClose(fd *Object) error {
+	no, f, ok := r.fileAt(fd)
+	if !ok {
+		return fmt.Errorf("unsupported syscall_close value: reason=fd is not open: fd=%v", fd)
+	}
+	if f.closer == nil {
+		return fmt.Errorf("unsupported syscall_close value: reason=cannot close std stream: fd=%v", fd)
+	}
+	delete(r.files, no)
+	return f.closer.Close()
+}
+
+// Close SYSCALL_OPENで開いたまま残っているファイルを全て閉じる
+func (r *Runtime) Close() error {
+	var errs []error
+	for fd, f := range r.files {
+		if f.closer == nil {
+			continue
+		}
+		delete(r.files, fd)
+		errs = append(errs, f.closer.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRuntime_Run_SyscallOpen(t *testing.T) {
+	dir := t.TempDir()
+	runtime := NewRuntime(1, 30, WithFS(DirFS(dir)))
+	assert.Nil(t, runtime.storeString(NewReferenceObject(0), "out.txt"))
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		// 書き込んで閉じる
+		NewSyscallOpenOp(NewReferenceObject(0), NewObject(OPEN_WRITE), NewRegisterObject(REG_GENERAL_1)),
+		NewSyscallWriteOp(NewRegisterObject(REG_GENERAL_1), NewObject('h')),
+		NewSyscallWriteOp(NewRegisterObject(REG_GENERAL_1), NewObject(42)),
+		NewSyscallWriteOp(NewRegisterObject(REG_GENERAL_1), NewObject('\n')),
+		NewSyscallCloseOp(NewRegisterObject(REG_GENERAL_1)),
+		// 読み直す
+		NewSyscallOpenOp(NewReferenceObject(0), NewObject(OPEN_READ), NewRegisterObject(REG_GENERAL_1)),
+		NewSyscallReadOp(NewRegisterObject(REG_GENERAL_1), NewObject(READ_LINE), NewRegisterObject(REG_GENERAL_2)),
+		NewSyscallCloseOp(NewRegisterObject(REG_GENERAL_1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewObject(true), runtime.register[REG_BOOL_FLAG])
+	// 閉じたので同じ番号がまた使われている
+	assert.Equal(t, NewObject(STD_ERR+1), runtime.register[REG_GENERAL_1])
+	str, ok := runtime.stringAt(runtime.register[REG_GENERAL_2].data)
+	assert.True(t, ok)
+	assert.Equal(t, "h42", str)
+
+	data, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "h42\n", string(data))
+	assert.Equal(t, 3, len(runtime.files))
+}
+
+func TestRuntime_Run_SyscallOpen_Fail(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys FileSystem
+		path string
+		mode int
+	}{
+		{"no filesystem", nil, "a.txt", OPEN_READ},
+		{"not exist", DirFS(t.TempDir()), "a.txt", OPEN_READ},
+		{"outside root", DirFS(t.TempDir()), "../a.txt", OPEN_WRITE},
+		{"read only", ReadOnlyFS(fstest.MapFS{"a.txt": {Data: []byte("a")}}), "a.txt", OPEN_APPEND},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := NewRuntime(1, 20, WithFS(tt.fsys))
+			assert.Nil(t, runtime.storeString(NewReferenceObject(0), tt.path))
+			_ = runtime.Load(Program{
+				NewDefLabelOp(NewLabelObject(0)),
+				NewSyscallOpenOp(NewReferenceObject(0), NewObject(tt.mode), NewRegisterObject(REG_GENERAL_1)),
+				NewReturnOp(),
+			})
+			assert.Nil(t, runtime.CollectLabel())
+			assert.Nil(t, runtime.Run())
+			assert.Equal(t, NewObject(false), runtime.register[REG_BOOL_FLAG])
+			assert.Equal(t, NewNullObject(), runtime.register[REG_GENERAL_1])
+		})
+	}
+}
+
+func TestRuntime_Run_SyscallFd_Errors(t *testing.T) {
+	fsys := ReadOnlyFS(fstest.MapFS{"a.txt": {Data: []byte("a")}})
+	tests := []struct {
+		name string
+		op   *Operation
+		err  string
+	}{
+		{"write to stdin", NewSyscallWriteOp(NewObject(STD_IN), NewObject('a')), "unsupported syscall_write value: reason=dest is not writable fd: dest=1"},
+		{"read from stdout", NewSyscallReadOp(NewObject(STD_OUT), NewObject(READ_CHAR), NewRegisterObject(REG_GENERAL_1)), "unsupported syscall_read value: reason=src is not readable fd: src=2"},
+		{"close not opened", NewSyscallCloseOp(NewObject(4)), "unsupported syscall_close value: reason=fd is not open: fd=4"},
+		{"close std", NewSyscallCloseOp(NewObject(STD_OUT)), "unsupported syscall_close value: reason=cannot close std stream: fd=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := NewRuntime(1, 20, WithFS(fsys))
+			_ = runtime.Load(Program{NewDefLabelOp(NewLabelObject(0)), tt.op, NewReturnOp()})
+			assert.Nil(t, runtime.CollectLabel())
+			assert.EqualError(t, runtime.Run(), tt.err)
+		})
+	}
+}
+
+func TestRuntime_Close(t *testing.T) {
+	runtime := NewRuntime(1, 20, WithFS(ReadOnlyFS(fstest.MapFS{"a.txt": {Data: []byte("a")}})))
+	assert.Nil(t, runtime.storeString(NewReferenceObject(0), "a.txt"))
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewSyscallOpenOp(NewReferenceObject(0), NewObject(OPEN_READ), NewRegisterObject(REG_GENERAL_1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, 4, len(runtime.files))
+	assert.Nil(t, runtime.Close())
+	assert.Equal(t, 3, len(runtime.files))
+}
+
+func TestDirFS_Symlink(t *testing.T) {
+	outside := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+	root := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+	assert.Nil(t, os.Symlink("a.txt", filepath.Join(root, "inside.txt")))
+	assert.Nil(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")))
+	assert.Nil(t, os.Symlink(outside, filepath.Join(root, "out")))
+	fsys := DirFS(root)
+
+	// ルートの中を指すリンクはたどる
+	f, err := fsys.Open("inside.txt")
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	// ルートの外を指すリンクは開けない
+	for _, name := range []string{"secret.txt", "out/secret.txt"} {
+		_, err = fsys.Open(name)
+		assert.NotNil(t, err, name)
+		_, err = fsys.OpenWriter(name, true)
+		assert.NotNil(t, err, name)
+	}
+	data, err := os.ReadFile(filepath.Join(outside, "secret.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(data))
+}
@@ -0,0 +1,46 @@
+package runtime
+
+import "fmt"
+
+// HostFunc プログラムから呼べるGoの関数
+// 引数は積んだ順に渡され，戻り値はSTATUSに入る, nilを返すとnullになる
+type HostFunc func(args []*Object) (*Object, error)
+
+// RegisterHostFunc nameで参照しているCALL_HOSTからfnを呼べるようにする
+// 名前はLoadObjectで読んだシンボルで引くので，同じ名前で登録し直すと後のものが使われる
+func (r *Runtime) RegisterHostFunc(name string, fn HostFunc) {
+	r.hostFuncs[name] = fn
+}
+
+func (r *Runtime) doCallHost(fn, argc *Object) error {
+	if fn.kind != OBJ_HOST {
+		return fmt.Errorf("unsupported call_host value: reason=fn is not HOST: fn=%v", fn)
+	}
+	if argc.kind != OBJ_INT || argc.data < 0 {
+		return fmt.Errorf("unsupported call_host value: reason=argc is not INT: argc=%v", argc)
+	}
+	name := r.symbolTable.Name(fn.data)
+	host, ok := r.hostFuncs[name]
+	if !ok {
+		return fmt.Errorf("failed to call host function: reason=not registered: name=%s", name)
+	}
+	if r.stack.Depth() < argc.data {
+		return fmt.Errorf("failed to call host function: reason=not enough arguments on stack: name=%s, argc=%d, depth=%d", name, argc.data, r.stack.Depth())
+	}
+	args := make([]*Object, argc.data)
+	for i := argc.data - 1; 0 <= i; i-- {
+		arg, err := r.stack.Pop()
+		if err != nil {
+			return err
+		}
+		args[i] = arg
+	}
+	result, err := host(args)
+	if err != nil {
+		return fmt.Errorf("failed to call host function: name=%s: %w", name, err)
+	}
+	if result == nil {
+		result = NewNullObject()
+	}
+	return r.setRegister(REG_STATUS, result.Clone())
+}
@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRuntime_CallHost(t *testing.T) {
+	obj, err := Assemble(`main:
+  PUSH 10
+  PUSH 3
+  CALL_HOST host(sub) 2
+  RETURN`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sub"}, obj.Externs())
+	assert.Equal(t, []string{}, obj.Undefined())
+	assert.Equal(t, "main:\n  PUSH 10\n  PUSH 3\n  CALL_HOST host(sub) 2\n  RETURN", Disassemble(obj))
+
+	runtime := NewRuntime(10, 1)
+	var got []*Object
+	runtime.RegisterHostFunc("sub", func(args []*Object) (*Object, error) {
+		got = args
+		return NewObject(args[0].GetData() - args[1].GetData()), nil
+	})
+	assert.Nil(t, runtime.LoadObject(obj))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	// 引数は積んだ順に渡される
+	assert.Equal(t, []*Object{NewObject(10), NewObject(3)}, got)
+	assert.Equal(t, NewObject(7), runtime.register[REG_STATUS])
+	assert.Equal(t, 0, runtime.stack.Depth())
+
+	// nilを返すとnullになる
+	runtime.RegisterHostFunc("sub", func([]*Object) (*Object, error) { return nil, nil })
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewNullObject(), runtime.register[REG_STATUS])
+
+	// ホスト関数のエラーは実行時エラーになる
+	errHost := errors.New("host error")
+	runtime.RegisterHostFunc("sub", func([]*Object) (*Object, error) { return nil, errHost })
+	err = runtime.Run()
+	assert.EqualError(t, err, "failed to call host function: name=sub: host error")
+	assert.ErrorIs(t, err, errHost)
+	assert.Equal(t, NewObject(int(STAT_ERR)), runtime.register[REG_STATUS])
+
+	// Resetしても登録したホスト関数は残る
+	assert.Nil(t, runtime.Reset())
+	assert.Nil(t, runtime.LoadObject(obj))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "failed to call host function: name=sub: host error")
+}
+
+func TestRuntime_CallHostErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   *Operation
+		err  string
+	}{
+		{"fn", NewCallHostOp(NewLabelObject(0), NewObject(0)), "unsupported call_host value: reason=fn is not HOST: fn=label(0)"},
+		{"argc", NewCallHostOp(NewHostObject(1), NewObject(-1)), "unsupported call_host value: reason=argc is not INT: argc=-1"},
+		{"not registered", NewCallHostOp(NewHostObject(1), NewObject(0)), "failed to call host function: reason=not registered: name=l_1"},
+		{"args", NewCallHostOp(NewHostObject(2), NewObject(1)), "failed to call host function: reason=not enough arguments on stack: name=l_2, argc=1, depth=0"},
+		{"huge args", NewCallHostOp(NewHostObject(2), NewObject(1000000000000)), "failed to call host function: reason=not enough arguments on stack: name=l_2, argc=1000000000000, depth=0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := NewRuntime(10, 1)
+			runtime.RegisterHostFunc("l_2", func([]*Object) (*Object, error) { return nil, nil })
+			assert.Nil(t, runtime.Reload(Program{
+				NewDefLabelOp(NewLabelObject(0)),
+				NewPopOp(NewRegisterObject(REG_RETURN_ADDRESS)),
+				tt.op,
+				NewReturnOp(),
+			}))
+			assert.EqualError(t, runtime.Run(), tt.err)
+		})
+	}
+}
+
+func TestLink_Externs(t *testing.T) {
+	// 同じホスト関数を別々のファイルから呼んでも重複にはならない
+	mainObj := NewObjectFile(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewCallHostOp(NewHostObject(1), NewObject(0)),
+		NewCallOp(NewLabelObject(2)),
+		NewReturnOp(),
+	}, map[string]int{"main": 0, "now": 1, "helper": 2})
+	libObj := NewObjectFile(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewCallHostOp(NewHostObject(1), NewObject(0)),
+		NewReturnOp(),
+	}, map[string]int{"helper": 0, "now": 1})
+	assert.Equal(t, []string{"helper"}, mainObj.Undefined())
+	assert.Equal(t, []string{"now"}, libObj.Externs())
+
+	linked, err := Link(mainObj, libObj)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"main": 0, "helper": 1, "now": 2}, linked.Symbols)
+	assert.Equal(t, []string{"now"}, linked.Externs())
+	assert.Equal(t, NewHostObject(2), linked.Program[1].param1)
+	assert.Equal(t, NewHostObject(2), linked.Program[5].param1)
+}
@@ -0,0 +1,113 @@
+package runtime
+
+import "fmt"
+
+// isLabelRef ラベル番号を持っているオブジェクトか
+func isLabelRef(obj *Object) bool {
+	return obj != nil && (obj.kind == OBJ_LABEL || obj.kind == OBJ_FUNCTION || obj.kind == OBJ_HOST)
+}
+
+func (op *Operation) params() []*Object {
+	return []*Object{op.param1, op.param2, op.param3, op.param4}
+}
+
+// Labels 命令が定義・参照しているラベル番号
+func (op *Operation) Labels() []int {
+	labels := []int{}
+	for _, param := range op.params() {
+		if isLabelRef(param) {
+			labels = append(labels, param.data)
+		}
+	}
+	return labels
+}
+
+// relocate ラベル番号を付け替えた新しい命令を作る
+func (op *Operation) relocate(labels map[int]int) *Operation {
+	params := op.params()
+	for i, param := range params {
+		if isLabelRef(param) {
+			params[i] = &Object{kind: param.kind, data: labels[param.data]}
+		}
+	}
+	return &Operation{kind: op.kind, param1: params[0], param2: params[1], param3: params[2], param4: params[3]}
+}
+
+// Link オブジェクトファイルをまとめて1つの実行できるプログラムにする
+// ラベルは振り直し，名前が同じシンボルは同じラベルになる
+// mainはLoadが挿入するstartupから呼ばれるので必ず0番にする
+func Link(objs ...*ObjectFile) (*ObjectFile, error) {
+	// 定義の重複を調べる
+	definedIn := make(map[string]int)
+	for i, obj := range objs {
+		for _, name := range obj.Defined() {
+			if j, ok := definedIn[name]; ok {
+				return nil, fmt.Errorf("failed to link: duplicate symbol: %s: defined in object %d and %d", name, j, i)
+			}
+			definedIn[name] = i
+		}
+	}
+	for i, obj := range objs {
+		for _, name := range obj.Undefined() {
+			if _, ok := definedIn[name]; !ok {
+				return nil, fmt.Errorf("failed to link: undefined symbol: %s: referenced in object %d", name, i)
+			}
+		}
+	}
+	if _, ok := definedIn["main"]; !ok {
+		return nil, fmt.Errorf("failed to link: undefined symbol: main")
+	}
+
+	symbols := map[string]int{"main": 0}
+	counter := 1
+	for _, obj := range objs {
+		for _, name := range obj.Defined() {
+			if _, ok := symbols[name]; !ok {
+				symbols[name] = counter
+				counter++
+			}
+		}
+	}
+	// ホスト関数は定義がなくても名前を残しておく
+	for _, obj := range objs {
+		for _, name := range obj.Externs() {
+			if _, ok := symbols[name]; !ok {
+				symbols[name] = counter
+				counter++
+			}
+		}
+	}
+
+	program := Program{}
+	for _, obj := range objs {
+		names := make(map[int]string)
+		for name, no := range obj.Symbols {
+			names[no] = name
+		}
+		defined := obj.definedLabels()
+		labels := make(map[int]int)
+		for _, op := range obj.Program {
+			for _, param := range op.params() {
+				if !isLabelRef(param) {
+					continue
+				}
+				if _, ok := labels[param.data]; ok {
+					continue
+				}
+				if name, ok := names[param.data]; ok {
+					labels[param.data] = symbols[name]
+				} else { // ファイルの中だけのラベル
+					if !defined[param.data] {
+						return nil, fmt.Errorf("failed to link: undefined label: %v", param)
+					}
+					labels[param.data] = counter
+					counter++
+				}
+			}
+		}
+		for _, op := range obj.Program {
+			program = append(program, op.relocate(labels))
+		}
+	}
+	return NewObjectFile(program, symbols), nil
+}
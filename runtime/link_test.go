@@ -0,0 +1,145 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	// main: call helper(l_1); return
+	mainObj := NewObjectFile(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_CALL, param1: NewLabelObject(1)},
+		&Operation{kind: OP_RETURN},
+	}, map[string]int{"main": 0, "helper": 1})
+	// helper: ループ用の名前のないラベルを使う. 番号はmainのファイルとぶつかっている
+	libObj := NewObjectFile(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(3)},
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(1)},
+		&Operation{kind: OP_SUB, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(1)},
+		&Operation{kind: OP_LT, param1: NewObject(0), param2: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_JUMP_TRUE, param1: NewLabelObject(1)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_2), param2: NewFunctionObject(0)},
+		&Operation{kind: OP_RETURN},
+	}, map[string]int{"helper": 0})
+	assert.Equal(t, []string{"main"}, mainObj.Defined())
+	assert.Equal(t, []string{"helper"}, mainObj.Undefined())
+	assert.Equal(t, []string{"helper"}, libObj.Defined())
+	assert.Equal(t, []string{}, libObj.Undefined())
+
+	linked, err := Link(mainObj, libObj)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"main": 0, "helper": 1}, linked.Symbols)
+	assert.Equal(t, "DEF_LABEL label(0)\n"+
+		"CALL label(1)\n"+
+		"RETURN\n"+
+		"DEF_LABEL label(1)\n"+
+		"MOVE register(GENERAL_1) 3\n"+
+		"DEF_LABEL label(2)\n"+
+		"SUB register(GENERAL_1) 1\n"+
+		"LT 0 register(GENERAL_1)\n"+
+		"JUMP_TRUE label(2)\n"+
+		"MOVE register(GENERAL_2) function(1)\n"+
+		"RETURN", Export(linked.Program))
+
+	runtime := NewRuntime(4, 4)
+	assert.Nil(t, runtime.Load(linked.Program))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewObject(0), runtime.register[REG_GENERAL_1])
+	assert.Equal(t, NewFunctionObject(1), runtime.register[REG_GENERAL_2])
+}
+
+func TestLink_Errors(t *testing.T) {
+	mainObj := NewObjectFile(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_CALL, param1: NewLabelObject(1)},
+		&Operation{kind: OP_RETURN},
+	}, map[string]int{"main": 0, "helper": 1})
+	_, err := Link(mainObj)
+	assert.EqualError(t, err, "failed to link: undefined symbol: helper: referenced in object 0")
+
+	_, err = Link(mainObj, mainObj)
+	assert.EqualError(t, err, "failed to link: duplicate symbol: main: defined in object 0 and 1")
+
+	libObj := NewObjectFile(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_RETURN},
+	}, map[string]int{"helper": 0})
+	_, err = Link(libObj)
+	assert.EqualError(t, err, "failed to link: undefined symbol: main")
+
+	danglingObj := NewObjectFile(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_JUMP, param1: NewLabelObject(5)},
+	}, map[string]int{"main": 0})
+	_, err = Link(danglingObj)
+	assert.EqualError(t, err, "failed to link: undefined label: label(5)")
+}
+
+func TestObjectFile_Encode(t *testing.T) {
+	obj := NewObjectFile(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(-30)},
+		&Operation{kind: OP_SYSCALL_WRITE, param1: NewObject(STD_OUT), param2: NewObject('あ')},
+		&Operation{kind: OP_MAKE_CLOSURE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewLabelObject(1), param3: NewObject(2)},
+		&Operation{kind: OP_RETURN},
+	}, map[string]int{"main": 0, "main.func1": 1})
+	var buf bytes.Buffer
+	assert.Nil(t, obj.Encode(&buf))
+	assert.Equal(t, "MYOB", buf.String()[:4])
+
+	decoded, err := DecodeObjectFile(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, obj, decoded)
+
+	_, err = DecodeObjectFile(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.EqualError(t, err, "failed to decode object file: unexpected EOF")
+	_, err = DecodeObjectFile(bytes.NewReader([]byte("ELF!")))
+	assert.EqualError(t, err, "failed to decode object file: reason=bad magic: \"ELF!\"")
+}
+
+func TestDecodeObjectFile_Corrupt(t *testing.T) {
+	uvarint := func(v uint64) string {
+		return string(binary.AppendUvarint(nil, v))
+	}
+	header := "MYOB" + uvarint(objectFileVersion)
+	for name, tt := range map[string]struct {
+		data string
+		err  string
+	}{
+		"huge name": {
+			data: header + uvarint(1) + uvarint(1<<62),
+			err:  "failed to decode object file: reason=symbol name too long: 4611686018427387904",
+		},
+		"short name": {
+			data: header + uvarint(1) + uvarint(100) + "main",
+			err:  "failed to decode object file: unexpected EOF",
+		},
+		"huge op count": {
+			data: header + uvarint(0) + uvarint(1<<62),
+			err:  "failed to decode object file: unexpected EOF",
+		},
+		"huge op kind": {
+			data: header + uvarint(0) + uvarint(1) + uvarint(1<<63),
+			err:  "failed to decode object file: reason=unknown operation: 9223372036854775808",
+		},
+		"huge object kind": {
+			data: header + uvarint(0) + uvarint(1) + uvarint(uint64(OP_PUSH)) + uvarint(1) + uvarint(1<<63),
+			err:  "failed to decode object file: reason=unknown object: 9223372036854775808",
+		},
+		"unknown register": {
+			data: header + uvarint(0) + uvarint(1) + uvarint(uint64(OP_PUSH)) + uvarint(1) + uvarint(uint64(OBJ_REGISTER)) + string(binary.AppendVarint(nil, 99)),
+			err:  "failed to decode object file: reason=unknown register: 99",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeObjectFile(strings.NewReader(tt.data))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
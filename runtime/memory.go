@@ -16,12 +16,66 @@ func (m *Memory) SetAt(addr int, obj *Object) error {
 	}
 	return fmt.Errorf("addr must be 0 <= $addr < %d", len(*m))
 }
+
+// GetAt addrの値, 範囲外ならnil
 func (m *Memory) GetAt(addr int) *Object {
+	if !m.InRange(addr) {
+		return nil
+	}
 	return (*m)[addr]
 }
 func (m *Memory) DeleteAt(addr int) {
-	(*m)[addr] = nil
+	if m.InRange(addr) {
+		(*m)[addr] = nil
+	}
 }
+
+// IsEmptyAt addrが空か, 範囲外も空とみなす
 func (m *Memory) IsEmptyAt(addr int) bool {
-	return (*m)[addr] == nil
+	return m.GetAt(addr) == nil
+}
+
+// InRange addrがメモリの中か
+func (m *Memory) InRange(addr int) bool {
+	return 0 <= addr && addr < len(*m)
+}
+
+// Reset すべてのアドレスを空にする
+func (m *Memory) Reset() {
+	clear(*m)
+}
+
+// Alloc size個の連続した空き領域をメモリの後ろ側から探して確保する
+// 先頭にはヘッダとしてlist(size)を置くので，実際にはsize+1個使う
+// 返すのはヘッダのアドレスで，データはaddr+1から始まる
+func (m *Memory) Alloc(size int) (int, error) {
+	need := size + 1
+	run := 0
+	for addr := len(*m) - 1; 0 <= addr; addr-- {
+		if !m.IsEmptyAt(addr) {
+			run = 0
+			continue
+		}
+		run++
+		if run == need {
+			(*m)[addr] = NewListObject(size)
+			for i := addr + 1; i < addr+need; i++ {
+				(*m)[i] = NewNullObject()
+			}
+			return addr, nil
+		}
+	}
+	return 0, fmt.Errorf("failed to alloc memory: reason=no space in memory: size=%d", size)
+}
+
+// Free Allocで確保した領域をヘッダごと解放する
+func (m *Memory) Free(addr int) error {
+	if addr < 0 || len(*m) <= addr || m.IsEmptyAt(addr) || m.GetAt(addr).kind != OBJ_LIST {
+		return fmt.Errorf("failed to free memory: reason=addr is not allocated: addr=%d", addr)
+	}
+	size := m.GetAt(addr).data
+	for i := addr; i <= addr+size; i++ {
+		m.DeleteAt(i)
+	}
+	return nil
 }
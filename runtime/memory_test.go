@@ -44,3 +44,29 @@ func TestMemory_IsEmptyAt(t *testing.T) {
 	assert.False(t, memory.IsEmptyAt(0))
 	assert.True(t, memory.IsEmptyAt(1))
 }
+
+func TestMemory_Alloc(t *testing.T) {
+	memory := NewMemory(5)
+	addr, err := memory.Alloc(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, addr)
+	assert.Equal(t, NewListObject(2), memory.GetAt(2))
+	assert.Equal(t, NewNullObject(), memory.GetAt(3))
+	assert.Equal(t, NewNullObject(), memory.GetAt(4))
+	_, err = memory.Alloc(2)
+	assert.NotNil(t, err)
+	addr, err = memory.Alloc(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, addr)
+}
+
+func TestMemory_Free(t *testing.T) {
+	memory := NewMemory(4)
+	addr, err := memory.Alloc(3)
+	assert.Nil(t, err)
+	assert.Nil(t, memory.Free(addr))
+	for i := 0; i < 4; i++ {
+		assert.True(t, memory.IsEmptyAt(i))
+	}
+	assert.NotNil(t, memory.Free(addr))
+}
@@ -18,6 +18,11 @@ const (
 	OBJ_REGISTER
 	OBJ_LABEL
 	OBJ_REFERENCE
+	OBJ_FUNCTION
+	OBJ_CLOSURE
+	OBJ_THREAD
+	OBJ_CHANNEL
+	OBJ_HOST
 )
 
 var objectKinds = [...]string{
@@ -30,6 +35,11 @@ var objectKinds = [...]string{
 	OBJ_REGISTER:  "REGISTER",
 	OBJ_LABEL:     "LABEL",
 	OBJ_REFERENCE: "REFERENCE",
+	OBJ_FUNCTION:  "FUNCTION",
+	OBJ_CLOSURE:   "CLOSURE",
+	OBJ_THREAD:    "THREAD",
+	OBJ_CHANNEL:   "CHANNEL",
+	OBJ_HOST:      "HOST",
 }
 
 func (objKind ObjectKind) String() string {
@@ -80,6 +90,30 @@ func NewReferenceObject(refAddr int) *Object {
 	return &Object{kind: OBJ_REFERENCE, data: refAddr}
 }
 
+func NewFunctionObject(labelNo int) *Object {
+	return &Object{kind: OBJ_FUNCTION, data: labelNo}
+}
+
+// NewClosureObject envAddrはヒープに確保された環境の先頭アドレス
+func NewClosureObject(envAddr int) *Object {
+	return &Object{kind: OBJ_CLOSURE, data: envAddr}
+}
+
+// NewThreadObject SPAWNで作ったスレッドの番号
+func NewThreadObject(id int) *Object {
+	return &Object{kind: OBJ_THREAD, data: id}
+}
+
+// NewChannelObject MAKE_CHANNELで作ったチャネルの番号
+func NewChannelObject(id int) *Object {
+	return &Object{kind: OBJ_CHANNEL, data: id}
+}
+
+// NewHostObject ホスト関数を指すシンボルのラベル番号, 名前はラベルと同じくSymbolsで引く
+func NewHostObject(labelNo int) *Object {
+	return &Object{kind: OBJ_HOST, data: labelNo}
+}
+
 type Object struct {
 	kind ObjectKind
 	data int
@@ -109,6 +143,16 @@ func (o *Object) String() string {
 		return fmt.Sprintf("label(%d)", o.data)
 	case OBJ_REFERENCE:
 		return fmt.Sprintf("reference(%d)", o.data)
+	case OBJ_FUNCTION:
+		return fmt.Sprintf("function(%d)", o.data)
+	case OBJ_CLOSURE:
+		return fmt.Sprintf("closure(%d)", o.data)
+	case OBJ_THREAD:
+		return fmt.Sprintf("thread(%d)", o.data)
+	case OBJ_CHANNEL:
+		return fmt.Sprintf("channel(%d)", o.data)
+	case OBJ_HOST:
+		return fmt.Sprintf("host(%d)", o.data)
 
 	default:
 		log.Fatalf("unsupported object kind: %s", o.kind)
@@ -120,6 +164,10 @@ func (o *Object) GetKind() ObjectKind {
 	return o.kind
 }
 
+func (o *Object) GetData() int {
+	return o.data
+}
+
 func (o *Object) Clone() *Object {
 	newObj := Object{kind: o.kind, data: o.data}
 	return &newObj
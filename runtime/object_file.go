@@ -0,0 +1,237 @@
+package runtime
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ObjectFile 別々にコンパイルされたプログラムとラベルの名前
+// Symbolsに名前があってDEF_LABELがあるものが定義済み，DEF_LABELがないものが未定義のシンボル
+// host(n)で参照しているものは実行時にRegisterHostFuncで登録するホスト関数
+// 名前のないラベルはそのファイルの中だけで使われる
+type ObjectFile struct {
+	Program Program
+	Symbols map[string]int
+}
+
+func NewObjectFile(prog Program, symbols map[string]int) *ObjectFile {
+	return &ObjectFile{Program: prog, Symbols: symbols}
+}
+
+func (o *ObjectFile) definedLabels() map[int]bool {
+	defined := make(map[int]bool)
+	for _, op := range o.Program {
+		if op.kind == OP_DEF_LABEL && op.param1 != nil && op.param1.kind == OBJ_LABEL {
+			defined[op.param1.data] = true
+		}
+	}
+	return defined
+}
+
+// Defined このファイルで定義しているシンボルの名前
+func (o *ObjectFile) Defined() []string {
+	defined := o.definedLabels()
+	names := []string{}
+	for name, no := range o.Symbols {
+		if defined[no] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// hostLabels ホスト関数として参照しているラベル
+func (o *ObjectFile) hostLabels() map[int]bool {
+	hosts := make(map[int]bool)
+	for _, op := range o.Program {
+		for _, param := range op.params() {
+			if param != nil && param.kind == OBJ_HOST {
+				hosts[param.data] = true
+			}
+		}
+	}
+	return hosts
+}
+
+// Externs このファイルが呼んでいるホスト関数の名前
+func (o *ObjectFile) Externs() []string {
+	hosts := o.hostLabels()
+	names := []string{}
+	for name, no := range o.Symbols {
+		if hosts[no] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Undefined このファイルが参照しているが定義していないシンボルの名前, ホスト関数は含めない
+func (o *ObjectFile) Undefined() []string {
+	defined := o.definedLabels()
+	hosts := o.hostLabels()
+	names := []string{}
+	for name, no := range o.Symbols {
+		if !defined[no] && !hosts[no] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+const (
+	objectFileMagic   = "MYOB"
+	objectFileVersion = 1
+	maxSymbolNameSize = 1 << 16 // 壊れたファイルで大きな領域を確保しないための上限
+)
+
+// Encode オブジェクトファイルをバイト列にする
+//
+//	magic   "MYOB"
+//	version uvarint
+//	symbols uvarint個の (名前の長さ uvarint, 名前, ラベル varint)
+//	program uvarint個の (命令 uvarint, 引数の数 uvarint, 引数の数個の (種類 uvarint, データ varint))
+func (o *ObjectFile) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf, v)
+		_, _ = bw.Write(buf[:n])
+	}
+	putVarint := func(v int64) {
+		n := binary.PutVarint(buf, v)
+		_, _ = bw.Write(buf[:n])
+	}
+
+	_, _ = bw.WriteString(objectFileMagic)
+	putUvarint(objectFileVersion)
+
+	names := make([]string, 0, len(o.Symbols))
+	for name := range o.Symbols {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	putUvarint(uint64(len(names)))
+	for _, name := range names {
+		putUvarint(uint64(len(name)))
+		_, _ = bw.WriteString(name)
+		putVarint(int64(o.Symbols[name]))
+	}
+
+	putUvarint(uint64(len(o.Program)))
+	for _, op := range o.Program {
+		params := []*Object{}
+		for _, param := range op.params() {
+			if param == nil {
+				break
+			}
+			params = append(params, param)
+		}
+		putUvarint(uint64(op.kind))
+		putUvarint(uint64(len(params)))
+		for _, param := range params {
+			putUvarint(uint64(param.kind))
+			putVarint(int64(param.data))
+		}
+	}
+	return bw.Flush()
+}
+
+// DecodeObjectFile Encodeしたバイト列を読む
+func DecodeObjectFile(r io.Reader) (*ObjectFile, error) {
+	br := bufio.NewReader(r)
+	fail := func(err error) (*ObjectFile, error) {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to decode object file: %w", err)
+	}
+
+	magic := make([]byte, len(objectFileMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fail(err)
+	}
+	if string(magic) != objectFileMagic {
+		return fail(fmt.Errorf("reason=bad magic: %q", magic))
+	}
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fail(err)
+	}
+	if version != objectFileVersion {
+		return fail(fmt.Errorf("reason=unsupported version: %d", version))
+	}
+
+	symbolCount, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fail(err)
+	}
+	symbols := make(map[string]int)
+	for i := uint64(0); i < symbolCount; i++ {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return fail(err)
+		}
+		if maxSymbolNameSize < size {
+			return fail(fmt.Errorf("reason=symbol name too long: %d", size))
+		}
+		name := make([]byte, size)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return fail(err)
+		}
+		no, err := binary.ReadVarint(br)
+		if err != nil {
+			return fail(err)
+		}
+		symbols[string(name)] = int(no)
+	}
+
+	opCount, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fail(err)
+	}
+	// 命令の数は読めた分だけ確保する
+	program := Program{}
+	for i := uint64(0); i < opCount; i++ {
+		kind, err := binary.ReadUvarint(br)
+		if err != nil {
+			return fail(err)
+		}
+		if uint64(len(opKinds)) <= kind {
+			return fail(fmt.Errorf("reason=unknown operation: %d", kind))
+		}
+		paramCount, err := binary.ReadUvarint(br)
+		if err != nil {
+			return fail(err)
+		}
+		if 4 < paramCount {
+			return fail(fmt.Errorf("reason=too many params: %d", paramCount))
+		}
+		params := make([]*Object, 4)
+		for j := uint64(0); j < paramCount; j++ {
+			objKind, err := binary.ReadUvarint(br)
+			if err != nil {
+				return fail(err)
+			}
+			if uint64(len(objectKinds)) <= objKind {
+				return fail(fmt.Errorf("reason=unknown object: %d", objKind))
+			}
+			data, err := binary.ReadVarint(br)
+			if err != nil {
+				return fail(err)
+			}
+			if ObjectKind(objKind) == OBJ_REGISTER && (data < 0 || int64(len(regKinds)) <= data) {
+				return fail(fmt.Errorf("reason=unknown register: %d", data))
+			}
+			params[j] = &Object{kind: ObjectKind(objKind), data: int(data)}
+		}
+		program = append(program, &Operation{kind: OperationKind(kind), param1: params[0], param2: params[1], param3: params[2], param4: params[3]})
+	}
+	return NewObjectFile(program, symbols), nil
+}
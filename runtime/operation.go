@@ -1,5 +1,7 @@
 package runtime
 
+import "fmt"
+
 type OperationKind int
 
 const (
@@ -21,6 +23,23 @@ const (
 	OP_LT
 	OP_LE
 	OP_SYSCALL_WRITE
+	OP_SYSCALL_READ
+	OP_SYSCALL_OPEN
+	OP_SYSCALL_CLOSE
+	OP_ENTER
+	OP_LEAVE
+	OP_LOAD_ENV
+	OP_STORE_ENV
+	OP_MAKE_CLOSURE
+	OP_PEEK
+	OP_SPAWN
+	OP_YIELD
+	OP_JOIN
+	OP_MAKE_CHANNEL
+	OP_SEND
+	OP_RECV
+	OP_CLOSE
+	OP_CALL_HOST
 )
 
 var opKinds = [...]string{
@@ -42,6 +61,23 @@ var opKinds = [...]string{
 	OP_LT:            "LT",
 	OP_LE:            "LE",
 	OP_SYSCALL_WRITE: "SYSCALL_WRITE",
+	OP_SYSCALL_READ:  "SYSCALL_READ",
+	OP_SYSCALL_OPEN:  "SYSCALL_OPEN",
+	OP_SYSCALL_CLOSE: "SYSCALL_CLOSE",
+	OP_ENTER:         "ENTER",
+	OP_LEAVE:         "LEAVE",
+	OP_LOAD_ENV:      "LOAD_ENV",
+	OP_STORE_ENV:     "STORE_ENV",
+	OP_MAKE_CLOSURE:  "MAKE_CLOSURE",
+	OP_PEEK:          "PEEK",
+	OP_SPAWN:         "SPAWN",
+	OP_YIELD:         "YIELD",
+	OP_JOIN:          "JOIN",
+	OP_MAKE_CHANNEL:  "MAKE_CHANNEL",
+	OP_SEND:          "SEND",
+	OP_RECV:          "RECV",
+	OP_CLOSE:         "CLOSE",
+	OP_CALL_HOST:     "CALL_HOST",
 }
 
 func (opKind OperationKind) String() string {
@@ -56,6 +92,37 @@ type Operation struct {
 	param4 *Object
 }
 
+// NewOperation 種類と引数から命令を作る, 引数は4つまで
+func NewOperation(kind OperationKind, params ...*Object) (*Operation, error) {
+	if kind <= OP_ILLEGAL || len(opKinds) <= int(kind) {
+		return nil, fmt.Errorf("unsupported operation value: reason=unknown kind: kind=%d", kind)
+	}
+	if 4 < len(params) {
+		return nil, fmt.Errorf("unsupported operation value: reason=too many params: kind=%v, params=%d", kind, len(params))
+	}
+	op := &Operation{kind: kind}
+	fields := []**Object{&op.param1, &op.param2, &op.param3, &op.param4}
+	for i, param := range params {
+		*fields[i] = param
+	}
+	return op, nil
+}
+
+func (op *Operation) GetKind() OperationKind {
+	return op.kind
+}
+
+// Params 引数を前から順に返す, 省略されている引数は含めない
+func (op *Operation) Params() []*Object {
+	params := []*Object{}
+	for _, param := range op.params() {
+		if param != nil {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
 func (op *Operation) String() string {
 	str := op.kind.String()
 	if op.param1 != nil {
@@ -73,6 +140,9 @@ func (op *Operation) String() string {
 	return str
 }
 
+func NewExitOp() *Operation {
+	return &Operation{kind: OP_EXIT}
+}
 func NewReturnOp() *Operation {
 	return &Operation{kind: OP_RETURN}
 }
@@ -99,6 +169,100 @@ func NewSubOp(dest, src *Object) *Operation {
 	return &Operation{kind: OP_SUB, param1: dest, param2: src}
 }
 
+func NewEqOp(obj1, obj2 *Object) *Operation {
+	return &Operation{kind: OP_EQ, param1: obj1, param2: obj2}
+}
+func NewNeOp(obj1, obj2 *Object) *Operation {
+	return &Operation{kind: OP_NE, param1: obj1, param2: obj2}
+}
+func NewLtOp(obj1, obj2 *Object) *Operation {
+	return &Operation{kind: OP_LT, param1: obj1, param2: obj2}
+}
+func NewLeOp(obj1, obj2 *Object) *Operation {
+	return &Operation{kind: OP_LE, param1: obj1, param2: obj2}
+}
+
+func NewJumpOp(label *Object) *Operation {
+	return &Operation{kind: OP_JUMP, param1: label}
+}
+func NewJumpTrueOp(label *Object) *Operation {
+	return &Operation{kind: OP_JUMP_TRUE, param1: label}
+}
+func NewJumpFalseOp(label *Object) *Operation {
+	return &Operation{kind: OP_JUMP_FALSE, param1: label}
+}
+
 func NewCallOp(label *Object) *Operation {
 	return &Operation{kind: OP_CALL, param1: label}
 }
+
+func NewEnterOp(size *Object) *Operation {
+	return &Operation{kind: OP_ENTER, param1: size}
+}
+func NewLeaveOp() *Operation {
+	return &Operation{kind: OP_LEAVE}
+}
+
+func NewLoadEnvOp(dest, depth, slot *Object) *Operation {
+	return &Operation{kind: OP_LOAD_ENV, param1: dest, param2: depth, param3: slot}
+}
+func NewStoreEnvOp(depth, slot, src *Object) *Operation {
+	return &Operation{kind: OP_STORE_ENV, param1: depth, param2: slot, param3: src}
+}
+
+func NewMakeClosureOp(dest, label, count *Object) *Operation {
+	return &Operation{kind: OP_MAKE_CLOSURE, param1: dest, param2: label, param3: count}
+}
+
+func NewPeekOp(dest, offset *Object) *Operation {
+	return &Operation{kind: OP_PEEK, param1: dest, param2: offset}
+}
+
+// NewSpawnOp fnをargc個の引数で呼び出すスレッドを作ってdestに入れる, 引数は先頭から順にpushしておく
+func NewSpawnOp(dest, fn, argc *Object) *Operation {
+	return &Operation{kind: OP_SPAWN, param1: dest, param2: fn, param3: argc}
+}
+func NewYieldOp() *Operation {
+	return &Operation{kind: OP_YIELD}
+}
+
+// NewJoinOp threadが終わるのを待ち，その戻り値をdestに入れる
+func NewJoinOp(dest, thread *Object) *Operation {
+	return &Operation{kind: OP_JOIN, param1: dest, param2: thread}
+}
+
+// NewMakeChannelOp capacity個までためておけるチャネルを作ってdestに入れる, 0ならバッファなし
+func NewMakeChannelOp(dest, capacity *Object) *Operation {
+	return &Operation{kind: OP_MAKE_CHANNEL, param1: dest, param2: capacity}
+}
+
+// NewSendOp channelにsrcを送る, 受け取られるまで送ったスレッドは止まる(バッファに空きがあれば止まらない)
+func NewSendOp(channel, src *Object) *Operation {
+	return &Operation{kind: OP_SEND, param1: channel, param2: src}
+}
+
+// NewRecvOp channelから受け取ってdestに入れる, 閉じていて空ならnull
+func NewRecvOp(dest, channel *Object) *Operation {
+	return &Operation{kind: OP_RECV, param1: dest, param2: channel}
+}
+func NewCloseOp(channel *Object) *Operation {
+	return &Operation{kind: OP_CLOSE, param1: channel}
+}
+
+// NewCallHostOp スタックに積んだargc個の引数でホスト関数fnを呼び，戻り値をSTATUSに入れる
+func NewCallHostOp(fn, argc *Object) *Operation {
+	return &Operation{kind: OP_CALL_HOST, param1: fn, param2: argc}
+}
+
+func NewSyscallWriteOp(dest, src *Object) *Operation {
+	return &Operation{kind: OP_SYSCALL_WRITE, param1: dest, param2: src}
+}
+func NewSyscallReadOp(src, mode, dest *Object) *Operation {
+	return &Operation{kind: OP_SYSCALL_READ, param1: src, param2: mode, param3: dest}
+}
+func NewSyscallOpenOp(path, mode, dest *Object) *Operation {
+	return &Operation{kind: OP_SYSCALL_OPEN, param1: path, param2: mode, param3: dest}
+}
+func NewSyscallCloseOp(fd *Object) *Operation {
+	return &Operation{kind: OP_SYSCALL_CLOSE, param1: fd}
+}
@@ -0,0 +1,57 @@
+package runtime
+
+import (
+	"bufio"
+	"io"
+)
+
+// Option NewRuntimeに渡す設定
+type Option func(*Runtime)
+
+// WithStdin SYSCALL_READの読み込み元
+func WithStdin(in io.Reader) Option {
+	return func(r *Runtime) {
+		if br, ok := in.(*bufio.Reader); ok {
+			r.stdin = br
+			return
+		}
+		r.stdin = bufio.NewReader(in)
+	}
+}
+
+// WithStdout SYSCALL_WRITE 2の書き込み先
+func WithStdout(out io.Writer) Option {
+	return func(r *Runtime) {
+		r.stdout = out
+	}
+}
+
+// WithStderr SYSCALL_WRITE 3の書き込み先
+func WithStderr(out io.Writer) Option {
+	return func(r *Runtime) {
+		r.stderr = out
+	}
+}
+
+// WithFS SYSCALL_OPENで開けるファイルの置き場所
+// 指定しなければどのファイルも開けない
+func WithFS(fsys FileSystem) Option {
+	return func(r *Runtime) {
+		r.fsys = fsys
+	}
+}
+
+// WithFuel 1回のRunで実行できる命令数, 使い切るとErrFuelExhaustedで止まる
+// 0なら無制限
+func WithFuel(fuel int) Option {
+	return func(r *Runtime) {
+		r.fuel = fuel
+	}
+}
+
+// WithHook 各命令の前後に呼ぶHookを追加する, 登録した順に呼ばれる
+func WithHook(hook Hook) Option {
+	return func(r *Runtime) {
+		r.hooks = append(r.hooks, hook)
+	}
+}
@@ -0,0 +1,317 @@
+package runtime
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Profiler 命令ごと・関数ごとの実行回数を数えるHook
+// 関数はCALLで飛んだ先のラベルで区別し，CALLとRETURNの組で呼び出しの深さを追う
+// 呼び出しの深さはスレッドごとに追い，SPAWNした関数はSPAWNから呼んだものとして数える
+type Profiler struct {
+	names   map[int]string
+	pcs     map[int]int
+	ops     map[OperationKind]int
+	flat    map[int]int            // 関数の中で直接実行した命令数
+	cum     map[int]int            // 関数から呼んだ先も含めた命令数
+	frames  map[int][]profileFrame // スレッドの番号ごとの呼び出し履歴
+	samples map[string]*profileSample
+	owners  map[int]int // 命令を実行した関数
+	program Program
+}
+
+type profileFrame struct {
+	label  int
+	callPC int // この関数を呼んだCALLのアドレス
+}
+
+// profileSample 同じ呼び出し履歴で実行した命令数
+// pcsは実行した命令のアドレスから外側の関数を呼んだCALLのアドレスへと並ぶ
+type profileSample struct {
+	pcs   []int
+	count int
+}
+
+// NewProfiler symbolsがあれば関数名に使う, なければl_1のように表示する
+func NewProfiler(symbols map[string]int) *Profiler {
+	names := map[int]string{startupLabel: "_startup"}
+	for name, no := range symbols {
+		names[no] = name
+	}
+	return &Profiler{
+		names:   names,
+		pcs:     make(map[int]int),
+		ops:     make(map[OperationKind]int),
+		flat:    make(map[int]int),
+		cum:     make(map[int]int),
+		frames:  make(map[int][]profileFrame),
+		samples: make(map[string]*profileSample),
+		owners:  make(map[int]int),
+	}
+}
+
+func (p *Profiler) BeforeOp(r *Runtime, pc int, op *Operation) {
+	frames := p.frames[r.ThreadID()]
+	if len(frames) == 0 {
+		frames = []profileFrame{{label: startupLabel, callPC: -1}}
+		p.frames[r.ThreadID()] = frames
+	}
+	p.program = r.program
+	p.pcs[pc]++
+	p.ops[op.kind]++
+	p.flat[frames[len(frames)-1].label]++
+	p.owners[pc] = frames[len(frames)-1].label
+	// 再帰していても1回の命令は1回だけ数える
+	counted := make(map[int]bool, len(frames))
+	for _, f := range frames {
+		if !counted[f.label] {
+			counted[f.label] = true
+			p.cum[f.label]++
+		}
+	}
+
+	var key strings.Builder
+	key.WriteString(strconv.Itoa(pc))
+	for i := len(frames) - 1; 1 <= i; i-- {
+		key.WriteByte(',')
+		key.WriteString(strconv.Itoa(frames[i].callPC))
+	}
+	sample, ok := p.samples[key.String()]
+	if !ok {
+		sample = &profileSample{pcs: []int{pc}}
+		for i := len(frames) - 1; 1 <= i; i-- {
+			sample.pcs = append(sample.pcs, frames[i].callPC)
+		}
+		p.samples[key.String()] = sample
+	}
+	sample.count++
+}
+
+func (p *Profiler) AfterOp(r *Runtime, pc int, op *Operation, err error) {
+	if err != nil {
+		return
+	}
+	id := r.ThreadID()
+	switch op.kind {
+	case OP_CALL:
+		// 呼び出し先のDEF_LABELに着いている
+		label := p.labelAt(r, r.register[REG_PROGRAM_COUNTER].data)
+		p.frames[id] = append(p.frames[id], profileFrame{label: label, callPC: pc})
+	case OP_SPAWN:
+		// 新しいスレッドはstartupからSPAWNで呼ばれたものとする
+		t := r.threads[r.register[RegisterKind(op.param1.data)].data]
+		p.frames[t.id] = []profileFrame{
+			{label: startupLabel, callPC: -1},
+			{label: p.labelAt(r, t.register[REG_PROGRAM_COUNTER].data), callPC: pc},
+		}
+	case OP_RETURN:
+		if frames := p.frames[id]; 1 < len(frames) {
+			p.frames[id] = frames[:len(frames)-1]
+		}
+	case OP_EXIT:
+		if id != 0 {
+			delete(p.frames, id)
+		}
+	}
+}
+
+// labelAt pcが関数の先頭のDEF_LABELならそのラベル, そうでなければstartup
+func (p *Profiler) labelAt(r *Runtime, pc int) int {
+	if 0 <= pc && pc < len(r.program) && r.program[pc].kind == OP_DEF_LABEL {
+		return r.program[pc].param1.data
+	}
+	return startupLabel
+}
+
+func (p *Profiler) name(label int) string {
+	if name, ok := p.names[label]; ok {
+		return name
+	}
+	return "l_" + strconv.Itoa(label)
+}
+
+func (p *Profiler) total() int {
+	total := 0
+	for _, count := range p.ops {
+		total += count
+	}
+	return total
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "0.00%"
+	}
+	return fmt.Sprintf("%.2f%%", float64(n)*100/float64(total))
+}
+
+// WriteText 関数・命令の種類・アドレスごとの実行回数を表にする
+func (p *Profiler) WriteText(w io.Writer) error {
+	total := p.total()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	labels := []int{}
+	for label := range p.cum {
+		labels = append(labels, label)
+	}
+	slices.SortFunc(labels, func(a, b int) int {
+		if p.flat[a] != p.flat[b] {
+			return p.flat[b] - p.flat[a]
+		}
+		return a - b
+	})
+	fmt.Fprintf(tw, "flat\tflat%%\tcum\tcum%%\t\tfunction\n")
+	for _, label := range labels {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t\t%s\n", p.flat[label], percent(p.flat[label], total), p.cum[label], percent(p.cum[label], total), p.name(label))
+	}
+	fmt.Fprintln(tw)
+
+	kinds := []OperationKind{}
+	for kind := range p.ops {
+		kinds = append(kinds, kind)
+	}
+	slices.SortFunc(kinds, func(a, b OperationKind) int {
+		if p.ops[a] != p.ops[b] {
+			return p.ops[b] - p.ops[a]
+		}
+		return int(a - b)
+	})
+	fmt.Fprintf(tw, "count\t%%\t\toperation\n")
+	for _, kind := range kinds {
+		fmt.Fprintf(tw, "%d\t%s\t\t%s\n", p.ops[kind], percent(p.ops[kind], total), kind.String())
+	}
+	fmt.Fprintln(tw)
+
+	pcs := []int{}
+	for pc := range p.pcs {
+		pcs = append(pcs, pc)
+	}
+	slices.Sort(pcs)
+	fmt.Fprintf(tw, "pc\tcount\t\tinstruction\n")
+	for _, pc := range pcs {
+		fmt.Fprintf(tw, "%d\t%d\t\t%s\n", pc, p.pcs[pc], p.program[pc].String())
+	}
+	return tw.Flush()
+}
+
+// WritePprof go tool pprofで読めるgzip圧縮したprotobufを書く
+// 命令のアドレスごとにlocationを作り，実行した命令数をサンプルの値にする
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+func (p *Profiler) WritePprof(w io.Writer) error {
+	strs := []string{""}
+	strIndex := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		if i, ok := strIndex[s]; ok {
+			return uint64(i)
+		}
+		strIndex[s] = len(strs)
+		strs = append(strs, s)
+		return uint64(len(strs) - 1)
+	}
+
+	prof := &protoBuffer{}
+	// sample_type, period_type
+	valueType := (&protoBuffer{}).uint64(1, str("instructions")).uint64(2, str("count"))
+	prof.message(1, valueType)
+
+	keys := []string{}
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	locations := map[int]bool{}
+	for _, key := range keys {
+		sample := p.samples[key]
+		ids := []uint64{}
+		for _, pc := range sample.pcs {
+			ids = append(ids, uint64(pc+1)) // idは0にできない
+			locations[pc] = true
+		}
+		prof.message(2, (&protoBuffer{}).packedUint64(1, ids).packedUint64(2, []uint64{uint64(sample.count)}))
+	}
+
+	pcs := []int{}
+	for pc := range locations {
+		pcs = append(pcs, pc)
+	}
+	slices.Sort(pcs)
+	functions := map[int]bool{}
+	for _, pc := range pcs {
+		label := p.owners[pc]
+		functions[label] = true
+		line := (&protoBuffer{}).uint64(1, functionId(label))
+		prof.message(4, (&protoBuffer{}).uint64(1, uint64(pc+1)).uint64(3, uint64(pc)).message(4, line))
+	}
+	labels := []int{}
+	for label := range functions {
+		labels = append(labels, label)
+	}
+	slices.Sort(labels)
+	for _, label := range labels {
+		name := str(p.name(label))
+		prof.message(5, (&protoBuffer{}).uint64(1, functionId(label)).uint64(2, name).uint64(3, name))
+	}
+	prof.message(11, valueType)
+	prof.uint64(12, 1)
+	for _, s := range strs {
+		prof.string(6, s)
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(prof.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// functionId pprofのidは0にできないので，startupの-1が1になるようにずらす
+func functionId(label int) uint64 {
+	return uint64(label - startupLabel + 1)
+}
+
+// protoBuffer protobufのメッセージを組み立てる
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.buf = append(b.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	b.buf = append(b.buf, byte(v))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) *protoBuffer {
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(v)
+	return b
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) *protoBuffer {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.buf = append(b.buf, data...)
+	return b
+}
+
+func (b *protoBuffer) string(field int, s string) *protoBuffer {
+	return b.bytes(field, []byte(s))
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) *protoBuffer {
+	return b.bytes(field, m.buf)
+}
+
+func (b *protoBuffer) packedUint64(field int, vs []uint64) *protoBuffer {
+	packed := &protoBuffer{}
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	return b.bytes(field, packed.buf)
+}
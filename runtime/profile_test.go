@@ -0,0 +1,127 @@
+package runtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func runProfiler(t *testing.T) *Profiler {
+	obj, err := Assemble(`
+main:
+  MOVE register(GENERAL_1) 0
+loop:
+  CALL work
+  ADD register(GENERAL_1) 1
+  LT register(GENERAL_1) 3
+  JUMP_TRUE loop
+  RETURN
+work:
+  CALL inner
+  CALL inner
+  RETURN
+inner:
+  RETURN
+`)
+	assert.Nil(t, err)
+	profiler := NewProfiler(obj.Symbols)
+	runtime := NewRuntime(4, 1, WithHook(profiler))
+	_ = runtime.Load(obj.Program)
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	return profiler
+}
+
+func labelOf(p *Profiler, name string) int {
+	for label, n := range p.names {
+		if n == name {
+			return label
+		}
+	}
+	return 0
+}
+
+func TestProfiler(t *testing.T) {
+	p := runProfiler(t)
+	// inner: (DEF_LABEL, RETURN) * 6
+	// work: (DEF_LABEL, CALL, CALL, RETURN) * 3
+	// main: DEF_LABEL, MOVE, RETURN + (DEF_LABEL, CALL, ADD, LT, JUMP_TRUE) * 3
+	// loopはCALLで飛ぶ先ではないのでmainに含まれる
+	work, inner := labelOf(p, "work"), labelOf(p, "inner")
+	assert.Equal(t, map[int]int{startupLabel: 3, 0: 18, work: 12, inner: 12}, p.flat)
+	assert.Equal(t, map[int]int{startupLabel: 45, 0: 42, work: 24, inner: 12}, p.cum)
+	assert.Equal(t, 10, p.ops[OP_CALL])
+	assert.Equal(t, 10, p.ops[OP_RETURN])
+	assert.Equal(t, 6, p.pcs[16]) // innerのRETURN
+	assert.Equal(t, 45, p.total())
+}
+
+func TestProfiler_Recursion(t *testing.T) {
+	// 再帰しても含む命令数は全体を超えない
+	obj, err := Assemble(`
+main:
+  MOVE register(GENERAL_1) 3
+  CALL f
+  RETURN
+f:
+  SUB register(GENERAL_1) 1
+  EQ register(GENERAL_1) 0
+  JUMP_TRUE end
+  CALL f
+end:
+  RETURN
+`)
+	assert.Nil(t, err)
+	p := NewProfiler(obj.Symbols)
+	runtime := NewRuntime(8, 1, WithHook(p))
+	_ = runtime.Load(obj.Program)
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, p.cum[obj.Symbols["f"]], p.flat[obj.Symbols["f"]])
+	assert.Equal(t, p.total(), p.cum[startupLabel])
+}
+
+func TestProfiler_WriteText(t *testing.T) {
+	p := runProfiler(t)
+	var buf bytes.Buffer
+	assert.Nil(t, p.WriteText(&buf))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "  flat   flat%  cum     cum%  function", lines[0])
+	assert.Equal(t, "    18  40.00%   42   93.33%  main", lines[1])
+	assert.Contains(t, buf.String(), "     10  22.22%  CALL\n")
+	assert.Contains(t, buf.String(), "  16      6  RETURN\n")
+}
+
+func TestProfiler_WritePprof(t *testing.T) {
+	p := runProfiler(t)
+	var buf bytes.Buffer
+	assert.Nil(t, p.WritePprof(&buf))
+	gz, err := gzip.NewReader(&buf)
+	assert.Nil(t, err)
+	data, err := io.ReadAll(gz)
+	assert.Nil(t, err)
+	// string_tableに関数名と値の種類が入っている
+	for _, s := range []string{"instructions", "count", "main", "work", "inner", "_startup"} {
+		assert.True(t, bytes.Contains(data, append([]byte{6<<3 | 2, byte(len(s))}, s...)), s)
+	}
+}
+
+func TestProfiler_Thread(t *testing.T) {
+	// スレッドが交互に動いても，呼び出し履歴はスレッドごとに追う
+	p := NewProfiler(nil)
+	runtime := NewRuntime(10, 1, WithHook(p))
+	assert.Nil(t, runtime.Reload(newWorkerProgram()))
+	assert.Nil(t, runtime.Run())
+	// worker: (DEF_LABEL, POP, POP, PUSH, (WRITE, YIELD) * 2, WRITE, MOVE, RETURN) * 2
+	assert.Equal(t, 22, p.flat[1])
+	assert.Equal(t, 22, p.cum[1])
+	assert.Equal(t, 2, p.ops[OP_SPAWN])
+	// 終わったスレッドの履歴は残さない
+	assert.Equal(t, []int{0}, slices.Collect(maps.Keys(p.frames)))
+	assert.Equal(t, []profileFrame{{label: startupLabel, callPC: -1}}, p.frames[0])
+}
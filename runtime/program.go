@@ -2,11 +2,12 @@ package runtime
 
 type Program []*Operation
 
+// Export プログラムをAssembleで読めるテキストにする, 文字はシングルクォートで囲む
 func Export(prog Program) string {
 	str := ""
 
 	for i, op := range prog {
-		str += op.String()
+		str += asmOperationString(op, nil)
 		if i != len(prog)-1 { // 最後の行でなかったら
 			str += "\n"
 		}
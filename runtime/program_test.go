@@ -16,3 +16,42 @@ func TestExport(t *testing.T) {
 	}
 	assert.Equal(t, "MOVE register(GENERAL_1) 30\nEXIT", Export(program))
 }
+
+func TestExport_RoundTrip(t *testing.T) {
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	objs := []*Object{
+		{kind: OBJ_INVALID},
+		NewNullObject(),
+		NewObject(-30),
+		NewObject('f'),
+		NewObject(' '),
+		NewObject('\''),
+		NewObject('\n'),
+		NewObject('あ'),
+		NewObject(true),
+		NewObject(false),
+		NewListObject(3),
+		g1,
+		NewLabelObject(-1),
+		NewReferenceObject(4),
+		NewFunctionObject(5),
+		NewClosureObject(6),
+		NewThreadObject(7),
+		NewChannelObject(8),
+		NewHostObject(9),
+	}
+	kinds := make(map[ObjectKind]bool)
+	program := Program{}
+	for _, obj := range objs {
+		kinds[obj.kind] = true
+		program = append(program, NewMoveOp(g1, obj))
+	}
+	// 全ての種類を試す
+	assert.Equal(t, len(objectKinds), len(kinds))
+
+	obj, err := Assemble(Export(program))
+	assert.Nil(t, err)
+	assert.Equal(t, program, obj.Program)
+	assert.Empty(t, obj.Symbols)
+	assert.Equal(t, "MOVE register(GENERAL_1) 'f'", Export(program[3:4]))
+}
@@ -10,6 +10,8 @@ const (
 	REG_GENERAL_1
 	REG_GENERAL_2
 	REG_TEMP_1
+	REG_ENV
+	REG_STACK_POINTER // 次にpushする位置, スタックと共有している
 )
 
 var regKinds = [...]string{
@@ -20,6 +22,8 @@ var regKinds = [...]string{
 	REG_GENERAL_1:       "GENERAL_1",
 	REG_GENERAL_2:       "GENERAL_2",
 	REG_TEMP_1:          "TEMP_1",
+	REG_ENV:             "ENV",
+	REG_STACK_POINTER:   "STACK_POINTER",
 }
 
 func (regKind RegisterKind) String() string {
@@ -1,66 +1,104 @@
 package runtime
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 )
 
+// Runtime 1つのRuntimeは1つのgoroutineから使う
+// Programは実行中に書き換えないので，別々のRuntimeで同じProgramを同時に動かせる
 type Runtime struct {
 	stack       *Stack
 	memory      *Memory
 	program     Program
+	code        []instruction // Loadで前処理したprogram
 	register    Register
 	symbolTable *SymbolTable
+	stdin       *bufio.Reader
+	stdout      io.Writer
+	stderr      io.Writer
+	fsys        FileSystem
+	files       map[int]*openFile // ファイルディスクリプタ表
+	fuel        int               // 1回のRunで実行できる命令数, 0なら無制限
+	executed    int               // 実行した命令数
+	hooks       []Hook
+	threads     []*thread // 添字がスレッドの番号, stackとregisterは動かしているスレッドのもの
+	current     int
+	switching   bool       // 命令の後で別のスレッドに切り替える
+	channels    []*channel // 添字がチャネルの番号
+	hostFuncs   map[string]HostFunc
 }
 
-func NewRuntime(stackSize int, memorySize int) *Runtime {
-	return &Runtime{
+// NewRuntime 入出力はオプションで指定しなければOSの標準入出力になる
+func NewRuntime(stackSize int, memorySize int, opts ...Option) *Runtime {
+	r := &Runtime{
 		stack:       NewStack(stackSize),
 		memory:      NewMemory(memorySize),
 		program:     nil,
 		register:    NewRegister(),
 		symbolTable: NewSymbolTable(),
+		hostFuncs:   make(map[string]HostFunc),
+		stdin:       bufio.NewReader(os.Stdin),
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.register[REG_STACK_POINTER] = r.stack.sp
+	r.initFiles()
+	return r
 }
 
 func (r *Runtime) setProgram(prog Program) {
 	r.program = prog
 }
 func (r *Runtime) setPC(newPC int) {
+	if pc := r.register[REG_PROGRAM_COUNTER]; pc != nil && pc.kind == OBJ_INT {
+		pc.data = newPC
+		return
+	}
 	r.register[REG_PROGRAM_COUNTER] = NewObject(newPC)
 }
 func (r *Runtime) setStatus(stat Status) {
 	r.register[REG_STATUS] = NewObject(int(stat))
 }
 
-func (r *Runtime) consumeOp() *Operation {
-	curt := r.program[r.register[REG_PROGRAM_COUNTER].data]
-	r.advance()
-	return curt
-}
 func (r *Runtime) advance() {
 	r.register[REG_PROGRAM_COUNTER].data++
 }
 
 func (r *Runtime) doMove(dest, src *Object) error {
+	if src.kind == OBJ_REGISTER && r.register[RegisterKind(src.data)] == nil {
+		return fmt.Errorf("failed to move value: reason=src register is empty: %v", src)
+	}
+	if src.kind == OBJ_REFERENCE && !r.memory.InRange(src.data) {
+		return fmt.Errorf("failed to move value: reason=src address is out of memory: %v, size=%d", src, len(*r.memory))
+	}
 	switch dest.kind {
 	case OBJ_REGISTER: // 代入先がレジスタ
 		switch src.kind {
 		case OBJ_REGISTER: // ソースがレジスタ
-			r.register[RegisterKind(dest.data)] = r.register[RegisterKind(src.data)].Clone()
-			return nil
+			return r.setRegister(RegisterKind(dest.data), r.register[RegisterKind(src.data)].Clone())
 		case OBJ_REFERENCE: // ソースがメモリ
 			if yes := r.memory.IsEmptyAt(src.data); yes { // ソースメモリが空
 				return fmt.Errorf("failed to move value: reason=src memory is empty: %v", src)
 			}
-			r.register[RegisterKind(dest.data)] = r.memory.GetAt(src.data).Clone()
-			return nil
+			return r.setRegister(RegisterKind(dest.data), r.memory.GetAt(src.data).Clone())
 		default:
-			r.register[RegisterKind(dest.data)] = src.Clone()
-			return nil
+			return r.setRegister(RegisterKind(dest.data), src.Clone())
 		}
 	case OBJ_REFERENCE: // 代入先がメモリ
+		if !r.memory.InRange(dest.data) {
+			return fmt.Errorf("failed to move value: reason=dest address is out of memory: %v, size=%d", dest, len(*r.memory))
+		}
 		if yes := r.memory.IsEmptyAt(dest.data); !yes { // 宛先メモリにデータが入っている
 			return fmt.Errorf("failed to move value: reason=dest memory is not empty: %v", dest)
 		}
@@ -87,34 +125,27 @@ func (r *Runtime) doMove(dest, src *Object) error {
 	default:
 		return fmt.Errorf("unsupported move value: reason=dest is nor REGISTER, REFERENCE: dest=%v", dest)
 	}
-	switch src.kind {
-	case OBJ_REGISTER:
-		r.register[RegisterKind(dest.data)] = r.register[RegisterKind(src.data)].Clone()
-		return nil
-	case OBJ_REFERENCE:
-		if !r.memory.IsEmptyAt(dest.data) {
-			return fmt.Errorf("failed to move value: reason=dest memory is not empty: %v", dest)
+}
+
+// setRegister STACK_POINTERはスタックと共有しているので，置き換えずに値だけを書き換える
+func (r *Runtime) setRegister(kind RegisterKind, obj *Object) error {
+	if kind == REG_STACK_POINTER {
+		if obj == nil || obj.kind != OBJ_INT {
+			return fmt.Errorf("unsupported stack pointer value: reason=value is not INT: value=%v", obj)
 		}
-		err := r.memory.SetAt(dest.data, src.Clone())
-		return err
-	default:
-		r.register[RegisterKind(dest.data)] = src.Clone()
+		return r.stack.SetPointer(obj.data)
 	}
+	r.register[kind] = obj
 	return nil
 }
 
 func (r *Runtime) doPush(obj1 *Object) error {
 	switch {
 	case obj1.kind == OBJ_REGISTER:
-		if err := r.stack.Push(r.register[RegisterKind(obj1.data)].Clone()); err != nil {
-			return err
-		}
+		return r.stack.Push(r.register[RegisterKind(obj1.data)])
 	default:
-		if err := r.stack.Push(obj1.Clone()); err != nil {
-			return err
-		}
+		return r.stack.Push(obj1)
 	}
-	return nil
 }
 
 func (r *Runtime) doPop(dest *Object) error {
@@ -125,24 +156,64 @@ func (r *Runtime) doPop(dest *Object) error {
 	if err != nil {
 		return err
 	}
-	r.register[RegisterKind(dest.data)] = pop.Clone()
-	return nil
+	return r.setRegister(RegisterKind(dest.data), pop.Clone())
 }
 
-func (r *Runtime) doCall(dest *Object) error {
-	if dest.kind != OBJ_LABEL {
-		return fmt.Errorf("unsupported call value: reason=dest is not LABEL: dest=%v", dest)
+// doPeek スタックの上からoffset番目の値を取り除かずにレジスタへ読む, offsetが0なら一番上
+func (r *Runtime) doPeek(dest, offset *Object) error {
+	if dest.kind != OBJ_REGISTER {
+		return fmt.Errorf("unsupported peek value: reason=dest is not REGISTER: dest=%v", dest)
 	}
-	if err := r.stack.Push(NewReferenceObject(r.register[REG_PROGRAM_COUNTER].data)); err != nil {
+	if offset.kind == OBJ_REGISTER {
+		offset = r.register[RegisterKind(offset.data)]
+	}
+	if offset == nil || offset.kind != OBJ_INT {
+		return fmt.Errorf("unsupported peek value: reason=offset is not INT: offset=%v", offset)
+	}
+	peek, err := r.stack.Peek(offset.data)
+	if err != nil {
 		return err
 	}
+	return r.setRegister(RegisterKind(dest.data), peek.Clone())
+}
+
+func (r *Runtime) doCall(dest *Object) error {
+	if dest.kind == OBJ_REGISTER { // レジスタ経由の間接呼び出し
+		dest = r.register[RegisterKind(dest.data)]
+		if dest == nil {
+			return fmt.Errorf("unsupported call value: reason=register is empty")
+		}
+	}
+	labelNo := 0
+	switch dest.kind {
+	case OBJ_LABEL, OBJ_FUNCTION:
+		labelNo = dest.data
+	case OBJ_CLOSURE:
+		fn, err := r.closureFunction(dest)
+		if err != nil {
+			return err
+		}
+		labelNo = fn.data
+		// 呼び出し先からキャプチャした変数が見えるように環境を切り替える
+		r.register[REG_ENV] = NewReferenceObject(dest.data)
+	default:
+		return fmt.Errorf("unsupported call value: reason=dest is nor LABEL, FUNCTION, CLOSURE: dest=%v", dest)
+	}
 	// ラベル経由で宛先の取り出し
-	destAddress, err := r.symbolTable.Get("l_" + strconv.Itoa(dest.data))
+	destAddress, err := r.symbolTable.Get(labelNo)
 	if err != nil {
 		return err
 	}
+	return r.callAt(destAddress)
+}
+
+// callAt 戻り先を積んでdestに飛ぶ
+func (r *Runtime) callAt(dest int) error {
+	if err := r.stack.Push(NewReferenceObject(r.register[REG_PROGRAM_COUNTER].data)); err != nil {
+		return err
+	}
 	// PCの書き換え
-	r.setPC(destAddress)
+	r.setPC(dest)
 	return nil
 }
 func (r *Runtime) doReturn() error {
@@ -158,16 +229,30 @@ func (r *Runtime) doReturn() error {
 	return nil
 }
 
+// moveStackPointer ADDやSUBでSTACK_POINTERを動かす
+// SetPointerを通すので，スタックの外には動かせず，積まれていない位置はnullになる
+func (r *Runtime) moveStackPointer(delta int) error {
+	return r.setRegister(REG_STACK_POINTER, NewObject(r.stack.Depth()+delta))
+}
+
 func (r *Runtime) doAdd(dest, src *Object) error {
 	if dest.kind != OBJ_REGISTER {
 		return fmt.Errorf("unsupported add value: reason=dest is not REGISTER: dest=%v", dest)
 	}
-	switch src.kind {
-	case OBJ_REGISTER:
-		r.register[RegisterKind(dest.data)].data += r.register[RegisterKind(src.data)].data
-	default:
-		r.register[RegisterKind(dest.data)].data += src.data
+	value := src.data
+	if src.kind == OBJ_REGISTER {
+		if r.register[RegisterKind(src.data)] == nil {
+			return fmt.Errorf("failed to add: reason=src register is empty: %v", src)
+		}
+		value = r.register[RegisterKind(src.data)].data
+	}
+	if RegisterKind(dest.data) == REG_STACK_POINTER {
+		return r.moveStackPointer(value)
 	}
+	if r.register[RegisterKind(dest.data)] == nil {
+		return fmt.Errorf("failed to add: reason=dest register is empty: %v", dest)
+	}
+	r.register[RegisterKind(dest.data)].data += value
 	return nil
 }
 
@@ -175,12 +260,20 @@ func (r *Runtime) doSub(dest, src *Object) error {
 	if dest.kind != OBJ_REGISTER {
 		return fmt.Errorf("unsupported sub value: reason=dest is not REGISTER: dest=%v", dest)
 	}
-	switch src.kind {
-	case OBJ_REGISTER:
-		r.register[RegisterKind(dest.data)].data -= r.register[RegisterKind(src.data)].data
-	default:
-		r.register[RegisterKind(dest.data)].data -= src.data
+	value := src.data
+	if src.kind == OBJ_REGISTER {
+		if r.register[RegisterKind(src.data)] == nil {
+			return fmt.Errorf("failed to sub: reason=src register is empty: %v", src)
+		}
+		value = r.register[RegisterKind(src.data)].data
+	}
+	if RegisterKind(dest.data) == REG_STACK_POINTER {
+		return r.moveStackPointer(-value)
+	}
+	if r.register[RegisterKind(dest.data)] == nil {
+		return fmt.Errorf("failed to sub: reason=dest register is empty: %v", dest)
 	}
+	r.register[RegisterKind(dest.data)].data -= value
 	return nil
 }
 
@@ -188,7 +281,7 @@ func (r *Runtime) doJump(dest *Object) error {
 	if dest.kind != OBJ_LABEL {
 		return fmt.Errorf("unsupported jump value: reason=dest is not label: dest=%v", dest)
 	}
-	destAddress, err := r.symbolTable.Get("l_" + strconv.Itoa(dest.data))
+	destAddress, err := r.symbolTable.Get(dest.data)
 	if err != nil {
 		return err
 	}
@@ -203,7 +296,7 @@ func (r *Runtime) doJumpTrue(dest *Object) error {
 	if r.register[REG_BOOL_FLAG].IsSame(NewObject(false)) {
 		return nil
 	} else if r.register[REG_BOOL_FLAG].IsSame(NewObject(true)) {
-		destAddress, err := r.symbolTable.Get("l_" + strconv.Itoa(dest.data))
+		destAddress, err := r.symbolTable.Get(dest.data)
 		if err != nil {
 			return err
 		}
@@ -222,7 +315,7 @@ func (r *Runtime) doJumpFalse(dest *Object) error {
 	if r.register[REG_BOOL_FLAG].IsSame(NewObject(true)) {
 		return nil
 	} else if r.register[REG_BOOL_FLAG].IsSame(NewObject(false)) {
-		destAddress, err := r.symbolTable.Get("l_" + strconv.Itoa(dest.data))
+		destAddress, err := r.symbolTable.Get(dest.data)
 		if err != nil {
 			return err
 		}
@@ -233,152 +326,367 @@ func (r *Runtime) doJumpFalse(dest *Object) error {
 	return nil
 }
 
-func (r *Runtime) doEq(obj1, obj2 *Object) error {
-	switch {
-	case obj1.kind == OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data == r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
-		}
-	case obj1.kind == OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data == obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+func (r *Runtime) setBoolFlag(b bool) {
+	r.register[REG_BOOL_FLAG] = NewObject(b)
+}
+
+// stringAt addrにlist(n)があれば，続くn個を文字列として読む
+func (r *Runtime) stringAt(addr int) (string, bool) {
+	if addr < 0 || len(*r.memory) <= addr || r.memory.IsEmptyAt(addr) || r.memory.GetAt(addr).kind != OBJ_LIST {
+		return "", false
+	}
+	var sb strings.Builder
+	for i := 1; i <= r.memory.GetAt(addr).data && addr+i < len(*r.memory); i++ {
+		if obj := r.memory.GetAt(addr + i); obj != nil {
+			sb.WriteString(obj.StringData())
 		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if obj1.data == r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+	}
+	return sb.String(), true
+}
+
+func (r *Runtime) doSyscallWrite(dest, src *Object) error {
+	_, file, ok := r.fileAt(dest)
+	if !ok || file.writer == nil {
+		return fmt.Errorf("unsupported syscall_write value: reason=dest is not writable fd: dest=%v", dest)
+	}
+	f := file.writer
+	if src.kind == OBJ_REGISTER {
+		src = r.register[RegisterKind(src.data)]
+		if src == nil {
+			return fmt.Errorf("unsupported syscall_write value: reason=src register is empty")
 		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if obj1.data == obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+	}
+	// メモリ上の文字列
+	if src.kind == OBJ_REFERENCE {
+		if str, ok := r.stringAt(src.data); ok {
+			_, err := fmt.Fprint(f, str)
+			return err
 		}
 	}
-	r.register[REG_BOOL_FLAG] = NewObject(false)
-	return nil
+	_, err := fmt.Fprint(f, src.StringData())
+	return err
 }
 
-func (r *Runtime) doNe(obj1, obj2 *Object) error {
-	switch {
-	case obj1.kind == OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data != r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+// readLine 改行までを読む, 何も読めずにEOFになったらio.EOFを返す
+func readLine(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, nil
+}
+
+// storeString 文字列をlist(n)に続けてn文字置く
+// destがレジスタならヒープに確保してその参照を入れ，メモリならその場所から書く
+func (r *Runtime) storeString(dest *Object, str string) error {
+	runes := []rune(str)
+	addr := 0
+	switch dest.kind {
+	case OBJ_REGISTER:
+		allocated, err := r.memory.Alloc(len(runes))
+		if err != nil {
+			return err
 		}
-	case obj1.kind == OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data != obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+		addr = allocated
+		if err := r.setRegister(RegisterKind(dest.data), NewReferenceObject(addr)); err != nil {
+			return err
 		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if obj1.data != r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+	case OBJ_REFERENCE:
+		addr = dest.data
+		if err := r.memory.SetAt(addr, NewListObject(len(runes))); err != nil {
+			return err
 		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if obj1.data != obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+	}
+	for i, c := range runes {
+		if err := r.memory.SetAt(addr+1+i, NewObject(c)); err != nil {
+			return err
 		}
 	}
-	r.register[REG_BOOL_FLAG] = NewObject(false)
 	return nil
 }
 
-func (r *Runtime) doLt(obj1, obj2 *Object) error {
+func (r *Runtime) storeValue(dest *Object, obj *Object) error {
+	switch dest.kind {
+	case OBJ_REGISTER:
+		return r.setRegister(RegisterKind(dest.data), obj)
+	default:
+		return r.memory.SetAt(dest.data, obj)
+	}
+}
+
+// doSyscallRead 読めたらBOOL_FLAGをtrueにする
+// EOFならBOOL_FLAGをfalseにしてdestにはnullを入れる
+func (r *Runtime) doSyscallRead(src, mode, dest *Object) error {
+	_, file, ok := r.fileAt(src)
+	if !ok || file.reader == nil {
+		return fmt.Errorf("unsupported syscall_read value: reason=src is not readable fd: src=%v", src)
+	}
+	if dest.kind != OBJ_REGISTER && dest.kind != OBJ_REFERENCE {
+		return fmt.Errorf("unsupported syscall_read value: reason=dest is nor REGISTER, REFERENCE: dest=%v", dest)
+	}
+	in := file.reader
+
+	var err error
 	switch {
-	case obj1.kind == OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data < r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+	case mode.IsSame(NewObject(READ_CHAR)):
+		var c rune
+		if c, _, err = in.ReadRune(); err == nil {
+			err = r.storeValue(dest, NewObject(c))
 		}
-	case obj1.kind == OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data < obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+	case mode.IsSame(NewObject(READ_LINE)):
+		var line string
+		if line, err = readLine(in); err == nil {
+			err = r.storeString(dest, line)
 		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if obj1.data < r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
-		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if obj1.data < obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+	case mode.IsSame(NewObject(READ_INT)):
+		var line string
+		if line, err = readLine(in); err == nil {
+			i, convErr := strconv.Atoi(strings.TrimSpace(line))
+			if convErr != nil {
+				return fmt.Errorf("failed to syscall_read: reason=not int: %q", line)
+			}
+			err = r.storeValue(dest, NewObject(i))
 		}
+	default:
+		return fmt.Errorf("unsupported syscall_read value: reason=unknown mode: mode=%v", mode)
 	}
-	r.register[REG_BOOL_FLAG] = NewObject(false)
+
+	if errors.Is(err, io.EOF) {
+		r.register[REG_BOOL_FLAG] = NewObject(false)
+		return r.storeValue(dest, NewNullObject())
+	}
+	if err != nil {
+		return err
+	}
+	r.register[REG_BOOL_FLAG] = NewObject(true)
 	return nil
 }
 
-func (r *Runtime) doLe(obj1, obj2 *Object) error {
-	switch {
-	case obj1.kind == OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data <= r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
-		}
-	case obj1.kind == OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if r.register[RegisterKind(obj1.data)].data <= obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
-		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind == OBJ_REGISTER:
-		if obj1.data <= r.register[RegisterKind(obj2.data)].data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+// 環境(ENTERで作るフレームとMAKE_CLOSUREで作るクロージャ)はAllocで確保した領域に次のように置く
+//
+//	addr+0: list(n+1) (Allocのヘッダ)
+//	addr+1: フレームなら親の環境への参照, クロージャなら関数
+//	addr+2: スロット0
+//	...
+const envSlotOffset = 2
+
+func (r *Runtime) closureFunction(closure *Object) (*Object, error) {
+	fn := r.memory.GetAt(closure.data + 1)
+	if fn == nil || fn.kind != OBJ_FUNCTION {
+		return nil, fmt.Errorf("unsupported closure value: reason=closure has no function: closure=%v", closure)
+	}
+	return fn, nil
+}
+
+// envAt REG_ENVからdepth回親をたどった環境のアドレスを返す
+func (r *Runtime) envAt(depth int) (int, error) {
+	env := r.register[REG_ENV]
+	for i := 0; ; i++ {
+		if env == nil || env.kind != OBJ_REFERENCE {
+			return 0, fmt.Errorf("failed to resolve env: reason=env is not REFERENCE: depth=%d, env=%v", i, env)
 		}
-	case obj1.kind != OBJ_REGISTER && obj2.kind != OBJ_REGISTER:
-		if obj1.data <= obj2.data {
-			r.register[REG_BOOL_FLAG] = NewObject(true)
-			return nil
+		if i == depth {
+			return env.data, nil
 		}
+		env = r.memory.GetAt(env.data + 1)
 	}
-	r.register[REG_BOOL_FLAG] = NewObject(false)
+}
+
+func (r *Runtime) envSlotAddr(depth, slot *Object) (int, error) {
+	env, err := r.envAt(depth.data)
+	if err != nil {
+		return 0, err
+	}
+	header := r.memory.GetAt(env)
+	if header == nil || header.kind != OBJ_LIST {
+		return 0, fmt.Errorf("failed to resolve env: reason=env is not allocated: env=%d", env)
+	}
+	size := header.data - 1
+	if slot.data < 0 || size <= slot.data {
+		return 0, fmt.Errorf("failed to resolve env: reason=slot out of range: slot=%d, size=%d", slot.data, size)
+	}
+	return env + envSlotOffset + slot.data, nil
+}
+
+func (r *Runtime) doEnter(size *Object) error {
+	if size.kind != OBJ_INT {
+		return fmt.Errorf("unsupported enter value: reason=size is not INT: size=%v", size)
+	}
+	addr, err := r.memory.Alloc(size.data + 1)
+	if err != nil {
+		return err
+	}
+	if err := r.memory.SetAt(addr+1, r.register[REG_ENV].Clone()); err != nil {
+		return err
+	}
+	r.register[REG_ENV] = NewReferenceObject(addr)
 	return nil
 }
 
-func (r *Runtime) doSyscallWrite(dest, src *Object) error {
-	var f *os.File
-	switch {
-	case dest.IsSame(NewObject(STD_OUT)):
-		f = os.Stdout
-	case dest.IsSame(NewObject(STD_ERR)):
-		f = os.Stderr
-	default:
-		return fmt.Errorf("unsupported syscall_write value: reason=dest is nor 2 & 3: dest=%v", dest)
+func (r *Runtime) doLeave() error {
+	env, err := r.envAt(0)
+	if err != nil {
+		return err
+	}
+	parent := r.memory.GetAt(env + 1).Clone()
+	if err := r.memory.Free(env); err != nil {
+		return err
+	}
+	r.register[REG_ENV] = parent
+	return nil
+}
+
+func (r *Runtime) doLoadEnv(dest, depth, slot *Object) error {
+	if dest.kind != OBJ_REGISTER {
+		return fmt.Errorf("unsupported load_env value: reason=dest is not REGISTER: dest=%v", dest)
+	}
+	addr, err := r.envSlotAddr(depth, slot)
+	if err != nil {
+		return err
+	}
+	return r.setRegister(RegisterKind(dest.data), r.memory.GetAt(addr).Clone())
+}
+
+func (r *Runtime) doStoreEnv(depth, slot, src *Object) error {
+	addr, err := r.envSlotAddr(depth, slot)
+	if err != nil {
+		return err
 	}
 	if src.kind == OBJ_REGISTER {
-		_, err := fmt.Fprintf(f, r.register[RegisterKind(src.data)].StringData())
+		src = r.register[RegisterKind(src.data)]
+		if src == nil {
+			return fmt.Errorf("failed to store env: reason=src register is empty")
+		}
+	}
+	return r.memory.SetAt(addr, src.Clone())
+}
+
+func (r *Runtime) doMakeClosure(dest, label, count *Object) error {
+	if dest.kind != OBJ_REGISTER {
+		return fmt.Errorf("unsupported make_closure value: reason=dest is not REGISTER: dest=%v", dest)
+	}
+	if label.kind != OBJ_LABEL {
+		return fmt.Errorf("unsupported make_closure value: reason=label is not LABEL: label=%v", label)
+	}
+	addr, err := r.memory.Alloc(count.data + 1)
+	if err != nil {
 		return err
 	}
-	_, err := fmt.Fprintf(f, src.StringData())
-	return err
+	if err := r.memory.SetAt(addr+1, NewFunctionObject(label.data)); err != nil {
+		return err
+	}
+	// キャプチャする値は先頭から順にpushされているので後ろから詰める
+	for i := count.data - 1; 0 <= i; i-- {
+		obj, err := r.stack.Pop()
+		if err != nil {
+			return err
+		}
+		if err := r.memory.SetAt(addr+envSlotOffset+i, obj); err != nil {
+			return err
+		}
+	}
+	return r.setRegister(RegisterKind(dest.data), NewClosureObject(addr))
 }
 
 func (r *Runtime) Load(program Program) error {
 	// main(l_0)を叩くコード, exit
 	// TODO: startupはコンパイラ側で挿入する
 	startup := Program{
-		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(-1)}, // process root label
-		&Operation{kind: OP_CALL, param1: NewLabelObject(0)},       // call main
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(startupLabel)}, // process root label
+		&Operation{kind: OP_CALL, param1: NewLabelObject(0)},                 // call main
 		&Operation{kind: OP_EXIT},
 	}
 	program = append(startup, program...)
 	r.setProgram(program)
+	r.code = decode(program)
+	// 前のプログラムのラベルが残らないようにする
+	r.symbolTable.Reset()
+	return nil
+}
+
+// Reset スタック・メモリ・レジスタ・ラベルを作った直後の状態に戻し，開いているファイルを閉じる
+// 入出力やHookなどオプションで指定したものはそのまま使う
+func (r *Runtime) Reset() error {
+	err := r.Close()
+	r.initFiles()
+	r.restoreMainThread()
+	r.threads, r.current, r.switching = nil, 0, false
+	r.channels = nil
+	r.stack.Reset()
+	r.memory.Reset()
+	clear(r.register)
+	r.register[REG_STACK_POINTER] = r.stack.sp
+	r.program, r.code = nil, nil
+	r.symbolTable.Reset()
+	r.executed = 0
+	return err
+}
+
+// Reload Resetしてからprogramを読み込み，ラベルを集める
+func (r *Runtime) Reload(program Program) error {
+	if err := r.Reset(); err != nil {
+		return err
+	}
+	if err := r.Load(program); err != nil {
+		return err
+	}
+	return r.CollectLabel()
+}
+
+// LoadObject シンボルの名前もエラーやデバッグの表示に使う
+func (r *Runtime) LoadObject(obj *ObjectFile) error {
+	if err := r.Load(obj.Program); err != nil {
+		return err
+	}
+	r.symbolTable.SetNames(obj.Symbols)
 	return nil
 }
 
 func (r *Runtime) CollectLabel() error {
-	for pc, op := range r.program {
+	return r.collectLabel(0)
+}
+
+// Append 読み込んだプログラムの後ろに命令を足し，足した分のラベルを登録する
+// REPLのように少しずつコンパイルしたものをCallで続けて動かすときに使う
+// ラベルを登録できなければ何も足さない
+func (r *Runtime) Append(program Program) error {
+	start := len(r.program)
+	r.setProgram(append(slices.Clip(r.program), program...))
+	if err := r.collectLabel(start); err != nil {
+		for _, op := range r.program[start:] {
+			if op.kind == OP_DEF_LABEL && op.param1.kind == OBJ_LABEL {
+				if pc, _ := r.symbolTable.Get(op.param1.data); start <= pc {
+					r.symbolTable.Delete(op.param1.data)
+				}
+			}
+		}
+		r.setProgram(r.program[:start])
+		return err
+	}
+	r.code = decode(r.program)
+	return nil
+}
+
+// AppendObject Appendに加えてシンボルの名前も覚えておく
+func (r *Runtime) AppendObject(obj *ObjectFile) error {
+	if err := r.Append(obj.Program); err != nil {
+		return err
+	}
+	r.symbolTable.SetNames(obj.Symbols)
+	return nil
+}
+
+func (r *Runtime) collectLabel(start int) error {
+	r.symbolTable.SetLimit(r.program)
+	for pc := start; pc < len(r.program); pc++ {
+		op := r.program[pc]
 		if op.kind == OP_DEF_LABEL {
 			if op.param1.kind != OBJ_LABEL {
 				return fmt.Errorf("failed to collect label: failed to define label: reason=this is not label object: obj=%s", op.param1.String())
 			}
-			if err := r.symbolTable.Set("l_"+strconv.Itoa(op.param1.data), pc); err != nil {
+			if err := r.symbolTable.Set(op.param1.data, pc); err != nil {
 				return err
 			}
 		}
@@ -386,99 +694,141 @@ func (r *Runtime) CollectLabel() error {
 	return nil
 }
 
+// ErrFuelExhausted WithFuelで指定した命令数を使い切った
+var ErrFuelExhausted = errors.New("fuel exhausted")
+
+// cancelCheckInterval この命令数ごとにcontextが終わっていないかを見る
+const cancelCheckInterval = 1024
+
 func (r *Runtime) Run() error {
-	entryPointAddress, err := r.symbolTable.Get("l_-1")
+	return r.RunContext(context.Background())
+}
+
+// RunContext ctxがキャンセルされるかタイムアウトすると止まってctx.Err()を返す
+// 確認は命令の合間にしか行わないので，SYSCALL_READで入力を待っている間は止まらない
+func (r *Runtime) RunContext(ctx context.Context) error {
+	if err := r.start(); err != nil {
+		return err
+	}
+	return r.loop(ctx)
+}
+
+// Call labelの関数を引数なしで呼び，戻ってくるまで動かす
+// Runと違ってENVとメモリ，チャネルは前の実行の状態を引き継ぐので，Appendで足したプログラムを続けて動かせる
+// エラーで止まったときは，メインスレッドに戻してENVを呼ぶ前のものにする
+func (r *Runtime) Call(ctx context.Context, label int) error {
+	pc, err := r.symbolTable.Get(label)
 	if err != nil {
 		return err
 	}
-	r.setPC(entryPointAddress)
+	r.restoreMainThread()
+	env := r.register[REG_ENV]
+	if env == nil {
+		env = NewNullObject()
+	}
+	r.startThreads()
+	r.stack.Reset()
+	if err := r.stack.Push(NewReferenceObject(startupExitPC)); err != nil {
+		return err
+	}
+	r.setPC(pc)
 	r.setStatus(STAT_SUCCESS)
-programLoop:
+	r.register[REG_ENV] = env
+	r.executed = 0
+	if err := r.loop(ctx); err != nil {
+		r.restoreMainThread()
+		r.register[REG_ENV] = env
+		return err
+	}
+	return nil
+}
+
+// loop EXITするかエラーになるまで命令を実行する
+func (r *Runtime) loop(ctx context.Context) error {
 	for {
-		switch curtOp := r.consumeOp(); {
-		case curtOp.kind == OP_EXIT: // EXIT
-			break programLoop
-		case curtOp.kind == OP_MOVE: // MOVE $DEST $SRC
-			if err := r.doMove(curtOp.param1, curtOp.param2); err != nil {
+		if r.executed%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
 				r.setStatus(STAT_ERR)
 				return err
 			}
-		case curtOp.kind == OP_PUSH:
-			if err := r.doPush(curtOp.param1); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_POP:
-			if err := r.doPop(curtOp.param1); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_CALL:
-			if err := r.doCall(curtOp.param1); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_RETURN:
-			if err := r.doReturn(); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_ADD: // ADD $DEST $SRC
-			if err := r.doAdd(curtOp.param1, curtOp.param2); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_SUB: // SUB $DEST $SRC
-			if err := r.doSub(curtOp.param1, curtOp.param2); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_JUMP: // JUMP $LABEL
-			if err := r.doJump(curtOp.param1); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_DEF_LABEL: // DEF_LABEL $LABEL_NO
-			continue
-		case curtOp.kind == OP_EQ: // EQ $OBJ1 $OBJ2
-			if err := r.doEq(curtOp.param1, curtOp.param2); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_NE: // NE $OBJ1 $OBJ2
-			if err := r.doNe(curtOp.param1, curtOp.param2); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_LT: // LT $OBJ1 $OBJ2
-			if err := r.doLt(curtOp.param1, curtOp.param2); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_LE: // LE $OBJ1 $OBJ2
-			if err := r.doLe(curtOp.param1, curtOp.param2); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_JUMP_TRUE: // JUMP_TRUE $LABEL_NO
-			if err := r.doJumpTrue(curtOp.param1); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_JUMP_FALSE: // JUMP_TRUE $LABEL_NO
-			if err := r.doJumpFalse(curtOp.param1); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		case curtOp.kind == OP_SYSCALL_WRITE:
-			if err := r.doSyscallWrite(curtOp.param1, curtOp.param2); err != nil {
-				r.setStatus(STAT_ERR)
-				return err
-			}
-		default:
+		}
+		if 0 < r.fuel && r.fuel <= r.executed {
 			r.setStatus(STAT_ERR)
-			return fmt.Errorf("unsupported Op: %s", curtOp.kind.String())
+			return fmt.Errorf("%w: executed=%d", ErrFuelExhausted, r.executed)
+		}
+		exited, err := r.step()
+		r.executed++
+		if err != nil {
+			return err
+		}
+		if exited {
+			return nil
 		}
 	}
+}
+
+// InstructionCount 直前のRunで実行した命令の数
+func (r *Runtime) InstructionCount() int {
+	return r.executed
+}
+
+func (r *Runtime) start() error {
+	entryPointAddress, err := r.symbolTable.Get(startupLabel)
+	if err != nil {
+		return err
+	}
+	r.startThreads()
+	r.channels = nil
+	r.setPC(entryPointAddress)
+	r.setStatus(STAT_SUCCESS)
+	r.register[REG_ENV] = NewNullObject()
+	r.executed = 0
 	return nil
 }
+
+// step 1命令を実行する, EXITならexitedがtrueになる
+// Hookが登録されていれば前後で呼ぶ
+// スレッドの切り替えはHookを呼んだ後に行う
+func (r *Runtime) step() (bool, error) {
+	var exited bool
+	var err error
+	if len(r.hooks) == 0 {
+		exited, err = r.execute()
+	} else {
+		pc := r.register[REG_PROGRAM_COUNTER].data
+		if pc < 0 || len(r.program) <= pc {
+			r.setStatus(STAT_ERR)
+			return false, fmt.Errorf("failed to execute: reason=pc is out of program: pc=%d, size=%d", pc, len(r.program))
+		}
+		op := r.program[pc]
+		for _, hook := range r.hooks {
+			hook.BeforeOp(r, pc, op)
+		}
+		exited, err = r.execute()
+		for _, hook := range r.hooks {
+			hook.AfterOp(r, pc, op, err)
+		}
+	}
+	if err == nil && r.switching {
+		if err = r.schedule(); err != nil {
+			r.setStatus(STAT_ERR)
+		}
+	}
+	return exited, err
+}
+
+// execute Loadで選んでおいた関数で1命令を実行する
+func (r *Runtime) execute() (exited bool, err error) {
+	pc := r.register[REG_PROGRAM_COUNTER].data
+	if pc < 0 || len(r.code) <= pc {
+		r.setStatus(STAT_ERR)
+		return false, fmt.Errorf("failed to execute: reason=pc is out of program: pc=%d, size=%d", pc, len(r.code))
+	}
+	ins := &r.code[pc]
+	r.advance()
+	exited, err = ins.handler(r, ins)
+	if err != nil {
+		r.setStatus(STAT_ERR)
+	}
+	return exited, err
+}
@@ -2,11 +2,14 @@ package runtime
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
-	"os"
+	"io"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestRuntime_Run_Exit(t *testing.T) {
@@ -37,8 +40,6 @@ func TestRuntime_Run_Move(t *testing.T) {
 	assert.Equal(t, STAT_ERR, Status(runtime.register[REG_STATUS].data))
 	assert.Equal(t, nil, err)
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
 		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_STATUS), param2: NewObject(999)},
@@ -50,8 +51,6 @@ func TestRuntime_Run_Move(t *testing.T) {
 	assert.Equal(t, 999, runtime.register[REG_STATUS].data)
 	assert.Equal(t, nil, err)
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
 		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(888)},
@@ -64,8 +63,6 @@ func TestRuntime_Run_Move(t *testing.T) {
 	assert.Equal(t, 888, runtime.register[REG_STATUS].data)
 	assert.Equal(t, nil, err)
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
 		&Operation{kind: OP_MOVE, param1: NewObject(1), param2: NewObject(1)},
@@ -77,8 +74,6 @@ func TestRuntime_Run_Move(t *testing.T) {
 	assert.Equal(t, STAT_ERR, Status(runtime.register[REG_STATUS].data))
 	assert.Equal(t, fmt.Errorf("unsupported move value: reason=dest is nor REGISTER, REFERENCE: dest=%d", 1), err)
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
 		&Operation{kind: OP_MOVE, param1: NewReferenceObject(2), param2: NewObject(999)},
@@ -125,8 +120,6 @@ func TestRuntime_Run_Pop(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, NewObject(1), runtime.register[REG_GENERAL_1])
 	// remove main
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	// popで上書き
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
@@ -144,8 +137,6 @@ func TestRuntime_Run_Pop(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, NewObject(3), runtime.register[REG_GENERAL_1])
 	// remove main
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	// G2に足してく
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
@@ -270,8 +261,6 @@ func TestRuntime_Run_Eq(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, NewObject(true), runtime.register[REG_BOOL_FLAG])
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)}, // main:
 		&Operation{kind: OP_EQ, param1: NewObject(99), param2: NewObject(100)},
@@ -297,8 +286,6 @@ func TestRuntime_Run_Ne(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, NewObject(false), runtime.register[REG_BOOL_FLAG])
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)}, // main:
 		&Operation{kind: OP_NE, param1: NewObject(99), param2: NewObject(100)},
@@ -324,8 +311,6 @@ func TestRuntime_Run_Lt(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, NewObject(false), runtime.register[REG_BOOL_FLAG])
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)}, // main:
 		&Operation{kind: OP_LT, param1: NewObject(100), param2: NewObject(99)},
@@ -337,8 +322,6 @@ func TestRuntime_Run_Lt(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, NewObject(false), runtime.register[REG_BOOL_FLAG])
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)}, // main:
 		&Operation{kind: OP_LT, param1: NewObject(99), param2: NewObject(100)},
@@ -364,8 +347,6 @@ func TestRuntime_Run_Le(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, NewObject(true), runtime.register[REG_BOOL_FLAG])
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)}, // main:
 		&Operation{kind: OP_LE, param1: NewObject(100), param2: NewObject(99)},
@@ -377,8 +358,6 @@ func TestRuntime_Run_Le(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, NewObject(false), runtime.register[REG_BOOL_FLAG])
 
-	runtime.symbolTable.Delete("l_0")
-	runtime.symbolTable.Delete("l_-1")
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)}, // main:
 		&Operation{kind: OP_LE, param1: NewObject(99), param2: NewObject(100)},
@@ -439,13 +418,115 @@ func TestRuntime_Run_JumpFalse(t *testing.T) {
 	assert.Equal(t, NewObject(1), runtime.register[REG_GENERAL_2])
 }
 
-func TestRuntime_Run_SyscallWrite(t *testing.T) {
-	// 参考: https://chantsune.github.io/articles/320/
-	tmpStdout := os.Stdout // 標準出力を元に戻せるように保存
-	r, w, _ := os.Pipe()
-	os.Stdout = w // 標準出力の書き込み先を変更
+func TestRuntime_Run_CallRegister(t *testing.T) {
+	runtime := NewRuntime(3, 3)
+	_ = runtime.Load(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(1)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_2), param2: NewObject(5)},
+		&Operation{kind: OP_RETURN},
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewFunctionObject(1)},
+		&Operation{kind: OP_CALL, param1: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_RETURN},
+	})
+	err := runtime.CollectLabel()
+	assert.Nil(t, err)
+	err = runtime.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, NewObject(5), runtime.register[REG_GENERAL_2])
 
-	runtime := NewRuntime(1, 2)
+	_ = runtime.Load(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(1)},
+		&Operation{kind: OP_CALL, param1: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_RETURN},
+	})
+	err = runtime.CollectLabel()
+	assert.Nil(t, err)
+	err = runtime.Run()
+	assert.Equal(t, fmt.Errorf("unsupported call value: reason=dest is nor LABEL, FUNCTION, CLOSURE: dest=%v", 1), err)
+}
+
+func TestRuntime_Run_Closure(t *testing.T) {
+	runtime := NewRuntime(10, 20)
+	_ = runtime.Load(Program{
+		// make_adder(l_1):
+		//   enter 1
+		//   pop ra; pop t1; store_env 0 0 t1; push ra // x = 引数
+		//   load_env g1 0 0; push g1
+		//   make_closure g1 adder(l_2) 1 // xをキャプチャ
+		//   move status g1
+		//   leave
+		//   ret
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(1)},
+		&Operation{kind: OP_ENTER, param1: NewObject(1)},
+		&Operation{kind: OP_POP, param1: NewRegisterObject(REG_RETURN_ADDRESS)},
+		&Operation{kind: OP_POP, param1: NewRegisterObject(REG_TEMP_1)},
+		&Operation{kind: OP_STORE_ENV, param1: NewObject(0), param2: NewObject(0), param3: NewRegisterObject(REG_TEMP_1)},
+		&Operation{kind: OP_PUSH, param1: NewRegisterObject(REG_RETURN_ADDRESS)},
+		&Operation{kind: OP_LOAD_ENV, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(0), param3: NewObject(0)},
+		&Operation{kind: OP_PUSH, param1: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_MAKE_CLOSURE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewLabelObject(2), param3: NewObject(1)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_STATUS), param2: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_LEAVE},
+		&Operation{kind: OP_RETURN},
+
+		// adder(l_2):
+		//   enter 1
+		//   pop ra; pop t1; store_env 0 0 t1; push ra // y = 引数
+		//   load_env g1 1 0 // キャプチャしたx
+		//   load_env g2 0 0 // y
+		//   add g1 g2
+		//   move status g1
+		//   leave
+		//   ret
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(2)},
+		&Operation{kind: OP_ENTER, param1: NewObject(1)},
+		&Operation{kind: OP_POP, param1: NewRegisterObject(REG_RETURN_ADDRESS)},
+		&Operation{kind: OP_POP, param1: NewRegisterObject(REG_TEMP_1)},
+		&Operation{kind: OP_STORE_ENV, param1: NewObject(0), param2: NewObject(0), param3: NewRegisterObject(REG_TEMP_1)},
+		&Operation{kind: OP_PUSH, param1: NewRegisterObject(REG_RETURN_ADDRESS)},
+		&Operation{kind: OP_LOAD_ENV, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(1), param3: NewObject(0)},
+		&Operation{kind: OP_LOAD_ENV, param1: NewRegisterObject(REG_GENERAL_2), param2: NewObject(0), param3: NewObject(0)},
+		&Operation{kind: OP_ADD, param1: NewRegisterObject(REG_GENERAL_1), param2: NewRegisterObject(REG_GENERAL_2)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_STATUS), param2: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_LEAVE},
+		&Operation{kind: OP_RETURN},
+
+		// main(l_0):
+		//   push 30
+		//   call make_adder(l_1)
+		//   move g1 status
+		//   push env // 呼び出し側で環境を保存する
+		//   push 12
+		//   call g1
+		//   pop env
+		//   ret
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_PUSH, param1: NewObject(30)},
+		&Operation{kind: OP_CALL, param1: NewLabelObject(1)},
+		&Operation{kind: OP_MOVE, param1: NewRegisterObject(REG_GENERAL_1), param2: NewRegisterObject(REG_STATUS)},
+		&Operation{kind: OP_PUSH, param1: NewRegisterObject(REG_ENV)},
+		&Operation{kind: OP_PUSH, param1: NewObject(12)},
+		&Operation{kind: OP_CALL, param1: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_POP, param1: NewRegisterObject(REG_ENV)},
+		&Operation{kind: OP_RETURN},
+	})
+	err := runtime.CollectLabel()
+	assert.Nil(t, err)
+	err = runtime.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, NewObject(42), runtime.register[REG_STATUS])
+	assert.Equal(t, NewNullObject(), runtime.register[REG_ENV])
+	// クロージャの環境(list(2), function, x)だけがヒープに残っている
+	assert.Equal(t, NewListObject(2), runtime.memory.GetAt(14))
+	assert.Equal(t, NewFunctionObject(2), runtime.memory.GetAt(15))
+	assert.Equal(t, NewObject(30), runtime.memory.GetAt(16))
+}
+
+func TestRuntime_Run_SyscallWrite(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	runtime := NewRuntime(1, 2, WithStdout(&stdout), WithStderr(&stderr))
 	_ = runtime.Load(Program{
 		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
 		&Operation{kind: OP_SYSCALL_WRITE, param1: NewObject(STD_OUT), param2: NewObject('h')},
@@ -464,29 +545,62 @@ func TestRuntime_Run_SyscallWrite(t *testing.T) {
 		&Operation{kind: OP_SYSCALL_WRITE, param1: NewObject(STD_OUT), param2: NewObject(30)},
 		&Operation{kind: OP_SYSCALL_WRITE, param1: NewObject(STD_OUT), param2: NewNullObject()},
 		&Operation{kind: OP_SYSCALL_WRITE, param1: NewObject(STD_OUT), param2: NewObject('\n')},
+		&Operation{kind: OP_SYSCALL_WRITE, param1: NewObject(STD_ERR), param2: NewObject('e')},
 		&Operation{kind: OP_RETURN},
 	})
 	err := runtime.CollectLabel()
 	assert.Equal(t, nil, err)
 	err = runtime.Run()
 	assert.Equal(t, nil, err)
-
-	_ = w.Close()
-	var buf bytes.Buffer
-	_, _ = buf.ReadFrom(r)
-	s := strings.TrimRight(buf.String(), "") // バッファーから文字列へ変換
-	os.Stdout = tmpStdout
-	assert.Equal(t, "hello,world!true30null\n", s)
+	assert.Equal(t, "hello,world!true30null\n", stdout.String())
+	assert.Equal(t, "e", stderr.String())
 }
 
-func TestRuntime_Run_FizzBuzz(t *testing.T) {
-	// 参考: https://chantsune.github.io/articles/320/
-	tmpStdout := os.Stdout // 標準出力を元に戻せるように保存
-	r, w, _ := os.Pipe()
-	os.Stdout = w // 標準出力の書き込み先を変更
+func TestRuntime_Run_SyscallRead(t *testing.T) {
+	runtime := NewRuntime(1, 20, WithStdin(strings.NewReader("a42\nhello, world\n 7 \nlast")))
+	_ = runtime.Load(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_SYSCALL_READ, param1: NewObject(STD_IN), param2: NewObject(READ_CHAR), param3: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_SYSCALL_READ, param1: NewObject(STD_IN), param2: NewObject(READ_INT), param3: NewReferenceObject(0)},
+		&Operation{kind: OP_SYSCALL_READ, param1: NewObject(STD_IN), param2: NewObject(READ_LINE), param3: NewRegisterObject(REG_GENERAL_2)},
+		&Operation{kind: OP_SYSCALL_READ, param1: NewObject(STD_IN), param2: NewObject(READ_INT), param3: NewReferenceObject(1)},
+		&Operation{kind: OP_SYSCALL_READ, param1: NewObject(STD_IN), param2: NewObject(READ_LINE), param3: NewReferenceObject(2)},
+		&Operation{kind: OP_RETURN},
+	})
+	err := runtime.CollectLabel()
+	assert.Nil(t, err)
+	err = runtime.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, NewObject('a'), runtime.register[REG_GENERAL_1])
+	assert.Equal(t, NewObject(42), runtime.memory.GetAt(0))
+	assert.Equal(t, NewObject(7), runtime.memory.GetAt(1))
+	// 改行で終わっていない最後の行も読める
+	str, ok := runtime.stringAt(2)
+	assert.True(t, ok)
+	assert.Equal(t, "last", str)
+	// ヒープに確保された文字列
+	str, ok = runtime.stringAt(runtime.register[REG_GENERAL_2].data)
+	assert.True(t, ok)
+	assert.Equal(t, "hello, world", str)
+	assert.Equal(t, NewObject(true), runtime.register[REG_BOOL_FLAG])
 
-	runtime := NewRuntime(100, 100)
+	// EOF
 	_ = runtime.Load(Program{
+		&Operation{kind: OP_DEF_LABEL, param1: NewLabelObject(0)},
+		&Operation{kind: OP_SYSCALL_READ, param1: NewObject(STD_IN), param2: NewObject(READ_LINE), param3: NewRegisterObject(REG_GENERAL_1)},
+		&Operation{kind: OP_RETURN},
+	})
+	err = runtime.CollectLabel()
+	assert.Nil(t, err)
+	err = runtime.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, NewNullObject(), runtime.register[REG_GENERAL_1])
+	assert.Equal(t, NewObject(false), runtime.register[REG_BOOL_FLAG])
+}
+
+// newFizzBuzzProgram 1から100までのfizzbuzzを出力する
+func newFizzBuzzProgram() Program {
+	return Program{
 		// check_x15(l_1):
 		//   push g1 // fizzbuzzのメインの数字であるg1の保存
 		// loop_c15(l_2):
@@ -664,17 +778,19 @@ func TestRuntime_Run_FizzBuzz(t *testing.T) {
 		&Operation{kind: OP_EQ, param1: NewRegisterObject(REG_GENERAL_1), param2: NewObject(101)},
 		&Operation{kind: OP_JUMP_FALSE, param1: NewLabelObject(17)},
 		&Operation{kind: OP_RETURN},
-	})
+	}
+}
+
+func TestRuntime_Run_FizzBuzz(t *testing.T) {
+	var stdout bytes.Buffer
+	runtime := NewRuntime(100, 100, WithStdout(&stdout))
+	_ = runtime.Load(newFizzBuzzProgram())
 	err := runtime.CollectLabel()
 	assert.Nil(t, err)
 	err = runtime.Run()
 	assert.Nil(t, err)
 
-	_ = w.Close()
-	var buf bytes.Buffer
-	_, _ = buf.ReadFrom(r)
-	s := strings.TrimRight(buf.String(), "") // バッファーから文字列へ変換
-	os.Stdout = tmpStdout
+	s := stdout.String()
 
 	// PYTHON
 	//for i in range(1, 101):
@@ -790,3 +906,349 @@ func TestRuntime_Run_FizzBuzz(t *testing.T) {
 `
 	assert.Equal(t, fizzbuzz, s)
 }
+
+func TestRuntime_RunContext(t *testing.T) {
+	loop := Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewJumpOp(NewLabelObject(0)),
+	}
+
+	// 命令数の上限
+	runtime := NewRuntime(1, 1, WithFuel(100))
+	_ = runtime.Load(loop)
+	assert.Nil(t, runtime.CollectLabel())
+	err := runtime.Run()
+	assert.ErrorIs(t, err, ErrFuelExhausted)
+	assert.Equal(t, 100, runtime.InstructionCount())
+	assert.Equal(t, NewObject(int(STAT_ERR)), runtime.register[REG_STATUS])
+
+	// タイムアウト
+	runtime = NewRuntime(1, 1)
+	_ = runtime.Load(loop)
+	assert.Nil(t, runtime.CollectLabel())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, runtime.RunContext(ctx), context.DeadlineExceeded)
+
+	// キャンセル済みなら1命令も実行しない
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, runtime.RunContext(ctx), context.Canceled)
+	assert.Equal(t, 0, runtime.InstructionCount())
+}
+
+func TestRuntime_Run_Malformed(t *testing.T) {
+	// 壊れたプログラムでもpanicせずにエラーで止まる
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	tests := []struct {
+		name string
+		op   *Operation
+		err  string
+	}{
+		{"pc", NewMoveOp(NewRegisterObject(REG_PROGRAM_COUNTER), NewObject(9999)), "failed to execute: reason=pc is out of program: pc=9999, size=6"},
+		{"src address", NewMoveOp(g1, NewReferenceObject(5000)), "failed to move value: reason=src address is out of memory: reference(5000), size=10"},
+		{"dest address", NewMoveOp(NewReferenceObject(-1), NewObject(1)), "failed to move value: reason=dest address is out of memory: reference(-1), size=10"},
+		{"empty register", NewMoveOp(g1, NewRegisterObject(REG_GENERAL_2)), "failed to move value: reason=src register is empty: register(GENERAL_2)"},
+		{"add empty register", NewAddOp(g1, NewRegisterObject(REG_GENERAL_2)), "failed to add: reason=src register is empty: register(GENERAL_2)"},
+		{"sub empty register", NewSubOp(NewRegisterObject(REG_GENERAL_2), NewObject(1)), "failed to sub: reason=dest register is empty: register(GENERAL_2)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := NewRuntime(10, 10)
+			assert.Nil(t, runtime.Reload(Program{
+				NewDefLabelOp(NewLabelObject(0)),
+				NewMoveOp(g1, NewObject(0)),
+				tt.op,
+			}))
+			assert.EqualError(t, runtime.Run(), tt.err)
+			assert.Equal(t, NewObject(int(STAT_ERR)), runtime.register[REG_STATUS])
+		})
+	}
+}
+
+func TestRuntime_InstructionCount(t *testing.T) {
+	runtime := NewRuntime(1, 1, WithFuel(6))
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewObject(1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	// DEF_LABEL -1, CALL, DEF_LABEL 0, MOVE, RETURN, EXIT
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, 6, runtime.InstructionCount())
+}
+
+func TestRuntime_Run_Peek(t *testing.T) {
+	runtime := NewRuntime(4, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewPushOp(NewObject(10)),
+		NewPushOp(NewObject(20)),
+		NewPeekOp(NewRegisterObject(REG_GENERAL_1), NewObject(0)), // g1 = 20
+		NewMoveOp(NewRegisterObject(REG_TEMP_1), NewObject(1)),
+		NewPeekOp(NewRegisterObject(REG_GENERAL_2), NewRegisterObject(REG_TEMP_1)), // g2 = 10
+		NewPopOp(NewRegisterObject(REG_TEMP_1)),
+		NewPopOp(NewRegisterObject(REG_TEMP_1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewObject(20), runtime.register[REG_GENERAL_1])
+	assert.Equal(t, NewObject(10), runtime.register[REG_GENERAL_2])
+
+	// 積まれていない位置は読めない
+	runtime = NewRuntime(4, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewPeekOp(NewRegisterObject(REG_GENERAL_1), NewObject(1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "failed to peek stack: reason=offset is out of stack: offset=1, depth=1")
+}
+
+func TestRuntime_Run_StackPointer(t *testing.T) {
+	runtime := NewRuntime(4, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewRegisterObject(REG_STACK_POINTER)), // g1 = 1 (戻り先)
+		NewPushOp(NewObject(10)),
+		NewPushOp(NewObject(20)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_2), NewRegisterObject(REG_STACK_POINTER)), // g2 = 3
+		NewSubOp(NewRegisterObject(REG_STACK_POINTER), NewObject(1)),                      // 20を捨てる
+		NewPopOp(NewRegisterObject(REG_TEMP_1)),                                           // t1 = 10
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewObject(1), runtime.register[REG_GENERAL_1])
+	assert.Equal(t, NewObject(3), runtime.register[REG_GENERAL_2])
+	assert.Equal(t, NewObject(10), runtime.register[REG_TEMP_1])
+	assert.Equal(t, NewObject(0), runtime.register[REG_STACK_POINTER])
+
+	// MOVEで書き換えてもスタックと共有したまま
+	runtime = NewRuntime(4, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewPushOp(NewObject(10)),
+		NewMoveOp(NewRegisterObject(REG_STACK_POINTER), NewObject(1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, 0, runtime.stack.Depth())
+
+	runtime = NewRuntime(4, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_STACK_POINTER), NewObject(5)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "failed to set stack pointer: reason=out of stack: sp=5, size=4")
+}
+
+func TestRuntime_Run_StackOverflow(t *testing.T) {
+	runtime := NewRuntime(3, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewCallOp(NewLabelObject(0)), // 再帰が止まらない
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "failed to push to stack: reason=stack overflow: depth=3, size=3")
+}
+
+func BenchmarkRuntime_Run_FizzBuzz(b *testing.B) {
+	prog := newFizzBuzzProgram()
+	for i := 0; i < b.N; i++ {
+		runtime := NewRuntime(100, 100, WithStdout(io.Discard))
+		_ = runtime.Load(prog)
+		if err := runtime.CollectLabel(); err != nil {
+			b.Fatal(err)
+		}
+		if err := runtime.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRuntime_Run_Loop 命令の振り分けとジャンプだけのループ
+func BenchmarkRuntime_Run_Loop(b *testing.B) {
+	prog := Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewObject(0)),
+		NewDefLabelOp(NewLabelObject(1)),
+		NewAddOp(NewRegisterObject(REG_GENERAL_1), NewObject(1)),
+		NewLtOp(NewRegisterObject(REG_GENERAL_1), NewObject(10000)),
+		NewJumpTrueOp(NewLabelObject(1)),
+		NewReturnOp(),
+	}
+	for i := 0; i < b.N; i++ {
+		runtime := NewRuntime(10, 1)
+		_ = runtime.Load(prog)
+		if err := runtime.CollectLabel(); err != nil {
+			b.Fatal(err)
+		}
+		if err := runtime.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRuntime_Load_ResetLabels(t *testing.T) {
+	// Loadし直すと前のプログラムのラベルは残らない
+	runtime := NewRuntime(2, 1)
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewReturnOp(),
+		NewDefLabelOp(NewLabelObject(1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+
+	_ = runtime.LoadObject(NewObjectFile(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewFunctionObject(1)),
+		NewCallOp(NewRegisterObject(REG_GENERAL_1)),
+		NewReturnOp(),
+	}, map[string]int{"main": 0, "helper": 1}))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "failed to get symbol: not registered: helper")
+}
+
+func TestRuntime_Reset(t *testing.T) {
+	runtime := NewRuntime(4, 10, WithFS(ReadOnlyFS(fstest.MapFS{"a.txt": {Data: []byte("a")}})))
+	assert.Nil(t, runtime.storeString(NewReferenceObject(0), "a.txt"))
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewSyscallOpenOp(NewReferenceObject(0), NewObject(OPEN_READ), NewRegisterObject(REG_GENERAL_1)),
+		NewPushOp(NewObject(1)),
+		NewEnterOp(NewObject(1)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_2), NewObject(2)),
+		NewPopOp(NewRegisterObject(REG_GENERAL_1)), // 戻り先が無くなって止まる
+		NewPopOp(NewRegisterObject(REG_GENERAL_1)),
+		NewReturnOp(),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.NotNil(t, runtime.Run())
+
+	assert.Nil(t, runtime.Reset())
+	assert.Equal(t, 3, len(runtime.files))
+	assert.Equal(t, 0, runtime.stack.Depth())
+	assert.Equal(t, NewMemory(10), runtime.memory)
+	for kind, obj := range runtime.register {
+		if RegisterKind(kind) == REG_STACK_POINTER {
+			assert.Equal(t, NewObject(0), obj)
+			continue
+		}
+		assert.Nil(t, obj)
+	}
+	assert.Equal(t, 0, runtime.InstructionCount())
+	_, err := runtime.symbolTable.Get(0)
+	assert.NotNil(t, err)
+	assert.NotNil(t, runtime.Run())
+
+	// スタックポインタはレジスタと共有したまま
+	assert.Nil(t, runtime.stack.Push(NewObject(1)))
+	assert.Equal(t, NewObject(1), runtime.register[REG_STACK_POINTER])
+}
+
+func TestRuntime_Reload(t *testing.T) {
+	runtime := NewRuntime(4, 4)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, runtime.Reload(Program{
+			NewDefLabelOp(NewLabelObject(0)),
+			NewEnterOp(NewObject(2)), // 前の実行で確保したままだと足りなくなる
+			NewMoveOp(NewRegisterObject(REG_STATUS), NewObject(i)),
+			NewPushOp(NewObject(i)),
+			NewPopOp(NewRegisterObject(REG_GENERAL_1)),
+			NewReturnOp(),
+		}))
+		assert.Nil(t, runtime.Run())
+		assert.Equal(t, NewObject(i), runtime.register[REG_STATUS])
+	}
+
+	// ラベルが二重に定義されていればエラー
+	err := runtime.Reload(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewDefLabelOp(NewLabelObject(0)),
+	})
+	assert.EqualError(t, err, "failed to set symbol: already registered: l_0")
+}
+
+func TestRuntime_AppendCall(t *testing.T) {
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	runtime := NewRuntime(10, 20)
+	assert.Nil(t, runtime.Reload(Program{}))
+
+	// ENTERしたフレームはCallから戻っても残る
+	assert.Nil(t, runtime.Append(Program{
+		NewDefLabelOp(NewLabelObject(1)),
+		NewEnterOp(NewObject(1)),
+		NewReturnOp(),
+	}))
+	assert.Nil(t, runtime.Call(context.Background(), 1))
+	env := runtime.register[REG_ENV]
+	assert.Equal(t, OBJ_REFERENCE, env.kind)
+
+	assert.Nil(t, runtime.Append(Program{
+		NewDefLabelOp(NewLabelObject(2)),
+		NewStoreEnvOp(NewObject(0), NewObject(0), NewObject(5)),
+		NewReturnOp(),
+		NewDefLabelOp(NewLabelObject(3)),
+		NewLoadEnvOp(g1, NewObject(0), NewObject(0)),
+		NewAddOp(g1, NewObject(1)),
+		NewMoveOp(NewRegisterObject(REG_STATUS), g1),
+		NewReturnOp(),
+	}))
+	assert.Nil(t, runtime.Call(context.Background(), 2))
+	assert.Nil(t, runtime.Call(context.Background(), 3))
+	assert.Equal(t, NewObject(6), runtime.register[REG_STATUS])
+
+	// エラーで止まってもENVは呼ぶ前に戻る
+	assert.Nil(t, runtime.Append(Program{
+		NewDefLabelOp(NewLabelObject(4)),
+		NewEnterOp(NewObject(1)),
+		NewCallOp(NewLabelObject(99)),
+	}))
+	assert.EqualError(t, runtime.Call(context.Background(), 4), "failed to get symbol: not registered: l_99")
+	assert.Equal(t, env, runtime.register[REG_ENV])
+
+	// ラベルが重なっていれば何も足さない
+	size := len(runtime.program)
+	assert.EqualError(t, runtime.Append(Program{
+		NewDefLabelOp(NewLabelObject(5)),
+		NewDefLabelOp(NewLabelObject(3)),
+	}), "failed to set symbol: already registered: l_3")
+	assert.Equal(t, size, len(runtime.program))
+	_, err := runtime.symbolTable.Get(5)
+	assert.NotNil(t, err)
+	assert.Nil(t, runtime.Call(context.Background(), 3))
+	assert.Equal(t, NewObject(6), runtime.register[REG_STATUS])
+
+	assert.EqualError(t, runtime.Call(context.Background(), 7), "failed to get symbol: not registered: l_7")
+}
+
+func TestRuntime_Run_AddStackPointer(t *testing.T) {
+	sp := NewRegisterObject(REG_STACK_POINTER)
+	g1 := NewRegisterObject(REG_GENERAL_1)
+
+	// 積まれていない位置はnullになる
+	a := NewAsm()
+	a.Def("main").Add(sp, NewObject(2)).Pop(g1).Sub(sp, NewObject(1)).Move(NewRegisterObject(REG_STATUS), g1).Return()
+	runtime := NewRuntime(10, 10)
+	assert.Nil(t, runtime.LoadObject(a.Object()))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewNullObject(), runtime.register[REG_STATUS])
+
+	// スタックの外には動かせない
+	a = NewAsm()
+	a.Def("main").Add(sp, NewObject(100)).Return()
+	runtime = NewRuntime(10, 10)
+	assert.Nil(t, runtime.LoadObject(a.Object()))
+	assert.Nil(t, runtime.CollectLabel())
+	assert.EqualError(t, runtime.Run(), "failed to set stack pointer: reason=out of stack: sp=101, size=10")
+}
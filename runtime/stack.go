@@ -2,41 +2,93 @@ package runtime
 
 import "fmt"
 
+// Stack spは次にpushする位置で，積まれている値の数と同じ
+// spのオブジェクトはレジスタのSTACK_POINTERと共有する
 type Stack struct {
 	objects []*Object
+	sp      *Object
 }
 
 func NewStack(size int) *Stack {
-	s := Stack{objects: make([]*Object, size)}
+	s := Stack{objects: make([]*Object, size), sp: NewObject(0)}
 	return &s
 }
 
+// Push nilはnullとして積む
 func (s *Stack) Push(obj *Object) error {
-	if len(s.objects) == 0 {
-		return fmt.Errorf("failed to push to stack: reason=no space in stack: size=%v", len(s.objects))
+	sp := s.sp.data
+	if sp < 0 || len(s.objects) <= sp {
+		return fmt.Errorf("failed to push to stack: reason=stack overflow: depth=%d, size=%d", sp, len(s.objects))
 	}
-
-	for i := 0; i < len(s.objects); i++ {
-		if s.objects[i] == nil {
-			s.objects[i] = obj.Clone()
-			return nil
-		}
+	if obj == nil {
+		obj = NewNullObject()
 	}
-	return fmt.Errorf("failed to push to stack: reason=no space in stack: size=%v", len(s.objects))
+	s.objects[sp] = obj.Clone()
+	s.sp.data++
+	return nil
 }
 func (s *Stack) Pop() (*Object, error) {
-	if len(s.objects) == 0 {
-		return nil, fmt.Errorf("failed to pop to stack: reason=stack has no item")
-	}
-	for i := len(s.objects) - 1; 0 <= i; i-- {
-		if s.objects[i] != nil {
-			obj := s.objects[i].Clone()
-			s.objects[i] = nil
-			return obj, nil
+	sp := s.sp.data
+	if sp <= 0 || len(s.objects) < sp {
+		return nil, fmt.Errorf("failed to pop from stack: reason=stack underflow: depth=%d, size=%d", sp, len(s.objects))
+	}
+	obj := s.objects[sp-1]
+	if obj == nil {
+		return nil, fmt.Errorf("failed to pop from stack: reason=slot is empty: depth=%d", sp)
+	}
+	s.objects[sp-1] = nil
+	s.sp.data--
+	return obj, nil
+}
+
+// Peek 上からoffset番目の値を取り除かずに返す, 0なら一番上
+func (s *Stack) Peek(offset int) (*Object, error) {
+	sp := s.sp.data
+	if offset < 0 || sp-offset <= 0 || len(s.objects) < sp {
+		return nil, fmt.Errorf("failed to peek stack: reason=offset is out of stack: offset=%d, depth=%d", offset, sp)
+	}
+	obj := s.objects[sp-1-offset]
+	if obj == nil {
+		return nil, fmt.Errorf("failed to peek stack: reason=slot is empty: offset=%d, depth=%d", offset, sp)
+	}
+	return obj, nil
+}
+
+// SetPointer spを動かす, 積まれていない位置の値はnullになる
+// 下げたときはPopと同じく外れた値を消すので，上げ直しても前の値は戻らない
+func (s *Stack) SetPointer(sp int) error {
+	if sp < 0 || len(s.objects) < sp {
+		return fmt.Errorf("failed to set stack pointer: reason=out of stack: sp=%d, size=%d", sp, len(s.objects))
+	}
+	if sp < s.sp.data {
+		clear(s.objects[sp:min(s.sp.data, len(s.objects))])
+	}
+	for i := s.sp.data; i < sp; i++ {
+		if s.objects[i] == nil {
+			s.objects[i] = NewNullObject()
 		}
 	}
-	return nil, fmt.Errorf("failed to pop to stack: reason=stack item not found")
+	s.sp.data = sp
+	return nil
+}
+
+// Reset 空にする, spのオブジェクトはそのまま使う
+func (s *Stack) Reset() {
+	clear(s.objects)
+	s.sp.data = 0
 }
+
 func (s *Stack) GetSize() int {
 	return len(s.objects)
 }
+
+// Depth 積まれている値の数
+func (s *Stack) Depth() int {
+	return s.sp.data
+}
+
+// Items 積まれている値を底から順に
+func (s *Stack) Items() []*Object {
+	sp := min(max(s.sp.data, 0), len(s.objects))
+	return s.objects[:sp]
+}
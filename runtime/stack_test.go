@@ -6,28 +6,32 @@ import (
 )
 
 func TestNewStack(t *testing.T) {
-	assert.Equal(t, NewStack(1), &Stack{make([]*Object, 1)})
-	assert.Equal(t, NewStack(10), &Stack{make([]*Object, 10)})
+	assert.Equal(t, NewStack(1), &Stack{objects: make([]*Object, 1), sp: NewObject(0)})
+	assert.Equal(t, NewStack(10), &Stack{objects: make([]*Object, 10), sp: NewObject(0)})
 }
 
 func TestStack_GetSize(t *testing.T) {
-	stack := Stack{make([]*Object, 1)}
+	stack := NewStack(1)
 	assert.Equal(t, stack.GetSize(), 1)
 	_ = stack.Push(NewNullObject())
 	assert.Equal(t, stack.GetSize(), 1)
 }
 
 func TestStack_Push(t *testing.T) {
-	stack := Stack{make([]*Object, 2)}
+	stack := NewStack(2)
 	assert.Equal(t, stack.GetSize(), 2)
 	_ = stack.Push(NewNullObject())
 	assert.Equal(t, stack.GetSize(), 2)
 	_ = stack.Push(NewNullObject())
 	assert.Equal(t, stack.GetSize(), 2)
+	assert.Equal(t, 2, stack.Depth())
+	err := stack.Push(NewObject(1))
+	assert.EqualError(t, err, "failed to push to stack: reason=stack overflow: depth=2, size=2")
+	assert.Equal(t, 2, stack.Depth())
 }
 
 func TestStack_Pop(t *testing.T) {
-	stack := Stack{make([]*Object, 3)}
+	stack := NewStack(3)
 	err := stack.Push(NewObject('a'))
 	assert.Equal(t, nil, err)
 	pop, err := stack.Pop()
@@ -43,4 +47,61 @@ func TestStack_Pop(t *testing.T) {
 	pop, err = stack.Pop()
 	assert.Equal(t, nil, err)
 	assert.Equal(t, pop.String(), "true")
+	_, err = stack.Pop()
+	assert.EqualError(t, err, "failed to pop from stack: reason=stack underflow: depth=0, size=3")
+}
+
+func TestStack_PushNull(t *testing.T) {
+	stack := NewStack(2)
+	assert.Nil(t, stack.Push(nil))
+	assert.Nil(t, stack.Push(NewNullObject()))
+	pop, err := stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "null", pop.String())
+	pop, err = stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "null", pop.String())
+}
+
+func TestStack_Peek(t *testing.T) {
+	stack := NewStack(3)
+	_ = stack.Push(NewObject(1))
+	_ = stack.Push(NewObject(2))
+	peek, err := stack.Peek(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", peek.String())
+	peek, err = stack.Peek(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", peek.String())
+	assert.Equal(t, 2, stack.Depth())
+	_, err = stack.Peek(2)
+	assert.EqualError(t, err, "failed to peek stack: reason=offset is out of stack: offset=2, depth=2")
+	_, err = stack.Peek(-1)
+	assert.EqualError(t, err, "failed to peek stack: reason=offset is out of stack: offset=-1, depth=2")
+}
+
+func TestStack_SetPointer(t *testing.T) {
+	stack := NewStack(3)
+	_ = stack.Push(NewObject(1))
+	_ = stack.Push(NewObject(2))
+	assert.Nil(t, stack.SetPointer(1))
+	assert.Equal(t, 1, stack.Depth())
+	assert.Equal(t, []*Object{NewObject(1)}, stack.Items())
+	assert.Nil(t, stack.SetPointer(3))
+	// 下げたときに外れた2は戻らず，積まれていない位置はnullになる
+	assert.Equal(t, []*Object{NewObject(1), NewNullObject(), NewNullObject()}, stack.Items())
+	assert.EqualError(t, stack.SetPointer(4), "failed to set stack pointer: reason=out of stack: sp=4, size=3")
+	assert.EqualError(t, stack.SetPointer(-1), "failed to set stack pointer: reason=out of stack: sp=-1, size=3")
+	assert.Equal(t, 3, stack.Depth())
+}
+
+func TestStack_EmptySlot(t *testing.T) {
+	// spを直接書き換えられても，積まれていない位置は読めない
+	stack := NewStack(3)
+	stack.sp.data = 2
+	_, err := stack.Peek(0)
+	assert.EqualError(t, err, "failed to peek stack: reason=slot is empty: offset=0, depth=2")
+	_, err = stack.Pop()
+	assert.EqualError(t, err, "failed to pop from stack: reason=slot is empty: depth=2")
+	assert.Equal(t, 2, stack.Depth())
 }
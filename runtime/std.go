@@ -5,3 +5,17 @@ var (
 	STD_OUT = 2
 	STD_ERR = 3
 )
+
+// SYSCALL_READで何を読むか
+var (
+	READ_CHAR = 1 // 1文字
+	READ_LINE = 2 // 改行までの1行, 改行は含まない
+	READ_INT  = 3 // 1行を整数として
+)
+
+// SYSCALL_OPENでどう開くか
+var (
+	OPEN_READ   = 1 // 読み込み
+	OPEN_WRITE  = 2 // 書き込み, なければ作りあれば空にする
+	OPEN_APPEND = 3 // 末尾への書き込み, なければ作る
+)
@@ -1,34 +1,105 @@
 package runtime
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// startupLabel Loadで先頭に足すstartupのラベル
+const startupLabel = -1
+
+// labelAllowance プログラムに現れるラベルの数を超えて使える番号の数
+// コンパイラ，アセンブラとリンカの出力には要らないが，手で番号を書いた.sのために少しだけ余裕を持たせる
+const labelAllowance = 1024
 
 func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{
-		symbols: make(map[string]int),
+		names: make(map[int]string),
+		limit: labelAllowance,
 	}
 }
 
+// SymbolTable ラベル番号から定義しているPCを引く
+// ラベルはstartupの-1から順に振られるので，番号+1を添字にした配列で持つ
 type SymbolTable struct {
-	symbols map[string]int
+	pcs   []int          // 未定義は-1
+	names map[int]string // デバッグ用の名前, なければl_1のように表示する
+	limit int            // Setできるラベル番号の上限, SetLimitで決める
 }
 
-func (s *SymbolTable) Get(name string) (int, error) {
-	v, ok := s.symbols[name]
-	if !ok {
-		return v, fmt.Errorf("failed to get symbol: not registered: %s", name)
+func (s *SymbolTable) index(label int) int {
+	return label - startupLabel
+}
+
+func (s *SymbolTable) Get(label int) (int, error) {
+	i := s.index(label)
+	if i < 0 || len(s.pcs) <= i || s.pcs[i] < 0 {
+		return -1, fmt.Errorf("failed to get symbol: not registered: %s", s.Name(label))
 	}
-	return v, nil
+	return s.pcs[i], nil
 }
 
-func (s *SymbolTable) Set(name string, addr int) error {
-	_, err := s.Get(name)
-	if err == nil {
-		return fmt.Errorf("failed to set symbol: already registered: %s", name)
+// SetLimit programに現れるラベルの数から，Setできるラベル番号の上限を決める
+// コンパイラ，アセンブラとリンカはラベルをstartupの-1から詰めて振るので，
+// 正しいプログラムのラベル番号は，定義や参照に現れる異なるラベルの数より小さい
+// 命令の数によらないので，ラベルが多く命令が少ないプログラムでも上限に当たらない
+// .sや.bcに書かれた大きすぎる番号で，表を確保しきれなくならないようにするためのもの
+func (s *SymbolTable) SetLimit(program Program) {
+	labels := make(map[int]bool)
+	for _, op := range program {
+		for _, param := range op.Params() {
+			switch param.kind {
+			case OBJ_LABEL, OBJ_FUNCTION, OBJ_HOST:
+				labels[param.data] = true
+			}
+		}
+	}
+	s.limit = len(labels) + labelAllowance
+}
+
+func (s *SymbolTable) Set(label int, pc int) error {
+	i := s.index(label)
+	if i < 0 || s.limit < label {
+		return fmt.Errorf("failed to set symbol: reason=label is out of range: %s, limit=%d", s.Name(label), s.limit)
+	}
+	if _, err := s.Get(label); err == nil {
+		return fmt.Errorf("failed to set symbol: already registered: %s", s.Name(label))
+	}
+	if len(s.pcs) <= i {
+		n := len(s.pcs)
+		s.pcs = slices.Grow(s.pcs, i+1-n)[:i+1]
+		for j := n; j < i; j++ {
+			s.pcs[j] = -1
+		}
 	}
-	s.symbols[name] = addr
+	s.pcs[i] = pc
 	return nil
 }
 
-func (s *SymbolTable) Delete(name string) {
-	delete(s.symbols, name)
+func (s *SymbolTable) Delete(label int) {
+	if i := s.index(label); 0 <= i && i < len(s.pcs) {
+		s.pcs[i] = -1
+	}
+}
+
+// Reset 登録したラベルと名前をすべて消す
+func (s *SymbolTable) Reset() {
+	s.pcs = s.pcs[:0]
+	clear(s.names)
+}
+
+// SetNames アセンブラやリンカが作った名前を覚えておく
+func (s *SymbolTable) SetNames(symbols map[string]int) {
+	for name, label := range symbols {
+		s.names[label] = name
+	}
+}
+
+// Name ラベルの名前, 名前がなければl_1のようにする
+func (s *SymbolTable) Name(label int) string {
+	if name, ok := s.names[label]; ok {
+		return name
+	}
+	return "l_" + strconv.Itoa(label)
 }
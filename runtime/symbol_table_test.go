@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSymbolTable(t *testing.T) {
+	s := NewSymbolTable()
+	assert.Nil(t, s.Set(startupLabel, 0))
+	assert.Nil(t, s.Set(3, 10))
+	pc, err := s.Get(3)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, pc)
+	pc, err = s.Get(startupLabel)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pc)
+
+	// 間の番号や範囲外は未定義
+	_, err = s.Get(1)
+	assert.EqualError(t, err, "failed to get symbol: not registered: l_1")
+	_, err = s.Get(100)
+	assert.EqualError(t, err, "failed to get symbol: not registered: l_100")
+	_, err = s.Get(-2)
+	assert.EqualError(t, err, "failed to get symbol: not registered: l_-2")
+
+	assert.EqualError(t, s.Set(3, 11), "failed to set symbol: already registered: l_3")
+	assert.EqualError(t, s.Set(-2, 0), "failed to set symbol: reason=label is out of range: l_-2, limit=1024")
+
+	// 上限はプログラムに現れる異なるラベルの数で決まり，大きすぎる番号は表を広げずにエラーにする
+	s.SetLimit(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewCallOp(NewLabelObject(1)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewFunctionObject(2)),
+		NewCallHostOp(NewHostObject(3), NewObject(0)),
+		NewCallOp(NewLabelObject(1)),
+	})
+	assert.Nil(t, s.Set(1028, 1))
+	assert.EqualError(t, s.Set(1099511627776, 0), "failed to set symbol: reason=label is out of range: l_1099511627776, limit=1028")
+	assert.Equal(t, 1030, len(s.pcs))
+
+	s.Delete(3)
+	_, err = s.Get(3)
+	assert.NotNil(t, err)
+	assert.Nil(t, s.Set(3, 12))
+}
+
+func TestSymbolTable_Names(t *testing.T) {
+	s := NewSymbolTable()
+	s.SetNames(map[string]int{"main": 0, "fib": 2})
+	assert.Equal(t, "main", s.Name(0))
+	assert.Equal(t, "l_1", s.Name(1))
+	_, err := s.Get(2)
+	assert.EqualError(t, err, "failed to get symbol: not registered: fib")
+
+	assert.Nil(t, s.Set(0, 1))
+	s.Reset()
+	_, err = s.Get(0)
+	assert.EqualError(t, err, "failed to get symbol: not registered: l_0")
+}
@@ -0,0 +1,182 @@
+package runtime
+
+import "fmt"
+
+// startupExitPC LoadがつけるstartupのEXIT
+// SPAWNしたスレッドは関数からここに戻って終わる
+const startupExitPC = 2
+
+// thread SPAWNで作る軽量スレッド
+// レジスタとスタックはスレッドごとに持ち，メモリは全スレッドで共有する
+// 0番はRunを始めたメインスレッドで，メインスレッドがEXITすると他のスレッドが残っていても終わる
+type thread struct {
+	id       int
+	register Register
+	stack    *Stack
+	done     bool
+	result   *Object     // 終わったときのSTATUS
+	waiting  func() bool // nilでなければ，trueを返すまで動かさない
+}
+
+// startThreads 今のレジスタとスタックをメインスレッドにする
+func (r *Runtime) startThreads() {
+	r.restoreMainThread()
+	r.threads = []*thread{{id: 0, register: r.register, stack: r.stack}}
+	r.current = 0
+	r.switching = false
+}
+
+// restoreMainThread 他のスレッドを動かしている途中で止まっていても，メインスレッドのレジスタとスタックに戻す
+func (r *Runtime) restoreMainThread() {
+	if len(r.threads) != 0 {
+		r.register = r.threads[0].register
+		r.stack = r.threads[0].stack
+	}
+}
+
+func (r *Runtime) newThread() *thread {
+	t := &thread{
+		id:       len(r.threads),
+		register: NewRegister(),
+		stack:    NewStack(r.stack.GetSize()),
+	}
+	t.register[REG_STACK_POINTER] = t.stack.sp
+	r.threads = append(r.threads, t)
+	return t
+}
+
+// ThreadID 今動いているスレッドの番号, メインスレッドは0
+func (r *Runtime) ThreadID() int {
+	return r.current
+}
+
+// doExit メインスレッドならプログラムを終え，それ以外ならそのスレッドだけを終える
+// 終わったスレッドはJOINのために結果だけを残し，レジスタとスタックは手放す
+func (r *Runtime) doExit() bool {
+	if r.current == 0 {
+		return true
+	}
+	t := r.threads[r.current]
+	t.done = true
+	t.result = r.register[REG_STATUS].Clone()
+	t.register, t.stack = nil, nil
+	r.switching = true
+	return false
+}
+
+func (r *Runtime) doSpawn(dest, fn, argc *Object) error {
+	if dest.kind != OBJ_REGISTER {
+		return fmt.Errorf("unsupported spawn value: reason=dest is not REGISTER: dest=%v", dest)
+	}
+	if argc.kind != OBJ_INT || argc.data < 0 {
+		return fmt.Errorf("unsupported spawn value: reason=argc is not INT: argc=%v", argc)
+	}
+	if fn.kind == OBJ_REGISTER {
+		fn = r.register[RegisterKind(fn.data)]
+		if fn == nil {
+			return fmt.Errorf("unsupported spawn value: reason=register is empty")
+		}
+	}
+	labelNo, env := 0, NewNullObject()
+	switch fn.kind {
+	case OBJ_LABEL, OBJ_FUNCTION:
+		labelNo = fn.data
+	case OBJ_CLOSURE:
+		f, err := r.closureFunction(fn)
+		if err != nil {
+			return err
+		}
+		labelNo = f.data
+		env = NewReferenceObject(fn.data)
+	default:
+		return fmt.Errorf("unsupported spawn value: reason=fn is nor LABEL, FUNCTION, CLOSURE: fn=%v", fn)
+	}
+	pc, err := r.symbolTable.Get(labelNo)
+	if err != nil {
+		return err
+	}
+
+	// 引数は呼び出し元のスタックから新しいスレッドのスタックに同じ順で移す
+	if r.stack.Depth() < argc.data {
+		return fmt.Errorf("failed to spawn: reason=not enough arguments on stack: argc=%d, depth=%d", argc.data, r.stack.Depth())
+	}
+	args := make([]*Object, argc.data)
+	for i := argc.data - 1; 0 <= i; i-- {
+		arg, err := r.stack.Pop()
+		if err != nil {
+			return err
+		}
+		args[i] = arg
+	}
+	t := r.newThread()
+	for _, arg := range args {
+		if err := t.stack.Push(arg); err != nil {
+			return err
+		}
+	}
+	// CALLと同じく戻り先を積んでおく
+	if err := t.stack.Push(NewReferenceObject(startupExitPC)); err != nil {
+		return err
+	}
+	t.register[REG_PROGRAM_COUNTER] = NewObject(pc)
+	t.register[REG_STATUS] = NewObject(int(STAT_SUCCESS))
+	t.register[REG_ENV] = env
+	return r.setRegister(RegisterKind(dest.data), NewThreadObject(t.id))
+}
+
+func (r *Runtime) doYield() error {
+	r.switching = true
+	return nil
+}
+
+// doJoin threadが終わっていなければ，終わるまで待ってからJOINをやり直す
+func (r *Runtime) doJoin(dest, th *Object) error {
+	if dest.kind != OBJ_REGISTER {
+		return fmt.Errorf("unsupported join value: reason=dest is not REGISTER: dest=%v", dest)
+	}
+	if th.kind == OBJ_REGISTER {
+		th = r.register[RegisterKind(th.data)]
+	}
+	if th == nil || th.kind != OBJ_THREAD || th.data < 0 || len(r.threads) <= th.data {
+		return fmt.Errorf("unsupported join value: reason=thread is not THREAD: thread=%v", th)
+	}
+	t := r.threads[th.data]
+	if t.id == r.current {
+		return fmt.Errorf("failed to join: reason=deadlock: thread joins itself: thread=%v", th)
+	}
+	if !t.done {
+		r.retry(func() bool { return t.done })
+		return nil
+	}
+	return r.setRegister(RegisterKind(dest.data), t.result.Clone())
+}
+
+// block readyがtrueを返すまで今のスレッドを止める
+func (r *Runtime) block(ready func() bool) {
+	r.threads[r.current].waiting = ready
+	r.switching = true
+}
+
+// retry 今の命令を，readyがtrueを返してからやり直す
+func (r *Runtime) retry(ready func() bool) {
+	r.setPC(r.register[REG_PROGRAM_COUNTER].data - 1)
+	r.block(ready)
+}
+
+// schedule 今のスレッドの次から順に，動かせるスレッドを探して切り替える
+// 命令の切れ目でしか切り替えないので，同じプログラムなら毎回同じ順に動く
+func (r *Runtime) schedule() error {
+	r.switching = false
+	for i := 1; i <= len(r.threads); i++ {
+		t := r.threads[(r.current+i)%len(r.threads)]
+		if t.done || (t.waiting != nil && !t.waiting()) {
+			continue
+		}
+		t.waiting = nil
+		r.current = t.id
+		r.register = t.register
+		r.stack = t.stack
+		return nil
+	}
+	return fmt.Errorf("failed to schedule: reason=deadlock: all threads are blocked: threads=%d", len(r.threads))
+}
@@ -0,0 +1,152 @@
+package runtime
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newWorkerProgram worker(c)はcを3回書き，書くたびにYIELDする
+func newWorkerProgram() Program {
+	g1 := NewRegisterObject(REG_GENERAL_1)
+	g2 := NewRegisterObject(REG_GENERAL_2)
+	ra := NewRegisterObject(REG_RETURN_ADDRESS)
+	return Program{
+		// main
+		NewDefLabelOp(NewLabelObject(0)),
+		NewPushOp(NewObject('a')),
+		NewSpawnOp(g1, NewLabelObject(1), NewObject(1)),
+		NewPushOp(NewObject('b')),
+		NewSpawnOp(g2, NewFunctionObject(1), NewObject(1)),
+		NewJoinOp(g1, g1),
+		NewJoinOp(g2, g2),
+		NewReturnOp(),
+		// worker
+		NewDefLabelOp(NewLabelObject(1)),
+		NewPopOp(ra),
+		NewPopOp(g1),
+		NewPushOp(ra),
+		NewSyscallWriteOp(NewObject(STD_OUT), g1),
+		NewYieldOp(),
+		NewSyscallWriteOp(NewObject(STD_OUT), g1),
+		NewYieldOp(),
+		NewSyscallWriteOp(NewObject(STD_OUT), g1),
+		NewMoveOp(NewRegisterObject(REG_STATUS), g1),
+		NewReturnOp(),
+	}
+}
+
+func TestRuntime_Run_Thread(t *testing.T) {
+	var stdout bytes.Buffer
+	runtime := NewRuntime(10, 1, WithStdout(&stdout))
+	assert.Nil(t, runtime.Reload(newWorkerProgram()))
+	assert.Nil(t, runtime.Run())
+	// メインスレッドがJOINで待っている間，2つのスレッドが交互に動く
+	assert.Equal(t, "ababab", stdout.String())
+	assert.Equal(t, NewObject('a'), runtime.register[REG_GENERAL_1])
+	assert.Equal(t, NewObject('b'), runtime.register[REG_GENERAL_2])
+	assert.Equal(t, 3, len(runtime.threads))
+	assert.Equal(t, 0, runtime.stack.Depth())
+	// 終わったスレッドは結果だけを残す
+	for _, th := range runtime.threads[1:] {
+		assert.True(t, th.done)
+		assert.Nil(t, th.stack)
+		assert.Nil(t, th.register)
+	}
+
+	// 何度動かしても同じ順になる
+	stdout.Reset()
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, "ababab", stdout.String())
+}
+
+func TestRuntime_Run_ThreadClosure(t *testing.T) {
+	// キャプチャした変数を新しいスレッドから読む
+	runtime := NewRuntime(10, 10)
+	assert.Nil(t, runtime.Reload(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewPushOp(NewObject(42)),
+		NewMakeClosureOp(NewRegisterObject(REG_GENERAL_1), NewLabelObject(1), NewObject(1)),
+		NewSpawnOp(NewRegisterObject(REG_GENERAL_2), NewRegisterObject(REG_GENERAL_1), NewObject(0)),
+		NewJoinOp(NewRegisterObject(REG_STATUS), NewRegisterObject(REG_GENERAL_2)),
+		NewReturnOp(),
+		NewDefLabelOp(NewLabelObject(1)),
+		NewLoadEnvOp(NewRegisterObject(REG_STATUS), NewObject(0), NewObject(0)),
+		NewReturnOp(),
+	}))
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, NewObject(42), runtime.register[REG_STATUS])
+}
+
+func TestRuntime_Run_ThreadMainExit(t *testing.T) {
+	// メインスレッドが終われば，終わっていないスレッドがあっても終わる
+	var stdout bytes.Buffer
+	runtime := NewRuntime(10, 1, WithStdout(&stdout))
+	assert.Nil(t, runtime.Reload(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewSpawnOp(NewRegisterObject(REG_GENERAL_1), NewLabelObject(1), NewObject(0)),
+		NewYieldOp(),
+		NewSyscallWriteOp(NewObject(STD_OUT), NewObject('m')),
+		NewReturnOp(),
+		NewDefLabelOp(NewLabelObject(1)),
+		NewSyscallWriteOp(NewObject(STD_OUT), NewObject('t')),
+		NewYieldOp(),
+		NewJumpOp(NewLabelObject(1)),
+	}))
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, "tm", stdout.String())
+}
+
+func TestRuntime_Run_ThreadDeadlock(t *testing.T) {
+	runtime := NewRuntime(10, 1)
+	assert.Nil(t, runtime.Reload(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewSpawnOp(NewRegisterObject(REG_GENERAL_1), NewLabelObject(1), NewObject(0)),
+		NewJoinOp(NewRegisterObject(REG_GENERAL_1), NewRegisterObject(REG_GENERAL_1)),
+		NewReturnOp(),
+		NewDefLabelOp(NewLabelObject(1)),
+		NewJoinOp(NewRegisterObject(REG_GENERAL_1), NewThreadObject(0)),
+		NewReturnOp(),
+	}))
+	assert.EqualError(t, runtime.Run(), "failed to schedule: reason=deadlock: all threads are blocked: threads=2")
+	assert.Equal(t, NewObject(int(STAT_ERR)), runtime.register[REG_STATUS])
+
+	// 止まったのが他のスレッドでも，Resetすればメインスレッドに戻る
+	assert.Nil(t, runtime.Reset())
+	assert.Nil(t, runtime.threads)
+	assert.Equal(t, runtime.stack.sp, runtime.register[REG_STACK_POINTER])
+
+	assert.Nil(t, runtime.Reload(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewJoinOp(NewRegisterObject(REG_GENERAL_1), NewThreadObject(0)),
+		NewReturnOp(),
+	}))
+	assert.EqualError(t, runtime.Run(), "failed to join: reason=deadlock: thread joins itself: thread=thread(0)")
+}
+
+func TestRuntime_Run_SpawnErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   *Operation
+		err  string
+	}{
+		{"dest", NewSpawnOp(NewObject(1), NewLabelObject(0), NewObject(0)), "unsupported spawn value: reason=dest is not REGISTER: dest=1"},
+		{"fn", NewSpawnOp(NewRegisterObject(REG_GENERAL_1), NewObject(1), NewObject(0)), "unsupported spawn value: reason=fn is nor LABEL, FUNCTION, CLOSURE: fn=1"},
+		{"undefined", NewSpawnOp(NewRegisterObject(REG_GENERAL_1), NewLabelObject(5), NewObject(0)), "failed to get symbol: not registered: l_5"},
+		{"args", NewSpawnOp(NewRegisterObject(REG_GENERAL_1), NewLabelObject(0), NewObject(2)), "failed to spawn: reason=not enough arguments on stack: argc=2, depth=0"},
+		{"huge args", NewSpawnOp(NewRegisterObject(REG_GENERAL_1), NewLabelObject(0), NewObject(1000000000000)), "failed to spawn: reason=not enough arguments on stack: argc=1000000000000, depth=0"},
+		{"join", NewJoinOp(NewRegisterObject(REG_GENERAL_1), NewThreadObject(3)), "unsupported join value: reason=thread is not THREAD: thread=thread(3)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := NewRuntime(2, 1)
+			assert.Nil(t, runtime.Reload(Program{
+				NewDefLabelOp(NewLabelObject(0)),
+				NewPopOp(NewRegisterObject(REG_RETURN_ADDRESS)),
+				tt.op,
+				NewReturnOp(),
+			}))
+			assert.EqualError(t, runtime.Run(), tt.err)
+		})
+	}
+}
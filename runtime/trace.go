@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Hook Runの各命令の前後に呼ばれる
+// opを実行する前のpcが渡される, AfterOpのerrはその命令が失敗したときのエラー
+type Hook interface {
+	BeforeOp(r *Runtime, pc int, op *Operation)
+	AfterOp(r *Runtime, pc int, op *Operation, err error)
+}
+
+// Register レジスタの中身
+func (r *Runtime) Register(kind RegisterKind) *Object {
+	return r.register[kind]
+}
+
+// Registers 全てのレジスタの中身, RegisterKindの順に並ぶ
+func (r *Runtime) Registers() Register {
+	return slices.Clone(r.register)
+}
+
+// registerChange 1命令で書き換わったレジスタ
+type registerChange struct {
+	Register RegisterKind
+	Before   *Object
+	After    *Object
+}
+
+// registerTracer 命令の前にレジスタを写しておき，後で比べる
+// PROGRAM_COUNTERは毎回変わるので比べない
+type registerTracer struct {
+	before Register
+}
+
+func (t *registerTracer) BeforeOp(r *Runtime, _ int, _ *Operation) {
+	if t.before == nil {
+		t.before = NewRegister()
+	}
+	for kind, obj := range r.register {
+		t.before[kind] = nil
+		if obj != nil {
+			t.before[kind] = obj.Clone()
+		}
+	}
+}
+
+func (t *registerTracer) changes(r *Runtime) []registerChange {
+	changes := []registerChange{}
+	for kind, after := range r.register {
+		if RegisterKind(kind) == REG_PROGRAM_COUNTER {
+			continue
+		}
+		before := t.before[kind]
+		if before == nil && after == nil || before != nil && after != nil && before.IsSame(after) {
+			continue
+		}
+		changes = append(changes, registerChange{Register: RegisterKind(kind), Before: before, After: after})
+	}
+	return changes
+}
+
+func objectString(obj *Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return obj.String()
+}
+
+type textTracer struct {
+	registerTracer
+	w io.Writer
+}
+
+// NewTextTracer 実行した命令と書き換わったレジスタを1行ずつ書く
+//
+//	pc=12 SUB register(GENERAL_1) 15 | GENERAL_1: 30 -> 15
+func NewTextTracer(w io.Writer) Hook {
+	return &textTracer{w: w}
+}
+
+func (t *textTracer) AfterOp(r *Runtime, pc int, op *Operation, err error) {
+	line := fmt.Sprintf("pc=%d %s", pc, op.String())
+	changes := []string{}
+	for _, c := range t.changes(r) {
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", c.Register.String(), objectString(c.Before), objectString(c.After)))
+	}
+	if len(changes) != 0 {
+		line += " | " + strings.Join(changes, ", ")
+	}
+	if err != nil {
+		line += " | error: " + err.Error()
+	}
+	_, _ = fmt.Fprintln(t.w, line)
+}
+
+type jsonTracer struct {
+	registerTracer
+	enc *json.Encoder
+}
+
+// NewJSONTracer 実行した命令を1行1つのJSONで書く
+//
+//	{"pc":12,"op":"SUB","params":["register(GENERAL_1)","15"],"changes":[{"register":"GENERAL_1","before":"30","after":"15"}]}
+func NewJSONTracer(w io.Writer) Hook {
+	return &jsonTracer{enc: json.NewEncoder(w)}
+}
+
+type jsonChange struct {
+	Register string `json:"register"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+type jsonTrace struct {
+	PC      int          `json:"pc"`
+	Op      string       `json:"op"`
+	Params  []string     `json:"params"`
+	Changes []jsonChange `json:"changes"`
+	Error   string       `json:"error,omitempty"`
+}
+
+func (t *jsonTracer) AfterOp(r *Runtime, pc int, op *Operation, err error) {
+	trace := jsonTrace{PC: pc, Op: op.kind.String(), Params: []string{}, Changes: []jsonChange{}}
+	for _, param := range op.params() {
+		if param != nil {
+			trace.Params = append(trace.Params, param.String())
+		}
+	}
+	for _, c := range t.changes(r) {
+		trace.Changes = append(trace.Changes, jsonChange{Register: c.Register.String(), Before: objectString(c.Before), After: objectString(c.After)})
+	}
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	_ = t.enc.Encode(trace)
+}
@@ -0,0 +1,80 @@
+package runtime
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func newTraceProgram() Program {
+	return Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewMoveOp(NewRegisterObject(REG_GENERAL_1), NewObject(30)),
+		NewSubOp(NewRegisterObject(REG_GENERAL_1), NewObject(15)),
+		NewReturnOp(),
+	}
+}
+
+func TestTextTracer(t *testing.T) {
+	var buf bytes.Buffer
+	runtime := NewRuntime(2, 1, WithHook(NewTextTracer(&buf)))
+	_ = runtime.Load(newTraceProgram())
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	assert.Equal(t, `pc=0 DEF_LABEL label(-1)
+pc=1 CALL label(0) | STACK_POINTER: 0 -> 1
+pc=3 DEF_LABEL label(0)
+pc=4 MOVE register(GENERAL_1) 30 | GENERAL_1: nil -> 30
+pc=5 SUB register(GENERAL_1) 15 | GENERAL_1: 30 -> 15
+pc=6 RETURN | STACK_POINTER: 1 -> 0
+pc=2 EXIT
+`, buf.String())
+
+	// 失敗した命令も書く
+	buf.Reset()
+	runtime = NewRuntime(2, 1, WithHook(NewTextTracer(&buf)))
+	_ = runtime.Load(Program{
+		NewDefLabelOp(NewLabelObject(0)),
+		NewPopOp(NewRegisterObject(REG_GENERAL_1)),
+		NewPopOp(NewRegisterObject(REG_GENERAL_1)),
+	})
+	assert.Nil(t, runtime.CollectLabel())
+	assert.NotNil(t, runtime.Run())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "pc=4 POP register(GENERAL_1) | GENERAL_1: nil -> reference(2), STACK_POINTER: 1 -> 0", lines[3])
+	assert.Equal(t, "pc=5 POP register(GENERAL_1) | STATUS: 0 -> 1 | error: failed to pop from stack: reason=stack underflow: depth=0, size=2", lines[4])
+}
+
+func TestJSONTracer(t *testing.T) {
+	var buf bytes.Buffer
+	runtime := NewRuntime(2, 1, WithHook(NewJSONTracer(&buf)))
+	_ = runtime.Load(newTraceProgram())
+	assert.Nil(t, runtime.CollectLabel())
+	assert.Nil(t, runtime.Run())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 7, len(lines))
+	assert.Equal(t, `{"pc":1,"op":"CALL","params":["label(0)"],"changes":[{"register":"STACK_POINTER","before":"0","after":"1"}]}`, lines[1])
+	assert.Equal(t, `{"pc":5,"op":"SUB","params":["register(GENERAL_1)","15"],"changes":[{"register":"GENERAL_1","before":"30","after":"15"}]}`, lines[4])
+}
+
+type countHook struct {
+	before, after int
+}
+
+func (h *countHook) BeforeOp(*Runtime, int, *Operation)       { h.before++ }
+func (h *countHook) AfterOp(*Runtime, int, *Operation, error) { h.after++ }
+
+func TestHook_Debugger(t *testing.T) {
+	// デバッガで1命令ずつ動かしてもHookが呼ばれる
+	hook := &countHook{}
+	runtime := NewRuntime(2, 1, WithHook(hook))
+	_ = runtime.Load(newTraceProgram())
+	assert.Nil(t, runtime.CollectLabel())
+	d := NewDebugger(runtime)
+	assert.Nil(t, d.Start())
+	assert.Nil(t, d.Step())
+	assert.Nil(t, d.Step())
+	assert.Equal(t, 2, hook.before)
+	assert.Equal(t, 2, hook.after)
+}